@@ -7,20 +7,82 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 )
 
 // Arguments
 const ARG_COUNT int = 2
 
 // Usage strings
-const USAGE_STRING = "./run-client.sh -d -f config_file.txt baseDir blockSize"
+const USAGE_STRING = "./run-client.sh -d -f config_file.txt -j concurrency -c chunking -z codec -e mode -n namespace -tls-ca file -tls-cert file -tls-key file [-verify] [-watch -poll interval | -history file | -restore file -version n] baseDir blockSize"
 
 const DEBUG_NAME = "d"
 const DEBUG_USAGE = "Output log statements"
 
 const CONFIG_NAME = "f config_file.txt"
-const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
+const CONFIG_USAGE = "Path to cluster config file (JSON, YAML or legacy format) that specifies addresses for all Raft nodes"
+
+const CONCURRENCY_NAME = "j concurrency"
+const CONCURRENCY_USAGE = "Number of files and of block streams transferred at the same time"
+
+const CHUNKING_NAME = "c chunking"
+const CHUNKING_USAGE = "How files are cut into blocks: fixed, or cdc for content-defined blocks that average blockSize bytes"
+
+const CHUNK_MIN_NAME = "min size"
+const CHUNK_MIN_USAGE = "Minimum block size in cdc mode (default blockSize/4)"
+
+const CHUNK_MAX_NAME = "max size"
+const CHUNK_MAX_USAGE = "Maximum block size in cdc mode (default blockSize*8)"
+
+const COMPRESSION_NAME = "z codec"
+const COMPRESSION_USAGE = "Compress uploaded blocks with gzip, snappy or zstd, if the BlockStore accepts it"
+
+// Compression flag value for uncompressed uploads
+const COMPRESSION_NONE = "none"
+
+const ENCRYPTION_NAME = "e mode"
+const ENCRYPTION_USAGE = "Encrypt blocks with the passphrase in $" + PASSPHRASE_ENV + ": convergent keeps deduplication, random hides equal blocks"
+
+const ENCRYPT_NAMES_NAME = "encrypt-names"
+const ENCRYPT_NAMES_USAGE = "Also encrypt file names, requires -e"
+
+const NAMESPACE_NAME = "n namespace"
+const NAMESPACE_USAGE = "Namespace to sync in, the first one the token grants if unset. The token is read from $" + TOKEN_ENV
+
+const TLS_CA_NAME = "tls-ca file"
+const TLS_CA_USAGE = "CA that signs the servers' certificates, overrides the config file"
+
+const TLS_CERT_NAME = "tls-cert file"
+const TLS_CERT_USAGE = "Certificate to present to servers that require one, overrides the config file"
+
+const TLS_KEY_NAME = "tls-key file"
+const TLS_KEY_USAGE = "Key of the -tls-cert certificate"
+
+const VERIFY_NAME = "verify"
+const VERIFY_USAGE = "Hash every file, even those whose size, mtime and inode match the index"
+
+const WATCH_NAME = "watch"
+const WATCH_USAGE = "Keep syncing local changes as they happen until interrupted"
+
+const POLL_NAME = "poll interval"
+const POLL_USAGE = "Time between checks for remote changes in -watch mode, 0 disables them"
+
+const HISTORY_NAME = "history file"
+const HISTORY_USAGE = "List the versions of file kept by the MetaStore instead of syncing"
+
+const RESTORE_NAME = "restore file"
+const RESTORE_USAGE = "Make the version of file given by -version its current version, then sync"
+
+const VERSION_NAME = "version n"
+const VERSION_USAGE = "Version restored by -restore"
+
+// Environment variable holding the encryption passphrase
+const PASSPHRASE_ENV = "SURFSTORE_PASSPHRASE"
+
+// Environment variable holding the token the client authenticates with
+const TOKEN_ENV = "SURFSTORE_TOKEN"
 
 const BASEDIR_NAME = "baseDir"
 const BASEDIR_USAGE = "Base directory of the client"
@@ -29,7 +91,13 @@ const BLOCK_NAME = "blockSize"
 const BLOCK_USAGE = "Size of the blocks used to fragment files"
 
 // Exit codes
+const EX_FAILURE int = 1
+// The sync saved local changes as conflicted copies
+const EX_CONFLICT int = 2
 const EX_USAGE int = 64
+// Another client changed a file during the sync, syncing again resolves it
+const EX_TEMPFAIL int = 75
+const EX_CONFIG int = 78
 
 func main() {
 	// Custom flag Usage message
@@ -38,6 +106,23 @@ func main() {
 		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
 		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
 		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v (default %d)\n", CONCURRENCY_NAME, CONCURRENCY_USAGE, surfstore.DEFAULT_SYNC_CONCURRENCY)
+		fmt.Fprintf(w, "  -%s: %v (default %s)\n", CHUNKING_NAME, CHUNKING_USAGE, surfstore.CHUNKING_FIXED)
+		fmt.Fprintf(w, "  -%s: %v\n", CHUNK_MIN_NAME, CHUNK_MIN_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CHUNK_MAX_NAME, CHUNK_MAX_USAGE)
+		fmt.Fprintf(w, "  -%s: %v (default %s)\n", COMPRESSION_NAME, COMPRESSION_USAGE, COMPRESSION_NONE)
+		fmt.Fprintf(w, "  -%s: %v\n", ENCRYPTION_NAME, ENCRYPTION_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", ENCRYPT_NAMES_NAME, ENCRYPT_NAMES_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", NAMESPACE_NAME, NAMESPACE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", TLS_CA_NAME, TLS_CA_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", TLS_CERT_NAME, TLS_CERT_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", TLS_KEY_NAME, TLS_KEY_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", VERIFY_NAME, VERIFY_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", WATCH_NAME, WATCH_USAGE)
+		fmt.Fprintf(w, "  -%s: %v (default %v)\n", POLL_NAME, POLL_USAGE, surfstore.DEFAULT_WATCH_POLL_INTERVAL)
+		fmt.Fprintf(w, "  -%s: %v\n", HISTORY_NAME, HISTORY_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", RESTORE_NAME, RESTORE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", VERSION_NAME, VERSION_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BASEDIR_NAME, BASEDIR_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BLOCK_NAME, BLOCK_USAGE)
 	}
@@ -45,20 +130,99 @@ func main() {
 	// Parse command-line arguments and flags
 	debug := flag.Bool("d", false, DEBUG_USAGE)
 	configFile := flag.String("f", "", "(required) Config file")
+	concurrency := flag.Int("j", surfstore.DEFAULT_SYNC_CONCURRENCY, CONCURRENCY_USAGE)
+	chunking := flag.String("c", surfstore.CHUNKING_FIXED, CHUNKING_USAGE)
+	minChunkSize := flag.Int("min", 0, CHUNK_MIN_USAGE)
+	maxChunkSize := flag.Int("max", 0, CHUNK_MAX_USAGE)
+	compression := flag.String("z", COMPRESSION_NONE, COMPRESSION_USAGE)
+	encryption := flag.String("e", "", ENCRYPTION_USAGE)
+	encryptNames := flag.Bool(ENCRYPT_NAMES_NAME, false, ENCRYPT_NAMES_USAGE)
+	namespace := flag.String("n", "", NAMESPACE_USAGE)
+	tlsCA := flag.String("tls-ca", "", TLS_CA_USAGE)
+	tlsCert := flag.String("tls-cert", "", TLS_CERT_USAGE)
+	tlsKey := flag.String("tls-key", "", TLS_KEY_USAGE)
+	verify := flag.Bool(VERIFY_NAME, false, VERIFY_USAGE)
+	watch := flag.Bool(WATCH_NAME, false, WATCH_USAGE)
+	poll := flag.Duration("poll", surfstore.DEFAULT_WATCH_POLL_INTERVAL, POLL_USAGE)
+	history := flag.String("history", "", HISTORY_USAGE)
+	restore := flag.String("restore", "", RESTORE_USAGE)
+	version := flag.Int("version", 0, VERSION_USAGE)
 	flag.Parse()
 
 	// Use tail arguments to hold non-flag arguments
 	args := flag.Args()
 
-	if len(args) != ARG_COUNT {
+	if len(args) != ARG_COUNT || *concurrency < 1 || (*history != "" && *restore != "") || (*watch && (*history != "" || *restore != "")) || *poll < 0 || (*restore != "") != (*version > 0) || (*namespace != "" && !surfstore.ValidNamespace(*namespace)) {
 		flag.Usage()
 		os.Exit(EX_USAGE)
 	}
-	addrs := surfstore.LoadRaftConfigFile(*configFile)
+	config, err := surfstore.LoadClusterConfig(*configFile)
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(EX_CONFIG)
+	}
+	addrs := config.ClientAddrs()
+	if *tlsCA != "" {
+		config.TLS.CAFile = *tlsCA
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		config.TLS.CertFile, config.TLS.KeyFile = *tlsCert, *tlsKey
+	}
+	// Only the Raft servers use the peer certificates
+	config.TLS.PeerCAFile, config.TLS.PeerCertFile, config.TLS.PeerKeyFile = "", "", ""
+	if err := config.TLS.Load(); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(EX_CONFIG)
+	}
 
 	baseDir := args[0]
 	blockSize, err := strconv.Atoi(args[1])
-	if err != nil {
+	if err != nil || blockSize < 1 {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if blockSize > surfstore.MAX_BLOCK_SIZE {
+		fmt.Fprintf(flag.CommandLine.Output(), "block size %d exceeds the BlockStores' limit of %d\n", blockSize, surfstore.MAX_BLOCK_SIZE)
+		os.Exit(EX_USAGE)
+	}
+
+	rpcClient := surfstore.NewSurfstoreRPCClient(addrs, baseDir, blockSize)
+	rpcClient.Timeout = config.RPCTimeout()
+	rpcClient.Concurrency = *concurrency
+	rpcClient.Chunking = *chunking
+	rpcClient.MinChunkSize = *minChunkSize
+	rpcClient.MaxChunkSize = *maxChunkSize
+	rpcClient.Verify = *verify
+	rpcClient.Token = os.Getenv(TOKEN_ENV)
+	rpcClient.Namespace = *namespace
+	rpcClient.TLS = &config.TLS
+	switch *chunking {
+	case surfstore.CHUNKING_FIXED:
+	case surfstore.CHUNKING_CDC:
+		if err := surfstore.ValidateChunkSizes(rpcClient.ChunkSizes()); err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			os.Exit(EX_USAGE)
+		}
+	default:
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	switch *compression {
+	case COMPRESSION_NONE:
+		rpcClient.Compression = surfstore.CODEC_NONE
+	case surfstore.CODEC_GZIP, surfstore.CODEC_SNAPPY, surfstore.CODEC_ZSTD:
+		rpcClient.Compression = *compression
+	default:
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if *encryption != "" {
+		rpcClient.Encryption, err = surfstore.NewBlockCipher(os.Getenv(PASSPHRASE_ENV), *encryption, *encryptNames)
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			os.Exit(EX_USAGE)
+		}
+	} else if *encryptNames {
 		flag.Usage()
 		os.Exit(EX_USAGE)
 	}
@@ -71,6 +235,64 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	rpcClient := surfstore.NewSurfstoreRPCClient(addrs, baseDir, blockSize)
-	surfstore.ClientSync(rpcClient)
+	switch {
+	case *history != "":
+		var versions []*surfstore.FileMetaData
+		if err := rpcClient.GetFileHistory(*history, &versions); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EX_FAILURE)
+		}
+		for _, fileMetaData := range versions {
+			hashes := fileMetaData.BlockHashList
+			switch {
+			case len(hashes) == 1 && hashes[0] == surfstore.TOMBSTONE_HASH:
+				fmt.Printf("%d deleted\n", fileMetaData.Version)
+			case len(hashes) == 1 && hashes[0] == surfstore.DIRECTORY_HASH:
+				fmt.Printf("%d directory\n", fileMetaData.Version)
+			default:
+				fmt.Printf("%d %d blocks\n", fileMetaData.Version, len(hashes))
+			}
+		}
+	case *restore != "":
+		if err := surfstore.ClientRestore(rpcClient, *restore, int32(*version)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EX_FAILURE)
+		}
+	case *watch:
+		watcher := surfstore.NewSyncWatcher(rpcClient, *poll, surfstore.DEFAULT_WATCH_DEBOUNCE)
+		watcher.OnSync = printSyncProblems
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			watcher.Stop()
+		}()
+		if err := watcher.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EX_FAILURE)
+		}
+	default:
+		stats, err := surfstore.ClientSync(rpcClient)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(EX_FAILURE)
+		}
+		printSyncProblems(stats)
+		if len(stats.Conflicts) > 0 {
+			os.Exit(EX_CONFLICT)
+		}
+		if len(stats.Rejected) > 0 {
+			os.Exit(EX_TEMPFAIL)
+		}
+	}
+}
+
+// printSyncProblems lists the files a sync could not sync as they were
+func printSyncProblems(stats surfstore.SyncStats) {
+	for _, fileName := range stats.Conflicts {
+		fmt.Fprintf(os.Stderr, "conflict: %s changed here and on the server, local changes kept as a conflicted copy\n", fileName)
+	}
+	for _, fileName := range stats.Rejected {
+		fmt.Fprintf(os.Stderr, "retry: %s was changed by another client during the sync\n", fileName)
+	}
 }
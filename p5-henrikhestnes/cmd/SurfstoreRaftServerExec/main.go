@@ -5,16 +5,53 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
+	"strings"
 )
 
 func main() {
 	serverId := flag.Int64("i", -1, "(required) Server ID")
 	configFile := flag.String("f", "", "(required) Config file, absolute path")
-	blockStoreAddr := flag.String("b", "", "(required) BlockStore address")
+	blockStoreAddrs := flag.String("b", "", "Comma separated BlockStore addresses, overrides the blockStores in the config file")
+	replicationFactor := flag.Int("r", 0, "Number of BlockStores each block is replicated to, overrides the config file")
 	debug := flag.Bool("d", false, "Output log statements")
+	tlsCA := flag.String("tls-ca", "", "CA that signs the servers' certificates, and the clients' with -mtls, overrides the config file")
+	tlsCert := flag.String("tls-cert", "", "Certificate served on the client address and used to call the BlockStores, overrides the config file")
+	tlsKey := flag.String("tls-key", "", "Key of the -tls-cert certificate")
+	mutualTLS := flag.Bool("mtls", false, "Only accept clients with a certificate signed by the -tls-ca CA")
+	peerCA := flag.String("peer-ca", "", "CA that signs the Raft servers' peer certificates, defaults to -tls-ca")
+	peerCert := flag.String("peer-cert", "", "Certificate served on the Raft address and used to call the other Raft servers, defaults to -tls-cert")
+	peerKey := flag.String("peer-key", "", "Key of the -peer-cert certificate")
 	flag.Parse()
 
-	addrs := surfstore.LoadRaftConfigFile(*configFile)
+	config, err := surfstore.LoadClusterConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *blockStoreAddrs != "" {
+		config.BlockStores = nil
+		for _, addr := range strings.Split(*blockStoreAddrs, ",") {
+			config.BlockStores = append(config.BlockStores, surfstore.BlockStoreConfig{Addr: addr})
+		}
+	}
+
+	if *replicationFactor > 0 {
+		config.ReplicationFactor = *replicationFactor
+	}
+	if *tlsCA != "" {
+		config.TLS.CAFile = *tlsCA
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		config.TLS.CertFile, config.TLS.KeyFile = *tlsCert, *tlsKey
+	}
+	if *mutualTLS {
+		config.TLS.Mutual = true
+	}
+	if *peerCA != "" {
+		config.TLS.PeerCAFile = *peerCA
+	}
+	if *peerCert != "" || *peerKey != "" {
+		config.TLS.PeerCertFile, config.TLS.PeerKeyFile = *peerCert, *peerKey
+	}
 
 	// Disable log outputs if debug flag is missing
 	if !(*debug) {
@@ -22,13 +59,13 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	log.Fatal(startServer(*serverId, addrs, *blockStoreAddr))
+	log.Fatal(startServer(*serverId, config))
 }
 
-func startServer(id int64, addrs []string, blockStoreAddr string) error {
-	raftServer, err := surfstore.NewRaftServer(id, addrs, blockStoreAddr)
+func startServer(id int64, config *surfstore.ClusterConfig) error {
+	raftServer, err := surfstore.NewRaftServer(id, config)
 	if err != nil {
-		log.Fatal("Error creating servers")
+		return err
 	}
 
 	return surfstore.ServeRaftServer(raftServer)
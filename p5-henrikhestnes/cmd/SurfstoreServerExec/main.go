@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -15,11 +16,18 @@ import (
 )
 
 // Usage String
-const USAGE_STRING = "./run-server.sh -s <service_type> -p <port> -l -d (blockStoreAddr*)"
+const USAGE_STRING = "./run-server.sh -s <service_type> -p <port> -l -d -f <config_file> -storage <storage> -dir <block_dir> -s3-endpoint <url> -s3-bucket <bucket> -tls-ca <ca_file> -tls-cert <cert_file> -tls-key <key_file> -mtls (blockStoreAddr*)..."
 
 // Set of valid services
 var SERVICE_TYPES = map[string]bool{"meta": true, "block": true, "both": true}
 
+// Set of valid BlockStore storage backends
+var STORAGE_TYPES = map[string]bool{"memory": true, "disk": true, "bolt": true, "s3": true}
+
+const DEFAULT_BLOCK_DIR = "blocks"
+const BOLT_FILENAME = "blocks.db"
+const DEFAULT_S3_BUCKET = "surfstore"
+
 // Exit codes
 const EX_USAGE int = 64
 
@@ -31,7 +39,7 @@ func main() {
 		flag.VisitAll(func(f *flag.Flag) {
 			fmt.Fprintf(w, "  -%s: %v\n", f.Name, f.Usage)
 		})
-		fmt.Fprintf(w, "  (blockStoreAddr*): BlockStore Address (include self if service type is both)\n")
+		fmt.Fprintf(w, "  (blockStoreAddr*): BlockStore Addresses (include self if service type is both), the peers used to repair corrupt blocks if service type is block\n")
 	}
 
 	// Parse command-line argument flags
@@ -39,13 +47,53 @@ func main() {
 	port := flag.Int("p", 8080, "(default = 8080) Port to accept connections")
 	localOnly := flag.Bool("l", false, "Only listen on localhost")
 	debug := flag.Bool("d", false, "Output log statements")
+	configFile := flag.String("f", "", "Cluster config file, used for the BlockStore addresses if none are given and to check callers")
+	replicationFactor := flag.Int("r", 0, "(default = 1) Number of BlockStores each block is replicated to")
+	storage := flag.String("storage", "memory", "(default = memory) Where the BlockStore keeps blocks: memory, disk, bolt, s3")
+	blockDir := flag.String("dir", "", "Directory for disk and bolt storage, defaults to this BlockStore's dataDir in the config or "+DEFAULT_BLOCK_DIR)
+	s3Endpoint := flag.String("s3-endpoint", "", "URL of the S3 compatible object store for s3 storage, credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Bucket := flag.String("s3-bucket", DEFAULT_S3_BUCKET, "(default = "+DEFAULT_S3_BUCKET+") Bucket for s3 storage")
+	tlsCA := flag.String("tls-ca", "", "CA that signs the BlockStores' certificates, and the callers' with -mtls, overrides the config file")
+	tlsCert := flag.String("tls-cert", "", "Certificate this server serves and calls the BlockStores with, overrides the config file")
+	tlsKey := flag.String("tls-key", "", "Key of the -tls-cert certificate")
+	mutualTLS := flag.Bool("mtls", false, "Only accept callers with a certificate signed by the -tls-ca CA")
 	flag.Parse()
 
-	// Use tail arguments to hold BlockStore address
-	args := flag.Args()
-	blockStoreAddr := ""
-	if len(args) == 1 {
-		blockStoreAddr = args[0]
+	// Use tail arguments to hold BlockStore addresses
+	blockStoreAddrs := flag.Args()
+	config := &surfstore.ClusterConfig{}
+	if *configFile != "" {
+		var err error
+		config, err = surfstore.LoadClusterConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(blockStoreAddrs) == 0 {
+			blockStoreAddrs = config.BlockStoreAddrs()
+		}
+	}
+	if *replicationFactor > 0 {
+		config.ReplicationFactor = *replicationFactor
+	}
+	if *tlsCA != "" {
+		config.TLS.CAFile = *tlsCA
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		config.TLS.CertFile, config.TLS.KeyFile = *tlsCert, *tlsKey
+	}
+	if *mutualTLS {
+		config.TLS.Mutual = true
+	}
+	// Only the Raft servers use the peer certificates
+	config.TLS.PeerCAFile, config.TLS.PeerCertFile, config.TLS.PeerKeyFile = "", "", ""
+	if config.Replicas() > len(blockStoreAddrs) && strings.ToLower(*service) != "block" {
+		fmt.Fprintf(flag.CommandLine.Output(), "replication factor %d exceeds the %d BlockStores\n", config.Replicas(), len(blockStoreAddrs))
+		os.Exit(EX_USAGE)
+	}
+
+	// Callers are checked against the config's auth section
+	if *configFile == "" {
+		log.Println("No config given with -f, accepting every call")
 	}
 
 	// Valid service type argument
@@ -54,6 +102,16 @@ func main() {
 		os.Exit(EX_USAGE)
 	}
 
+	// Valid storage argument
+	if _, ok := STORAGE_TYPES[strings.ToLower(*storage)]; !ok {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if strings.ToLower(*storage) == "s3" && *s3Endpoint == "" {
+		fmt.Fprintf(flag.CommandLine.Output(), "s3 storage needs -s3-endpoint\n")
+		os.Exit(EX_USAGE)
+	}
+
 	// Add localhost if necessary
 	addr := ""
 	if *localOnly {
@@ -61,32 +119,65 @@ func main() {
 	}
 	addr += ":" + strconv.Itoa(*port)
 
+	if *blockDir == "" {
+		*blockDir = DEFAULT_BLOCK_DIR
+		for _, blockStore := range config.BlockStores {
+			if strings.HasSuffix(blockStore.Addr, ":"+strconv.Itoa(*port)) && blockStore.DataDir != "" {
+				*blockDir = blockStore.DataDir
+			}
+		}
+	}
+
 	// Disable log outputs if debug flag is missing
 	if !(*debug) {
 		log.SetFlags(0)
 		log.SetOutput(ioutil.Discard)
 	}
 
-	log.Fatal(startServer(addr, strings.ToLower(*service), blockStoreAddr))
+	storageConfig := storageConfig{
+		storage:    strings.ToLower(*storage),
+		blockDir:   *blockDir,
+		s3Endpoint: *s3Endpoint,
+		s3Bucket:   *s3Bucket,
+	}
+	log.Fatal(startServer(addr, strings.ToLower(*service), blockStoreAddrs, config, storageConfig))
 }
 
-func startServer(hostAddr string, serviceType string, blockStoreAddr string) error {
-	// Create a new RPC server
-	grpcServer := grpc.NewServer()
+// storageConfig describes where a BlockStore keeps its blocks
+type storageConfig struct {
+	storage    string
+	blockDir   string
+	s3Endpoint string
+	s3Bucket   string
+}
+
+func startServer(hostAddr string, serviceType string, blockStoreAddrs []string, config *surfstore.ClusterConfig, storage storageConfig) error {
+	if err := config.TLS.Load(); err != nil {
+		return err
+	}
+
+	// Create a new RPC server, checking the callers against the config's auth section
+	grpcServer := grpc.NewServer(append(surfstore.NewAuthenticator(config.Auth).ServerOptions(), grpc.Creds(config.TLS.ServerCredentials()))...)
 
 	// Register RPC services
 	if serviceType == "both" {
-		metaStore := surfstore.NewMetaStore(blockStoreAddr)
+		metaStore := newMetaStore(blockStoreAddrs, config)
 		surfstore.RegisterMetaStoreServer(grpcServer, metaStore)
-		blockStore := surfstore.NewBlockStore()
+		blockStore, err := newBlockStore(storage, blockStorePeers(hostAddr, blockStoreAddrs), config)
+		if err != nil {
+			return err
+		}
 		surfstore.RegisterBlockStoreServer(grpcServer, blockStore)
 	}
 	if serviceType == "meta" {
-		metaStore := surfstore.NewMetaStore(blockStoreAddr)
+		metaStore := newMetaStore(blockStoreAddrs, config)
 		surfstore.RegisterMetaStoreServer(grpcServer, metaStore)
 	}
 	if serviceType == "block" {
-		blockStore := surfstore.NewBlockStore()
+		blockStore, err := newBlockStore(storage, blockStorePeers(hostAddr, blockStoreAddrs), config)
+		if err != nil {
+			return err
+		}
 		surfstore.RegisterBlockStoreServer(grpcServer, blockStore)
 	}
 
@@ -100,3 +191,59 @@ func startServer(hostAddr string, serviceType string, blockStoreAddr string) err
 	}
 	return nil
 }
+
+// newMetaStore creates a MetaStore and starts watching and collecting garbage on its BlockStores
+func newMetaStore(blockStoreAddrs []string, config *surfstore.ClusterConfig) *surfstore.MetaStore {
+	metaStore := surfstore.NewMetaStore(blockStoreAddrs, config.VirtualNodes)
+	metaStore.ReplicationFactor = config.Replicas()
+	metaStore.HistoryLength = config.HistoryVersions()
+
+	monitor := surfstore.NewBlockStoreMonitor(metaStore, config, func() bool { return true })
+	go monitor.Run()
+	collector := surfstore.NewBlockGarbageCollector(metaStore, config, func() bool { return true })
+	go collector.Run()
+
+	return metaStore
+}
+
+// newBlockStore creates a BlockStore on top of the configured storage backend
+// and starts scrubbing it, repairing corrupt blocks from the peers
+func newBlockStore(storage storageConfig, peers []string, config *surfstore.ClusterConfig) (*surfstore.BlockStore, error) {
+	var backend surfstore.BlockBackend
+	var err error
+	switch storage.storage {
+	case "disk":
+		backend, err = surfstore.NewDiskBackend(storage.blockDir)
+	case "bolt":
+		if err := os.MkdirAll(storage.blockDir, 0755); err != nil {
+			return nil, err
+		}
+		backend, err = surfstore.NewBoltBackend(filepath.Join(storage.blockDir, BOLT_FILENAME))
+	case "s3":
+		backend, err = surfstore.NewS3Backend(storage.s3Endpoint, storage.s3Bucket, os.Getenv("AWS_REGION"),
+			os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	default:
+		backend = surfstore.NewMemoryBackend()
+	}
+	if err != nil {
+		return nil, err
+	}
+	blockStore := surfstore.NewBlockStoreWithBackend(backend)
+
+	scrubber := surfstore.NewBlockScrubber(blockStore, peers, config)
+	go scrubber.Run()
+
+	return blockStore, nil
+}
+
+// blockStorePeers returns the BlockStore addresses other than this server's
+func blockStorePeers(hostAddr string, blockStoreAddrs []string) []string {
+	port := hostAddr[strings.LastIndex(hostAddr, ":"):]
+	peers := make([]string, 0)
+	for _, addr := range blockStoreAddrs {
+		if !strings.HasSuffix(addr, port) {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
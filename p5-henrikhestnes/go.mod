@@ -3,12 +3,17 @@ module cse224/proj5
 go 1.17
 
 require (
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/golang/protobuf v1.5.0
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.15.15
+	go.etcd.io/bbolt v1.3.5
 	google.golang.org/grpc v1.44.0
 	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/golang/protobuf v1.5.0 // indirect
 	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
 	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd // indirect
 	golang.org/x/text v0.3.0 // indirect
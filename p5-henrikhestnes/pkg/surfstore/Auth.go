@@ -0,0 +1,243 @@
+package surfstore
+
+import (
+	context "context"
+	"crypto/subtle"
+	"regexp"
+	"strings"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Clients send a token and the namespace they sync in the gRPC metadata of
+// every call. Each namespace is a separate tree of files in the MetaStore,
+// and a user may only use the namespaces the cluster config grants it. The
+// servers call each other with the service token, which may do anything.
+
+const AUTH_METADATA_KEY string = "authorization"
+const AUTH_SCHEME string = "Bearer "
+const NAMESPACE_METADATA_KEY string = "surfstore-namespace"
+
+// Namespace of the calls that name none, and of all files synced before
+// namespaces existed
+const DEFAULT_NAMESPACE string = "default"
+
+// Grants a user every namespace
+const ALL_NAMESPACES string = "*"
+
+// Joins a namespace other than the default one and a file name into the
+// MetaStore's key for the file
+const NAMESPACE_SEPARATOR string = "\x00"
+
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Methods only the servers may call
+var SERVICE_METHODS = map[string]bool{
+	"AppendEntries":    true,
+	"SetLeader":        true,
+	"SendHeartbeat":    true,
+	"GetInternalState": true,
+	"IsCrashed":        true,
+	"Crash":            true,
+	"Restore":          true,
+	"ListBlocks":       true,
+	"DeleteBlocks":     true,
+}
+
+// Methods that read or write the files of the caller's namespace
+var NAMESPACED_METHODS = map[string]bool{
+	"GetFileInfoMap":  true,
+	"UpdateFile":      true,
+	"GetFileHistory":  true,
+	"GetFileVersion":  true,
+	"Watch":           true,
+	"GetChangesSince": true,
+}
+
+func ValidNamespace(namespace string) bool {
+	return namespacePattern.MatchString(namespace)
+}
+
+type namespaceKey struct{}
+
+// withNamespace returns a context for the calls made on behalf of a namespace
+func withNamespace(ctx context.Context, namespace string) context.Context {
+	if namespace == "" {
+		namespace = DEFAULT_NAMESPACE
+	}
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// namespaceFromContext returns the namespace a call was made in
+func namespaceFromContext(ctx context.Context) string {
+	if namespace, ok := ctx.Value(namespaceKey{}).(string); ok {
+		return namespace
+	}
+	return DEFAULT_NAMESPACE
+}
+
+// namespacedName returns the MetaStore's key for a file. Files of the default
+// namespace keep their plain name.
+func namespacedName(namespace string, fileName string) string {
+	if namespace == DEFAULT_NAMESPACE {
+		return fileName
+	}
+	return namespace + NAMESPACE_SEPARATOR + fileName
+}
+
+// namespaceOf returns the namespace of a MetaStore key
+func namespaceOf(key string) string {
+	if i := strings.Index(key, NAMESPACE_SEPARATOR); i >= 0 {
+		return key[:i]
+	}
+	return DEFAULT_NAMESPACE
+}
+
+// tokenCredentials adds the token and namespace to the metadata of every call
+type tokenCredentials struct {
+	token     string
+	namespace string
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := make(map[string]string)
+	if c.token != "" {
+		md[AUTH_METADATA_KEY] = AUTH_SCHEME + c.token
+	}
+	if c.namespace != "" {
+		md[NAMESPACE_METADATA_KEY] = c.namespace
+	}
+	return md, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// authDialOptions returns the options to dial a server with creds, sending
+// token and namespace if they are set
+func authDialOptions(creds credentials.TransportCredentials, token string, namespace string) []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token != "" || namespace != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: token, namespace: namespace}))
+	}
+	return opts
+}
+
+// serviceDialOptions returns the options the servers dial the MetaStore and
+// the BlockStores with
+func serviceDialOptions(config *ClusterConfig) []grpc.DialOption {
+	return authDialOptions(config.TLS.ClientCredentials(), config.Auth.ServiceToken, "")
+}
+
+// peerDialOptions returns the options the Raft servers dial each other with
+func peerDialOptions(config *ClusterConfig) []grpc.DialOption {
+	return authDialOptions(config.TLS.PeerClientCredentials(), config.Auth.ServiceToken, "")
+}
+
+// Authenticator checks the token and namespace of every call a server gets
+type Authenticator struct {
+	config AuthConfig
+}
+
+func NewAuthenticator(config AuthConfig) *Authenticator {
+	return &Authenticator{config: config}
+}
+
+// ServerOptions returns the options that install the Authenticator on a server
+func (a *Authenticator) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(a.unaryInterceptor),
+		grpc.StreamInterceptor(a.streamInterceptor),
+	}
+}
+
+func (a *Authenticator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *Authenticator) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authServerStream{ServerStream: stream, ctx: ctx})
+}
+
+// authServerStream is a stream whose context carries the caller's namespace
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize checks that the caller may call a method in the namespace it
+// asked for, and returns the context to handle the call with
+func (a *Authenticator) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	namespace := firstValue(md, NAMESPACE_METADATA_KEY)
+	if namespace != "" && !ValidNamespace(namespace) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid namespace %q", namespace)
+	}
+	if a.config.ServiceToken == "" {
+		return withNamespace(ctx, namespace), nil
+	}
+
+	auth := firstValue(md, AUTH_METADATA_KEY)
+	if !strings.HasPrefix(auth, AUTH_SCHEME) {
+		return nil, status.Errorf(codes.Unauthenticated, "missing token")
+	}
+	token := strings.TrimPrefix(auth, AUTH_SCHEME)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.ServiceToken)) == 1 {
+		return withNamespace(ctx, namespace), nil
+	}
+	user := a.user(token)
+	if user == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown token")
+	}
+
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if SERVICE_METHODS[method] {
+		return nil, status.Errorf(codes.PermissionDenied, "user %s may not call %s", user.Name, method)
+	}
+	if namespace == "" {
+		namespace = user.Namespaces[0]
+		if namespace == ALL_NAMESPACES {
+			namespace = DEFAULT_NAMESPACE
+		}
+	}
+	if NAMESPACED_METHODS[method] && !contains(user.Namespaces, namespace) && !contains(user.Namespaces, ALL_NAMESPACES) {
+		return nil, status.Errorf(codes.PermissionDenied, "user %s may not use namespace %s", user.Name, namespace)
+	}
+	return withNamespace(ctx, namespace), nil
+}
+
+// user returns the user a token belongs to, nil if it is unknown
+func (a *Authenticator) user(token string) *UserConfig {
+	var found *UserConfig
+	for i := range a.config.Users {
+		// Every token is compared, so the time taken does not tell which matched
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.Users[i].Token)) == 1 {
+			found = &a.config.Users[i]
+		}
+	}
+	return found
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
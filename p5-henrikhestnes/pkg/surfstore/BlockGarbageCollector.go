@@ -0,0 +1,128 @@
+package surfstore
+
+import (
+	context "context"
+	"log"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// BlockGarbageCollector deletes blocks that no file in the MetaStore refers to
+// anymore. Each run is a mark and sweep: the BlockStores are listed, the live
+// set is computed from the FileMetaMap and every listed block outside of it is
+// deleted. The BlockStores keep blocks written within the grace period, so a
+// block uploaded before its UpdateFile commits is never collected.
+type BlockGarbageCollector struct {
+	metaStore   *MetaStore
+	interval    time.Duration
+	gracePeriod time.Duration
+	timeout     time.Duration
+	dialOptions []grpc.DialOption
+
+	// Collections only run while active returns true, e.g. on the Raft leader
+	active func() bool
+}
+
+func (gc *BlockGarbageCollector) Run() {
+	for {
+		time.Sleep(gc.interval)
+		if !gc.active() {
+			continue
+		}
+		if _, err := gc.Collect(); err != nil {
+			log.Println("Block garbage collection failed: ", err)
+		}
+	}
+}
+
+// Collect runs one mark and sweep over all live BlockStores and returns the
+// number of blocks deleted.
+func (gc *BlockGarbageCollector) Collect() (int, error) {
+	// List before marking: a block that is committed in between is then
+	// found in the live set instead of being collected
+	stored := make(map[string][]string)
+	for _, addr := range gc.metaStore.LiveBlockStoreAddrs() {
+		var hashes []string
+		if err := gc.listBlocks(addr, &hashes); err != nil {
+			return 0, err
+		}
+		stored[addr] = hashes
+	}
+
+	live := make(map[string]bool)
+	for _, hash := range gc.metaStore.ReferencedBlockHashes() {
+		live[hash] = true
+	}
+
+	deleted := 0
+	for addr, hashes := range stored {
+		garbage := make([]string, 0)
+		for _, hash := range hashes {
+			if !live[hash] {
+				garbage = append(garbage, hash)
+			}
+		}
+		if len(garbage) == 0 {
+			continue
+		}
+
+		var deletedHashes []string
+		if err := gc.deleteBlocks(addr, garbage, &deletedHashes); err != nil {
+			return deleted, err
+		}
+		deleted += len(deletedHashes)
+		log.Println("Collected ", len(deletedHashes), " blocks from ", addr)
+	}
+	return deleted, nil
+}
+
+func (gc *BlockGarbageCollector) listBlocks(addr string, blockHashesOut *[]string) error {
+	conn, err := grpc.Dial(addr, gc.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gc.timeout)
+	defer cancel()
+
+	b, err := c.ListBlocks(ctx, &emptypb.Empty{})
+	if err != nil {
+		return err
+	}
+	*blockHashesOut = b.Hashes
+	return nil
+}
+
+func (gc *BlockGarbageCollector) deleteBlocks(addr string, blockHashesIn []string, blockHashesOut *[]string) error {
+	conn, err := grpc.Dial(addr, gc.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gc.timeout)
+	defer cancel()
+
+	b, err := c.DeleteBlocks(ctx, &DeleteBlocksRequest{Hashes: blockHashesIn, GracePeriodMs: gc.gracePeriod.Milliseconds()})
+	if err != nil {
+		return err
+	}
+	*blockHashesOut = b.Hashes
+	return nil
+}
+
+func NewBlockGarbageCollector(metaStore *MetaStore, config *ClusterConfig, active func() bool) *BlockGarbageCollector {
+	return &BlockGarbageCollector{
+		metaStore:   metaStore,
+		interval:    config.GCInterval(),
+		gracePeriod: config.GCGracePeriod(),
+		timeout:     config.RPCTimeout(),
+		dialOptions: serviceDialOptions(config),
+		active:      active,
+	}
+}
@@ -0,0 +1,118 @@
+package surfstore
+
+import (
+	context "context"
+	"log"
+	"time"
+
+	grpc "google.golang.org/grpc"
+)
+
+// BlockScrubber periodically reads back every block of a BlockStore and checks
+// it against its hash to find bit rot. A corrupt block is replaced with a copy
+// from one of the peer BlockStores, or removed if no peer has a good copy so
+// that HasBlocks no longer reports it and it can be re-replicated.
+type BlockScrubber struct {
+	blockStore  *BlockStore
+	peers       []string
+	interval    time.Duration
+	timeout     time.Duration
+	dialOptions []grpc.DialOption
+}
+
+type ScrubReport struct {
+	Checked  int
+	Corrupt  int
+	Repaired int
+	Removed  int
+}
+
+func (sc *BlockScrubber) Run() {
+	for {
+		time.Sleep(sc.interval)
+		report, err := sc.Scrub()
+		if err != nil {
+			log.Println("Block scrub failed: ", err)
+			continue
+		}
+		log.Printf("Scrubbed %d blocks: %d corrupt, %d repaired, %d removed\n",
+			report.Checked, report.Corrupt, report.Repaired, report.Removed)
+	}
+}
+
+// Scrub checks every stored block once
+func (sc *BlockScrubber) Scrub() (ScrubReport, error) {
+	var report ScrubReport
+	err := sc.blockStore.Backend.Iterate(func(hash string) error {
+		block, err := sc.blockStore.Backend.Get(hash)
+		if err == ERR_BLOCK_NOT_FOUND {
+			// Deleted since the iteration started
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Checked++
+		data, err := decompressBlock(block, MAX_BLOCK_SIZE)
+		if err == nil && GetBlockHashString(data) == hash {
+			return nil
+		}
+
+		report.Corrupt++
+		log.Println("Corrupt block: ", hash)
+		var goodBlock Block
+		for _, addr := range sc.peers {
+			// Peers verify the block before returning it
+			if err := sc.getBlock(hash, addr, &goodBlock); err != nil {
+				continue
+			}
+			if err := sc.blockStore.replaceBlock(hash, &goodBlock); err != nil {
+				return err
+			}
+			log.Println("Repaired block ", hash, " from ", addr)
+			report.Repaired++
+			return nil
+		}
+
+		if err := sc.blockStore.removeBlock(hash); err != nil {
+			return err
+		}
+		log.Println("No good copy of block ", hash, ", removed it")
+		report.Removed++
+		return nil
+	})
+	return report, err
+}
+
+func (sc *BlockScrubber) getBlock(blockHash string, addr string, block *Block) error {
+	conn, err := grpc.Dial(addr, sc.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sc.timeout)
+	defer cancel()
+
+	b, err := c.GetBlock(ctx, &BlockHash{Hash: blockHash, Codecs: SUPPORTED_CODECS})
+	if err != nil {
+		return err
+	}
+	block.BlockData = b.BlockData
+	block.BlockSize = b.BlockSize
+	block.Codec = b.Codec
+	return nil
+}
+
+// NewBlockScrubber creates a scrubber for blockStore that repairs blocks from
+// the BlockStores at peers.
+func NewBlockScrubber(blockStore *BlockStore, peers []string, config *ClusterConfig) *BlockScrubber {
+	return &BlockScrubber{
+		blockStore:  blockStore,
+		peers:       peers,
+		interval:    config.ScrubInterval(),
+		timeout:     config.RPCTimeout(),
+		dialOptions: serviceDialOptions(config),
+	}
+}
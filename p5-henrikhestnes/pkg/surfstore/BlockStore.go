@@ -2,49 +2,213 @@ package surfstore
 
 import (
 	context "context"
+	"io"
+	"log"
 	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
 type BlockStore struct {
-	BlockMap map[string]*Block
-	mtx sync.Mutex
+	Backend BlockBackend
+
+	// When each block was last written, blocks stored before the server
+	// started count as written at startTime
+	lastPut   map[string]time.Time
+	startTime time.Time
+	mtx       sync.Mutex
 	UnimplementedBlockStoreServer
 }
 
+// Returns the block stored under the hash. Fails with NotFound if there is no
+// such block and with DataLoss if the stored data no longer matches the hash.
+// A compressed block is returned as stored if the caller accepts its codec,
+// and decompressed otherwise.
 func (bs *BlockStore) GetBlock(ctx context.Context, blockHash *BlockHash) (*Block, error) {
-	bs.mtx.Lock()
-	block := bs.BlockMap[blockHash.Hash]
-	bs.mtx.Unlock()
-	return block, nil 
+	block, err := bs.Backend.Get(blockHash.Hash)
+	if err == ERR_BLOCK_NOT_FOUND {
+		return nil, status.Errorf(codes.NotFound, "block %s not found", blockHash.Hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := decompressBlock(block, MAX_BLOCK_SIZE)
+	if err != nil || GetBlockHashString(data) != blockHash.Hash {
+		log.Println("Corrupt block: ", blockHash.Hash)
+		return nil, status.Errorf(codes.DataLoss, "block %s is corrupt", blockHash.Hash)
+	}
+
+	if block.Codec != CODEC_NONE && contains(blockHash.Codecs, block.Codec) {
+		return &Block{BlockData: block.BlockData, BlockSize: int32(len(data)), Codec: block.Codec}, nil
+	}
+	return &Block{BlockData: data, BlockSize: int32(len(data))}, nil
 }
 
 func (bs *BlockStore) PutBlock(ctx context.Context, block *Block) (*Success, error) {
-	hash := GetBlockHashString(block.BlockData)
-	bs.mtx.Lock()
-	bs.BlockMap[hash] = block
-	bs.mtx.Unlock()
+	if _, err := bs.putBlock(block); err != nil {
+		return nil, err
+	}
 	return &Success{Flag: true}, nil
 }
 
+// putBlock stores a block as it was sent, compressed or not, and returns the
+// hash of its uncompressed data
+func (bs *BlockStore) putBlock(block *Block) (string, error) {
+	if !isSupportedCodec(block.Codec) {
+		return "", status.Errorf(codes.InvalidArgument, "unknown codec %q", block.Codec)
+	}
+	if int(block.BlockSize) > MAX_BLOCK_SIZE {
+		return "", status.Errorf(codes.InvalidArgument, "block size %d exceeds the limit of %d", block.BlockSize, MAX_BLOCK_SIZE)
+	}
+	data, err := decompressBlock(block, MAX_BLOCK_SIZE)
+	if err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "cannot decompress block: %v", err)
+	}
+	if int(block.BlockSize) != len(data) {
+		return "", status.Errorf(codes.InvalidArgument, "block size %d does not match its %d bytes of data", block.BlockSize, len(data))
+	}
+	hash := GetBlockHashString(data)
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	if err := bs.Backend.Put(hash, block); err != nil {
+		return "", err
+	}
+	bs.lastPut[hash] = time.Now()
+	return hash, nil
+}
+
 // Given a list of hashes “in”, returns a list containing the
 // subset of in that are stored in the key-value store
+//
+// Clients skip uploading the blocks found here, so they count as written now
+// and get a new grace period before they can be garbage collected. The reply
+// also lists the codecs PutBlock accepts.
 func (bs *BlockStore) HasBlocks(ctx context.Context, blockHashesIn *BlockHashes) (*BlockHashes, error) {
 	var hashes []string
+	now := time.Now()
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
 	for _, hash := range blockHashesIn.Hashes {
-		bs.mtx.Lock()
-		if _, ok := bs.BlockMap[hash]; ok {
+		ok, err := bs.Backend.Has(hash)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
 			hashes = append(hashes, hash)
+			bs.lastPut[hash] = now
 		}
-		bs.mtx.Unlock()
+	}
+	return &BlockHashes{Hashes: hashes, Codecs: SUPPORTED_CODECS}, nil
+}
+
+// Returns the hashes of all stored blocks
+func (bs *BlockStore) ListBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error) {
+	hashes := make([]string, 0)
+	err := bs.Backend.Iterate(func(hash string) error {
+		hashes = append(hashes, hash)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return &BlockHashes{Hashes: hashes}, nil
 }
 
+// Deletes the given blocks, except those written within the grace period, and
+// returns the hashes that were deleted
+func (bs *BlockStore) DeleteBlocks(ctx context.Context, request *DeleteBlocksRequest) (*BlockHashes, error) {
+	gracePeriod := time.Duration(request.GracePeriodMs) * time.Millisecond
+	deleted := make([]string, 0)
+
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	for _, hash := range request.Hashes {
+		lastPut, ok := bs.lastPut[hash]
+		if !ok {
+			lastPut = bs.startTime
+		}
+		if time.Since(lastPut) < gracePeriod {
+			continue
+		}
+
+		if err := bs.Backend.Delete(hash); err != nil {
+			return &BlockHashes{Hashes: deleted}, err
+		}
+		delete(bs.lastPut, hash)
+		deleted = append(deleted, hash)
+	}
+	return &BlockHashes{Hashes: deleted}, nil
+}
+
+// Stores every block sent on the stream and returns their hashes once the
+// client closes it
+func (bs *BlockStore) PutBlocks(stream BlockStore_PutBlocksServer) error {
+	hashes := make([]string, 0)
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&BlockHashes{Hashes: hashes})
+		}
+		if err != nil {
+			return err
+		}
+
+		hash, err := bs.putBlock(block)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+}
+
+// Streams the blocks in the order of the hashes, failing like GetBlock at the
+// first block that cannot be returned
+func (bs *BlockStore) GetBlocks(blockHashesIn *BlockHashes, stream BlockStore_GetBlocksServer) error {
+	for _, hash := range blockHashesIn.Hashes {
+		block, err := bs.GetBlock(stream.Context(), &BlockHash{Hash: hash, Codecs: blockHashesIn.Codecs})
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceBlock overwrites a stored block with a good copy
+func (bs *BlockStore) replaceBlock(hash string, block *Block) error {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	if err := bs.Backend.Delete(hash); err != nil {
+		return err
+	}
+	return bs.Backend.Put(hash, block)
+}
+
+// removeBlock deletes a block that cannot be served anymore
+func (bs *BlockStore) removeBlock(hash string) error {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	delete(bs.lastPut, hash)
+	return bs.Backend.Delete(hash)
+}
+
 // This line guarantees all method for BlockStore are implemented
 var _ BlockStoreInterface = new(BlockStore)
 
+// NewBlockStore creates a BlockStore that keeps its blocks in memory
 func NewBlockStore() *BlockStore {
+	return NewBlockStoreWithBackend(NewMemoryBackend())
+}
+
+func NewBlockStoreWithBackend(backend BlockBackend) *BlockStore {
 	return &BlockStore{
-		BlockMap: map[string]*Block{},
+		Backend:   backend,
+		lastPut:   make(map[string]time.Time),
+		startTime: time.Now(),
 	}
 }
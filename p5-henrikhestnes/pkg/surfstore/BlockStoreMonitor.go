@@ -0,0 +1,213 @@
+package surfstore
+
+import (
+	context "context"
+	"log"
+	"time"
+
+	grpc "google.golang.org/grpc"
+)
+
+// BlockStoreMonitor pings the BlockStores known to a MetaStore. A BlockStore
+// that has not answered for longer than deadAfter is taken out of the hash
+// ring, and the blocks it held are copied from the surviving replicas to their
+// new owners. A BlockStore that answers again is put back into the ring.
+type BlockStoreMonitor struct {
+	metaStore   *MetaStore
+	interval    time.Duration
+	deadAfter   time.Duration
+	timeout     time.Duration
+	dialOptions []grpc.DialOption
+
+	// Repairs only run while active returns true, e.g. on the Raft leader
+	active func() bool
+
+	lastSeen      map[string]time.Time
+	dead          map[string]bool
+	pendingRepair bool
+}
+
+func (mon *BlockStoreMonitor) Run() {
+	for {
+		mon.CheckBlockStores()
+		time.Sleep(mon.interval)
+	}
+}
+
+// CheckBlockStores pings every BlockStore once, updates the MetaStore's view
+// of which ones are alive and repairs the replicas if that view changed.
+func (mon *BlockStoreMonitor) CheckBlockStores() {
+	now := time.Now()
+	for _, addr := range mon.metaStore.BlockStoreAddrs {
+		var hashes []string
+		err := mon.hasBlocks(addr, []string{}, &hashes)
+		if err == nil {
+			mon.lastSeen[addr] = now
+			if mon.dead[addr] {
+				log.Println("BlockStore back up: ", addr)
+				mon.dead[addr] = false
+				mon.metaStore.SetBlockStoreAlive(addr, true)
+				mon.pendingRepair = true
+			}
+		} else if !mon.dead[addr] && now.Sub(mon.lastSeen[addr]) > mon.deadAfter {
+			log.Println("BlockStore declared dead: ", addr, err)
+			mon.dead[addr] = true
+			mon.metaStore.SetBlockStoreAlive(addr, false)
+			mon.pendingRepair = true
+		}
+	}
+
+	if mon.pendingRepair && mon.active() {
+		if err := mon.RepairBlocks(); err != nil {
+			log.Println("Block repair failed: ", err)
+			return
+		}
+		mon.pendingRepair = false
+	}
+}
+
+// RepairBlocks makes sure every block referenced by the MetaStore is stored
+// on each of the live BlockStores the hash ring assigns it to.
+func (mon *BlockStoreMonitor) RepairBlocks() error {
+	hashes := mon.metaStore.ReferencedBlockHashes()
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	holders := make(map[string][]string)
+	for _, addr := range mon.metaStore.LiveBlockStoreAddrs() {
+		var stored []string
+		if err := mon.hasBlocks(addr, hashes, &stored); err != nil {
+			return err
+		}
+		for _, hash := range stored {
+			holders[hash] = append(holders[hash], addr)
+		}
+	}
+
+	blockStoreMap, err := mon.metaStore.GetBlockStoreMap(context.Background(), &BlockHashes{Hashes: hashes})
+	if err != nil {
+		return err
+	}
+	replicas := make(map[string][]string)
+	for addr, blockHashes := range blockStoreMap.BlockStoreMap {
+		for _, hash := range blockHashes.Hashes {
+			replicas[hash] = append(replicas[hash], addr)
+		}
+	}
+
+	for hash, owners := range replicas {
+		missing := make([]string, 0)
+		for _, owner := range owners {
+			if !contains(holders[hash], owner) {
+				missing = append(missing, owner)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if len(holders[hash]) == 0 {
+			log.Println("No live replica left of block: ", hash)
+			continue
+		}
+
+		var block Block
+		if err := mon.getBlock(hash, holders[hash][0], &block); err != nil {
+			return err
+		}
+		for _, addr := range missing {
+			var succ bool
+			if err := mon.putBlock(&block, addr, &succ); err != nil {
+				return err
+			}
+		}
+		log.Println("Re-replicated block ", hash, " to ", missing)
+	}
+	return nil
+}
+
+func (mon *BlockStoreMonitor) hasBlocks(addr string, blockHashesIn []string, blockHashesOut *[]string) error {
+	conn, err := grpc.Dial(addr, mon.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mon.timeout)
+	defer cancel()
+
+	b, err := c.HasBlocks(ctx, &BlockHashes{Hashes: blockHashesIn})
+	if err != nil {
+		return err
+	}
+	*blockHashesOut = b.Hashes
+	return nil
+}
+
+func (mon *BlockStoreMonitor) getBlock(blockHash string, addr string, block *Block) error {
+	conn, err := grpc.Dial(addr, mon.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mon.timeout)
+	defer cancel()
+
+	b, err := c.GetBlock(ctx, &BlockHash{Hash: blockHash, Codecs: SUPPORTED_CODECS})
+	if err != nil {
+		return err
+	}
+	block.BlockData = b.BlockData
+	block.BlockSize = b.BlockSize
+	block.Codec = b.Codec
+	return nil
+}
+
+func (mon *BlockStoreMonitor) putBlock(block *Block, addr string, succ *bool) error {
+	conn, err := grpc.Dial(addr, mon.dialOptions...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mon.timeout)
+	defer cancel()
+
+	s, err := c.PutBlock(ctx, block)
+	if err != nil {
+		return err
+	}
+	*succ = s.Flag
+	return nil
+}
+
+func contains(list []string, item string) bool {
+	for _, elem := range list {
+		if elem == item {
+			return true
+		}
+	}
+	return false
+}
+
+func NewBlockStoreMonitor(metaStore *MetaStore, config *ClusterConfig, active func() bool) *BlockStoreMonitor {
+	lastSeen := make(map[string]time.Time)
+	for _, addr := range metaStore.BlockStoreAddrs {
+		lastSeen[addr] = time.Now()
+	}
+
+	return &BlockStoreMonitor{
+		metaStore:   metaStore,
+		interval:    config.BlockStoreHeartbeat(),
+		deadAfter:   config.BlockStoreDead(),
+		timeout:     config.RPCTimeout(),
+		dialOptions: serviceDialOptions(config),
+		active:      active,
+		lastSeen:    lastSeen,
+		dead:        make(map[string]bool),
+	}
+}
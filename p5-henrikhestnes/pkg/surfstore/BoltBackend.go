@@ -0,0 +1,125 @@
+package surfstore
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBlockBucket = []byte("blocks")
+
+// Codecs of the compressed blocks, uncompressed blocks have no entry
+var boltCodecBucket = []byte("codecs")
+
+// BoltBackend keeps blocks in a single bbolt database file, keyed by hash.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+func (b *BoltBackend) Get(hash string) (*Block, error) {
+	var block *Block
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBlockBucket).Get([]byte(hash))
+		if data == nil {
+			return ERR_BLOCK_NOT_FOUND
+		}
+		// data is only valid during the transaction
+		blockData := make([]byte, len(data))
+		copy(blockData, data)
+		codec := string(tx.Bucket(boltCodecBucket).Get([]byte(hash)))
+		block = &Block{BlockData: blockData, BlockSize: int32(len(blockData)), Codec: codec}
+		return nil
+	})
+	return block, err
+}
+
+func (b *BoltBackend) Put(hash string, block *Block) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if block.Codec == CODEC_NONE {
+			if err := tx.Bucket(boltCodecBucket).Delete([]byte(hash)); err != nil {
+				return err
+			}
+		} else if err := tx.Bucket(boltCodecBucket).Put([]byte(hash), []byte(block.Codec)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltBlockBucket).Put([]byte(hash), block.BlockData)
+	})
+}
+
+func (b *BoltBackend) Has(hash string) (bool, error) {
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltBlockBucket).Get([]byte(hash)) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (b *BoltBackend) Delete(hash string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltCodecBucket).Delete([]byte(hash)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltBlockBucket).Delete([]byte(hash))
+	})
+}
+
+func (b *BoltBackend) Iterate(fn func(hash string) error) error {
+	// Collect the keys first so fn may call back into the backend
+	hashes := make([]string, 0)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlockBucket).ForEach(func(k, v []byte) error {
+			hashes = append(hashes, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BoltBackend) Stats() (BackendStats, error) {
+	var stats BackendStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBlockBucket).ForEach(func(k, v []byte) error {
+			stats.Blocks++
+			stats.Bytes += int64(len(v))
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+var _ BlockBackend = new(BoltBackend)
+
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening block database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBlockBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltCodecBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating block buckets: %v", err)
+	}
+	return &BoltBackend{db: db}, nil
+}
@@ -0,0 +1,236 @@
+package surfstore
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const CHUNKING_FIXED string = "fixed"
+const CHUNKING_CDC string = "cdc"
+
+// Seed of the gear table. Every client must cut files the same way for their
+// blocks to deduplicate, so it must never change.
+const CDC_GEAR_SEED uint64 = 0x5375726653746f72
+
+// Chunker splits a file into the blocks that are hashed and uploaded
+type Chunker interface {
+	// Next returns the next block, or io.EOF after the last one
+	Next() ([]byte, error)
+}
+
+// FixedChunker cuts a file into blocks of the same size, only the last block
+// may be shorter.
+type FixedChunker struct {
+	reader io.Reader
+	size   int
+}
+
+func (c *FixedChunker) Next() ([]byte, error) {
+	chunk := make([]byte, c.size)
+	n, err := io.ReadFull(c.reader, chunk)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return chunk[:n], nil
+}
+
+func NewFixedChunker(reader io.Reader, size int) *FixedChunker {
+	return &FixedChunker{reader: reader, size: size}
+}
+
+// CDCChunker cuts a file at content-defined boundaries using FastCDC: a gear
+// hash is rolled over the data and a block ends where the hash matches a
+// mask. Since boundaries depend only on the bytes around them, inserting or
+// removing data only changes the blocks next to the edit, while fixed-size
+// blocks after it all shift. Blocks are between minSize and maxSize bytes and
+// average about avgSize.
+type CDCChunker struct {
+	reader  io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	// Stricter mask used before avgSize and looser one after it, which
+	// pulls block sizes towards the average (normalized chunking)
+	maskS uint64
+	maskL uint64
+
+	buf []byte
+	n   int
+	eof bool
+}
+
+var cdcGear = newGearTable(CDC_GEAR_SEED)
+
+func (c *CDCChunker) Next() ([]byte, error) {
+	if !c.eof && c.n < c.maxSize {
+		read, err := io.ReadFull(c.reader, c.buf[c.n:])
+		c.n += read
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.cutPoint(c.buf[:c.n])
+	chunk := make([]byte, cut)
+	copy(chunk, c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// cutPoint returns the length of the block at the start of data
+func (c *CDCChunker) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := c.avgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + cdcGear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + cdcGear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// topBits returns a mask of the n most significant bits, which depend on the
+// most bytes of the shifted gear hash
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// newGearTable fills the gear table with splitmix64 output
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+	state := seed
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// ValidateChunkSizes checks that 0 < minSize <= avgSize <= maxSize <=
+// MAX_BLOCK_SIZE
+func ValidateChunkSizes(minSize int, avgSize int, maxSize int) error {
+	if minSize < 1 || avgSize < minSize || maxSize < avgSize || maxSize > MAX_BLOCK_SIZE {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min <= avg <= max <= %d, got %d/%d/%d", MAX_BLOCK_SIZE, minSize, avgSize, maxSize)
+	}
+	return nil
+}
+
+func NewCDCChunker(reader io.Reader, minSize int, avgSize int, maxSize int) *CDCChunker {
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &CDCChunker{
+		reader:  reader,
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   topBits(avgBits + 1),
+		maskL:   topBits(avgBits - 1),
+		buf:     make([]byte, maxSize),
+	}
+}
+
+// ChunkSizes returns the minimum, average and maximum block size in cdc mode.
+// BlockSize is the average, and unset bounds default to a quarter and eight
+// times of it, at most MAX_BLOCK_SIZE.
+func (surfClient *RPCClient) ChunkSizes() (int, int, int) {
+	minSize, maxSize := surfClient.MinChunkSize, surfClient.MaxChunkSize
+	if minSize == 0 {
+		minSize = surfClient.BlockSize / 4
+		if minSize < 1 {
+			minSize = 1
+		}
+	}
+	if maxSize == 0 {
+		maxSize = surfClient.BlockSize * 8
+		if maxSize > MAX_BLOCK_SIZE {
+			maxSize = MAX_BLOCK_SIZE
+		}
+	}
+	return minSize, surfClient.BlockSize, maxSize
+}
+
+// ChunkingSpec describes how the client cuts files into blocks, as recorded
+// with the files it uploads: fixed/size or cdc/min/avg/max. Clients that cut
+// a file differently get different blocks for the same content.
+func (surfClient *RPCClient) ChunkingSpec() string {
+	if surfClient.Chunking == CHUNKING_CDC {
+		minSize, avgSize, maxSize := surfClient.ChunkSizes()
+		return fmt.Sprintf("%s/%d/%d/%d", CHUNKING_CDC, minSize, avgSize, maxSize)
+	}
+	return fmt.Sprintf("%s/%d", CHUNKING_FIXED, surfClient.BlockSize)
+}
+
+// newFileChunker returns the chunker the client is configured to use
+func newFileChunker(client RPCClient, reader io.Reader) Chunker {
+	if client.Chunking == CHUNKING_CDC {
+		minSize, avgSize, maxSize := client.ChunkSizes()
+		return NewCDCChunker(reader, minSize, avgSize, maxSize)
+	}
+	return NewFixedChunker(reader, client.BlockSize)
+}
+
+// newChunkerFor returns a chunker that cuts files the way a ChunkingSpec
+// describes, which is the client's own chunker if the spec is empty or the
+// client's
+func newChunkerFor(client RPCClient, spec string, reader io.Reader) (Chunker, error) {
+	if spec == "" || spec == client.ChunkingSpec() {
+		return newFileChunker(client, reader), nil
+	}
+	return newSpecChunker(spec, reader)
+}
+
+// newSpecChunker returns a chunker that cuts files the way a ChunkingSpec
+// describes
+func newSpecChunker(spec string, reader io.Reader) (Chunker, error) {
+	fields := strings.Split(spec, "/")
+	sizes := make([]int, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 1 || size > MAX_BLOCK_SIZE {
+			return nil, fmt.Errorf("invalid chunking %q", spec)
+		}
+		sizes = append(sizes, size)
+	}
+	switch {
+	case fields[0] == CHUNKING_FIXED && len(sizes) == 1:
+		return NewFixedChunker(reader, sizes[0]), nil
+	case fields[0] == CHUNKING_CDC && len(sizes) == 3 && ValidateChunkSizes(sizes[0], sizes[1], sizes[2]) == nil:
+		return NewCDCChunker(reader, sizes[0], sizes[1], sizes[2]), nil
+	}
+	return nil, fmt.Errorf("invalid chunking %q", spec)
+}
@@ -0,0 +1,447 @@
+package surfstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_RPC_TIMEOUT = time.Second
+const DEFAULT_BLOCKSTORE_HEARTBEAT = time.Second
+const DEFAULT_BLOCKSTORE_DEAD = 5 * time.Second
+const DEFAULT_GC_INTERVAL = 10 * time.Minute
+const DEFAULT_GC_GRACE_PERIOD = time.Hour
+const DEFAULT_SCRUB_INTERVAL = time.Hour
+const DEFAULT_HISTORY_LENGTH = 10
+
+// historyLength that turns the version history off, since 0 means the default
+const HISTORY_DISABLED = -1
+
+// ClusterConfig describes every process in a SurfStore deployment. The same
+// file is read by the Raft servers, the BlockStore servers and the clients.
+type ClusterConfig struct {
+	Servers     []ServerConfig     `json:"servers" yaml:"servers"`
+	BlockStores []BlockStoreConfig `json:"blockStores" yaml:"blockStores"`
+	Timeouts    TimeoutConfig      `json:"timeouts" yaml:"timeouts"`
+	TLS         TLSConfig          `json:"tls" yaml:"tls"`
+	Auth        AuthConfig         `json:"auth" yaml:"auth"`
+	GC          GCConfig           `json:"gc" yaml:"gc"`
+	Scrub       ScrubConfig        `json:"scrub" yaml:"scrub"`
+
+	// Ring positions per BlockStore, DEFAULT_VIRTUAL_NODES if unset
+	VirtualNodes int `json:"virtualNodes" yaml:"virtualNodes"`
+	// Number of BlockStores each block is written to, 1 if unset
+	ReplicationFactor int `json:"replicationFactor" yaml:"replicationFactor"`
+	// Prior versions the MetaStore keeps per file, DEFAULT_HISTORY_LENGTH if
+	// unset and none if HISTORY_DISABLED
+	HistoryLength int `json:"historyLength" yaml:"historyLength"`
+}
+
+// ServerConfig describes one RaftSurfstore node. RaftAddr is used by the other
+// nodes for AppendEntries, ClientAddr by SurfStore clients. ClientAddr defaults
+// to RaftAddr.
+type ServerConfig struct {
+	ID         int64  `json:"id" yaml:"id"`
+	RaftAddr   string `json:"raftAddr" yaml:"raftAddr"`
+	ClientAddr string `json:"clientAddr" yaml:"clientAddr"`
+	DataDir    string `json:"dataDir" yaml:"dataDir"`
+}
+
+type BlockStoreConfig struct {
+	Addr    string `json:"addr" yaml:"addr"`
+	DataDir string `json:"dataDir" yaml:"dataDir"`
+}
+
+type TimeoutConfig struct {
+	// Deadline of a single RPC, DEFAULT_RPC_TIMEOUT if unset
+	RPC Duration `json:"rpc" yaml:"rpc"`
+	// How often the MetaStore pings the BlockStores
+	BlockStoreHeartbeat Duration `json:"blockStoreHeartbeat" yaml:"blockStoreHeartbeat"`
+	// How long a BlockStore may stay silent before its blocks are re-replicated
+	BlockStoreDead Duration `json:"blockStoreDead" yaml:"blockStoreDead"`
+}
+
+// GCConfig controls the collection of blocks no file refers to anymore.
+type GCConfig struct {
+	// Time between collections, DEFAULT_GC_INTERVAL if unset
+	Interval Duration `json:"interval" yaml:"interval"`
+	// Blocks written more recently than this are never collected, so uploads
+	// whose UpdateFile has not committed yet are safe. DEFAULT_GC_GRACE_PERIOD if unset
+	GracePeriod Duration `json:"gracePeriod" yaml:"gracePeriod"`
+}
+
+type ScrubConfig struct {
+	// Time between two passes of the BlockStore scrubber, DEFAULT_SCRUB_INTERVAL if unset
+	Interval Duration `json:"interval" yaml:"interval"`
+}
+
+// TLSConfig describes the certificates of client traffic, and optionally
+// those of Raft peer traffic. Each process usually overrides the certificate
+// and key with its own on the command line.
+type TLSConfig struct {
+	// CA that signs the servers' certificates, and the clients' if Mutual
+	CAFile   string `json:"caFile" yaml:"caFile"`
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	// Servers only accept callers with a certificate signed by CAFile
+	Mutual bool `json:"mutual" yaml:"mutual"`
+
+	// Identity of the Raft servers towards each other, the ones above if unset
+	PeerCAFile   string `json:"peerCAFile" yaml:"peerCAFile"`
+	PeerCertFile string `json:"peerCertFile" yaml:"peerCertFile"`
+	PeerKeyFile  string `json:"peerKeyFile" yaml:"peerKeyFile"`
+
+	// Set by Load
+	loaded     bool
+	caPool     *x509.CertPool
+	cert       *tls.Certificate
+	peerCAPool *x509.CertPool
+	peerCert   *tls.Certificate
+}
+
+// AuthConfig lists who may call the servers. Without a service token every
+// call is allowed.
+type AuthConfig struct {
+	// Token the servers use to call each other, it may call any method and
+	// use any namespace
+	ServiceToken string       `json:"serviceToken" yaml:"serviceToken"`
+	Users        []UserConfig `json:"users" yaml:"users"`
+}
+
+type UserConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Token string `json:"token" yaml:"token"`
+	// Namespaces the user may read and write, ALL_NAMESPACES for any of them.
+	// The first one is used when a call names none, defaults to the user's name.
+	Namespaces []string `json:"namespaces" yaml:"namespaces"`
+}
+
+// Duration is a time.Duration written as a string such as "500ms" or "2s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// RaftAddrs returns the Raft peer addresses indexed by server ID.
+func (c *ClusterConfig) RaftAddrs() []string {
+	addrs := make([]string, len(c.Servers))
+	for i, server := range c.Servers {
+		addrs[i] = server.RaftAddr
+	}
+	return addrs
+}
+
+// ClientAddrs returns the addresses clients use to reach the MetaStore.
+func (c *ClusterConfig) ClientAddrs() []string {
+	addrs := make([]string, len(c.Servers))
+	for i, server := range c.Servers {
+		addrs[i] = server.ClientAddr
+	}
+	return addrs
+}
+
+func (c *ClusterConfig) BlockStoreAddrs() []string {
+	addrs := make([]string, len(c.BlockStores))
+	for i, blockStore := range c.BlockStores {
+		addrs[i] = blockStore.Addr
+	}
+	return addrs
+}
+
+func (c *ClusterConfig) RPCTimeout() time.Duration {
+	if c.Timeouts.RPC.Duration == 0 {
+		return DEFAULT_RPC_TIMEOUT
+	}
+	return c.Timeouts.RPC.Duration
+}
+
+func (c *ClusterConfig) BlockStoreHeartbeat() time.Duration {
+	if c.Timeouts.BlockStoreHeartbeat.Duration == 0 {
+		return DEFAULT_BLOCKSTORE_HEARTBEAT
+	}
+	return c.Timeouts.BlockStoreHeartbeat.Duration
+}
+
+func (c *ClusterConfig) BlockStoreDead() time.Duration {
+	if c.Timeouts.BlockStoreDead.Duration == 0 {
+		return DEFAULT_BLOCKSTORE_DEAD
+	}
+	return c.Timeouts.BlockStoreDead.Duration
+}
+
+func (c *ClusterConfig) GCInterval() time.Duration {
+	if c.GC.Interval.Duration == 0 {
+		return DEFAULT_GC_INTERVAL
+	}
+	return c.GC.Interval.Duration
+}
+
+func (c *ClusterConfig) GCGracePeriod() time.Duration {
+	if c.GC.GracePeriod.Duration == 0 {
+		return DEFAULT_GC_GRACE_PERIOD
+	}
+	return c.GC.GracePeriod.Duration
+}
+
+func (c *ClusterConfig) ScrubInterval() time.Duration {
+	if c.Scrub.Interval.Duration == 0 {
+		return DEFAULT_SCRUB_INTERVAL
+	}
+	return c.Scrub.Interval.Duration
+}
+
+func (c *ClusterConfig) Replicas() int {
+	if c.ReplicationFactor == 0 {
+		return 1
+	}
+	return c.ReplicationFactor
+}
+
+func (c *ClusterConfig) HistoryVersions() int {
+	switch c.HistoryLength {
+	case 0:
+		return DEFAULT_HISTORY_LENGTH
+	case HISTORY_DISABLED:
+		return 0
+	}
+	return c.HistoryLength
+}
+
+// Validate fills in defaults and checks that the configuration is usable.
+// Servers are sorted into ID order, so Servers[i].ID == i afterwards.
+func (c *ClusterConfig) Validate() error {
+	if len(c.Servers) == 0 {
+		return fmt.Errorf("config has no servers")
+	}
+
+	servers := make([]ServerConfig, len(c.Servers))
+	seen := make(map[int64]bool)
+	for _, server := range c.Servers {
+		if server.ID < 0 || server.ID >= int64(len(c.Servers)) {
+			return fmt.Errorf("server id %d out of range [0, %d)", server.ID, len(c.Servers))
+		}
+		if seen[server.ID] {
+			return fmt.Errorf("duplicate server id %d", server.ID)
+		}
+		seen[server.ID] = true
+		if server.RaftAddr == "" {
+			return fmt.Errorf("server %d has no raftAddr", server.ID)
+		}
+		if server.ClientAddr == "" {
+			server.ClientAddr = server.RaftAddr
+		}
+		servers[server.ID] = server
+	}
+	c.Servers = servers
+
+	addrs := make(map[string]bool)
+	useAddr := func(addr string) error {
+		if addrs[addr] {
+			return fmt.Errorf("address %s used twice", addr)
+		}
+		addrs[addr] = true
+		return nil
+	}
+	for _, server := range c.Servers {
+		if err := useAddr(server.RaftAddr); err != nil {
+			return err
+		}
+		if server.ClientAddr != server.RaftAddr {
+			if err := useAddr(server.ClientAddr); err != nil {
+				return err
+			}
+		}
+	}
+	for i, blockStore := range c.BlockStores {
+		if blockStore.Addr == "" {
+			return fmt.Errorf("blockStore %d has no addr", i)
+		}
+		if err := useAddr(blockStore.Addr); err != nil {
+			return err
+		}
+	}
+
+	if c.VirtualNodes < 0 {
+		return fmt.Errorf("negative virtualNodes %d", c.VirtualNodes)
+	}
+	if c.ReplicationFactor < 0 {
+		return fmt.Errorf("negative replicationFactor %d", c.ReplicationFactor)
+	}
+	if c.HistoryLength < HISTORY_DISABLED {
+		return fmt.Errorf("historyLength %d below %d", c.HistoryLength, HISTORY_DISABLED)
+	}
+	if len(c.BlockStores) > 0 && c.ReplicationFactor > len(c.BlockStores) {
+		return fmt.Errorf("replicationFactor %d exceeds the %d blockStores", c.ReplicationFactor, len(c.BlockStores))
+	}
+	for name, timeout := range map[string]Duration{
+		"rpc timeout":                 c.Timeouts.RPC,
+		"blockStoreHeartbeat timeout": c.Timeouts.BlockStoreHeartbeat,
+		"blockStoreDead timeout":      c.Timeouts.BlockStoreDead,
+		"gc interval":                 c.GC.Interval,
+		"gc gracePeriod":              c.GC.GracePeriod,
+		"scrub interval":              c.Scrub.Interval,
+	} {
+		if timeout.Duration < 0 {
+			return fmt.Errorf("negative %s %v", name, timeout.Duration)
+		}
+	}
+	if err := c.TLS.validate(); err != nil {
+		return err
+	}
+	return c.Auth.validate()
+}
+
+func (a *AuthConfig) validate() error {
+	if len(a.Users) > 0 && a.ServiceToken == "" {
+		return fmt.Errorf("auth users need a serviceToken")
+	}
+	names := make(map[string]bool)
+	tokens := map[string]bool{a.ServiceToken: true}
+	for i := range a.Users {
+		user := &a.Users[i]
+		if user.Name == "" {
+			return fmt.Errorf("auth user %d has no name", i)
+		}
+		if names[user.Name] {
+			return fmt.Errorf("duplicate auth user %s", user.Name)
+		}
+		names[user.Name] = true
+		if user.Token == "" || tokens[user.Token] {
+			return fmt.Errorf("auth user %s needs a token of its own", user.Name)
+		}
+		tokens[user.Token] = true
+		if len(user.Namespaces) == 0 {
+			user.Namespaces = []string{user.Name}
+		}
+		for _, namespace := range user.Namespaces {
+			if namespace != ALL_NAMESPACES && !ValidNamespace(namespace) {
+				return fmt.Errorf("auth user %s has invalid namespace %q", user.Name, namespace)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadClusterConfig reads a cluster configuration written in JSON, YAML or the
+// legacy "M: <count>" format and validates it.
+func LoadClusterConfig(filename string) (*ClusterConfig, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file: %v", err)
+	}
+
+	config, err := ParseClusterConfig(content, filepath.Ext(filename))
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", filename, err)
+	}
+	return config, nil
+}
+
+// ParseClusterConfig parses and validates a cluster configuration. The file
+// extension picks the format; anything else is sniffed from the content.
+func ParseClusterConfig(content []byte, ext string) (*ClusterConfig, error) {
+	config := &ClusterConfig{}
+	var err error
+	switch {
+	case ext == ".json":
+		err = decodeJSONConfig(content, config)
+	case ext == ".yaml" || ext == ".yml":
+		err = decodeYAMLConfig(content, config)
+	case isLegacyConfig(content):
+		config, err = parseLegacyConfig(content)
+	case bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")):
+		err = decodeJSONConfig(content, config)
+	default:
+		err = decodeYAMLConfig(content, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func decodeJSONConfig(content []byte, config *ClusterConfig) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+func decodeYAMLConfig(content []byte, config *ClusterConfig) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	return decoder.Decode(config)
+}
+
+func isLegacyConfig(content []byte) bool {
+	firstLine := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0])
+	return strings.HasPrefix(firstLine, "M:")
+}
+
+// parseLegacyConfig reads the original config format: a "M: <count>" line
+// followed by one "<name>: <addr>" line per Raft server.
+func parseLegacyConfig(content []byte) (*ClusterConfig, error) {
+	config := &ClusterConfig{}
+	serverCount := -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		splitRes := strings.SplitN(line, ": ", 2)
+		if len(splitRes) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\", got %q", lineNum, line)
+		}
+
+		if serverCount < 0 {
+			count, err := strconv.Atoi(strings.TrimSpace(splitRes[1]))
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("line %d: invalid server count %q", lineNum, splitRes[1])
+			}
+			serverCount = count
+			continue
+		}
+
+		addr := strings.TrimSpace(splitRes[1])
+		config.Servers = append(config.Servers, ServerConfig{
+			ID:         int64(len(config.Servers)),
+			RaftAddr:   addr,
+			ClientAddr: addr,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(config.Servers) != serverCount {
+		return nil, fmt.Errorf("expected %d servers, found %d", serverCount, len(config.Servers))
+	}
+	return config, nil
+}
@@ -0,0 +1,141 @@
+package surfstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const CODEC_NONE string = ""
+const CODEC_GZIP string = "gzip"
+const CODEC_SNAPPY string = "snappy"
+const CODEC_ZSTD string = "zstd"
+
+// Codecs a BlockStore accepts and a client can decompress
+var SUPPORTED_CODECS = []string{CODEC_GZIP, CODEC_SNAPPY, CODEC_ZSTD}
+
+// Blocks cannot be larger than gRPC's default message size limit
+const MAX_BLOCK_SIZE int = 4 * 1024 * 1024
+
+// A compressed block is only kept if it is at most this fraction of the
+// original size
+const COMPRESSION_MAX_RATIO float64 = 0.9
+
+// Shared by all blocks, EncodeAll may be called concurrently
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// Leading bytes of common formats that are already compressed
+var compressedMagics = [][]byte{
+	{0x1f, 0x8b},             // gzip
+	{0x28, 0xb5, 0x2f, 0xfd}, // zstd
+	{0xfd, '7', 'z', 'X', 'Z'},
+	{'B', 'Z', 'h'},
+	{'P', 'K', 0x03, 0x04}, // zip, docx, jar, ...
+	{'7', 'z', 0xbc, 0xaf},
+	{0x89, 'P', 'N', 'G'},
+	{0xff, 0xd8, 0xff}, // jpeg
+	{'G', 'I', 'F', '8'},
+	{'O', 'g', 'g', 'S'},
+	{'I', 'D', '3'}, // mp3
+	{0xff, 0xfb},    // mp3
+	{'s', 'N', 'a', 'P', 'p', 'Y'},
+}
+
+// compressBlock returns the block compressed with codec, or uncompressed if
+// the data looks compressed already or does not get smaller
+func compressBlock(data []byte, codec string) *Block {
+	block := &Block{BlockData: data, BlockSize: int32(len(data))}
+	if codec == CODEC_NONE || !worthCompressing(data) {
+		return block
+	}
+
+	var compressed []byte
+	switch codec {
+	case CODEC_GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		compressed = buf.Bytes()
+	case CODEC_SNAPPY:
+		compressed = snappy.Encode(nil, data)
+	case CODEC_ZSTD:
+		compressed = zstdEncoder.EncodeAll(data, nil)
+	default:
+		return block
+	}
+
+	if float64(len(compressed)) > float64(len(data))*COMPRESSION_MAX_RATIO {
+		return block
+	}
+	block.BlockData = compressed
+	block.Codec = codec
+	return block
+}
+
+// decompressBlock returns the uncompressed data of a block. At most limit
+// bytes are decompressed, so a small block cannot expand into an arbitrary
+// amount of memory.
+func decompressBlock(block *Block, limit int) ([]byte, error) {
+	switch block.Codec {
+	case CODEC_NONE:
+		return block.BlockData, nil
+	case CODEC_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(block.BlockData))
+		if err != nil {
+			return nil, err
+		}
+		return readLimited(r, limit)
+	case CODEC_ZSTD:
+		r, err := zstd.NewReader(bytes.NewReader(block.BlockData), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, limit)
+	case CODEC_SNAPPY:
+		n, err := snappy.DecodedLen(block.BlockData)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, fmt.Errorf("block is larger than %d bytes", limit)
+		}
+		return snappy.Decode(nil, block.BlockData)
+	}
+	return nil, ERR_UNKNOWN_CODEC
+}
+
+// readLimited reads all of r, failing if it holds more than limit bytes
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("block is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// worthCompressing guesses whether data can be compressed from its first bytes
+func worthCompressing(data []byte) bool {
+	if len(data) < 64 {
+		return false
+	}
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(data, magic) {
+			return false
+		}
+	}
+	return true
+}
+
+func isSupportedCodec(codec string) bool {
+	return codec == CODEC_NONE || contains(SUPPORTED_CODECS, codec)
+}
@@ -0,0 +1,72 @@
+package surfstore
+
+import (
+	"sort"
+	"strconv"
+)
+
+const DEFAULT_VIRTUAL_NODES int = 100
+
+// ConsistentHashRing maps block hashes onto BlockStore servers. Each server is
+// placed on the ring VirtualNodes times so blocks spread evenly and only about
+// 1/n of the blocks move when a server is added or removed.
+type ConsistentHashRing struct {
+	ServerMap    map[string]string // ring position -> BlockStore address
+	sortedHashes []string
+}
+
+// GetResponsibleServer returns the BlockStore that owns blockId: the first
+// server at or after the block's position on the ring.
+func (c *ConsistentHashRing) GetResponsibleServer(blockId string) string {
+	if len(c.sortedHashes) == 0 {
+		return ""
+	}
+	idx := sort.SearchStrings(c.sortedHashes, blockId)
+	if idx == len(c.sortedHashes) {
+		idx = 0
+	}
+	return c.ServerMap[c.sortedHashes[idx]]
+}
+
+// GetResponsibleServers returns the n distinct BlockStores that hold replicas
+// of blockId, walking the ring clockwise from the block's position. The first
+// one is the block's primary owner.
+func (c *ConsistentHashRing) GetResponsibleServers(blockId string, n int) []string {
+	servers := make([]string, 0, n)
+	if len(c.sortedHashes) == 0 {
+		return servers
+	}
+	seen := make(map[string]bool)
+	start := sort.SearchStrings(c.sortedHashes, blockId)
+	for i := 0; i < len(c.sortedHashes) && len(servers) < n; i++ {
+		server := c.ServerMap[c.sortedHashes[(start+i)%len(c.sortedHashes)]]
+		if !seen[server] {
+			seen[server] = true
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+func (c *ConsistentHashRing) Hash(addr string) string {
+	return GetBlockHashString([]byte(addr))
+}
+
+func NewConsistentHashRing(serverAddrs []string, virtualNodes int) *ConsistentHashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = DEFAULT_VIRTUAL_NODES
+	}
+
+	ring := &ConsistentHashRing{
+		ServerMap: make(map[string]string),
+	}
+	for _, addr := range serverAddrs {
+		for i := 0; i < virtualNodes; i++ {
+			hash := ring.Hash("blockstore" + addr + "#" + strconv.Itoa(i))
+			ring.ServerMap[hash] = addr
+			ring.sortedHashes = append(ring.sortedHashes, hash)
+		}
+	}
+	sort.Strings(ring.sortedHashes)
+	return ring
+}
@@ -0,0 +1,179 @@
+package surfstore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiskBackend keeps each block in its own file, named by the block's hash and
+// fanned out over two levels of directories: <dir>/ab/cd/abcd... A compressed
+// block has its codec as extension, e.g. abcd....gzip
+type DiskBackend struct {
+	Dir string
+}
+
+func (d *DiskBackend) Get(hash string) (*Block, error) {
+	path, codec, err := d.findBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ERR_BLOCK_NOT_FOUND
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading block %s: %v", hash, err)
+	}
+	return &Block{BlockData: data, BlockSize: int32(len(data)), Codec: codec}, nil
+}
+
+func (d *DiskBackend) Put(hash string, block *Block) error {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return err
+	}
+	if _, _, err := d.findBlock(hash); err == nil {
+		return nil
+	}
+	path = codecPath(path, block.Codec)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating block directory: %v", err)
+	}
+	if err := writeFileAtomic(path, block.BlockData); err != nil {
+		return fmt.Errorf("error writing block %s: %v", hash, err)
+	}
+	return nil
+}
+
+func (d *DiskBackend) Has(hash string) (bool, error) {
+	_, _, err := d.findBlock(hash)
+	if err == ERR_BLOCK_NOT_FOUND {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DiskBackend) Delete(hash string) error {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return err
+	}
+	for _, codec := range append([]string{CODEC_NONE}, SUPPORTED_CODECS...) {
+		if err := os.Remove(codecPath(path, codec)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error deleting block %s: %v", hash, err)
+		}
+	}
+	return nil
+}
+
+func (d *DiskBackend) Iterate(fn func(hash string) error) error {
+	return d.walk(func(hash string, info os.FileInfo) error {
+		return fn(hash)
+	})
+}
+
+func (d *DiskBackend) Stats() (BackendStats, error) {
+	var stats BackendStats
+	err := d.walk(func(hash string, info os.FileInfo) error {
+		stats.Blocks++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	return stats, err
+}
+
+// walk calls fn for every block file under Dir, skipping temporary files
+func (d *DiskBackend) walk(fn func(hash string, info os.FileInfo) error) error {
+	return filepath.Walk(d.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		hash := strings.SplitN(info.Name(), ".", 2)[0]
+		if info.IsDir() || !isBlockHash(hash) {
+			return nil
+		}
+		return fn(hash, info)
+	})
+}
+
+// blockPath returns the file a block is stored in. Only well-formed SHA-256
+// hashes are accepted, so a hash can never point outside of Dir.
+func (d *DiskBackend) blockPath(hash string) (string, error) {
+	if !isBlockHash(hash) {
+		return "", fmt.Errorf("invalid block hash %q", hash)
+	}
+	return filepath.Join(d.Dir, hash[0:2], hash[2:4], hash), nil
+}
+
+// findBlock returns the file a block is stored in and the codec it is
+// compressed with
+func (d *DiskBackend) findBlock(hash string) (string, string, error) {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return "", "", ERR_BLOCK_NOT_FOUND
+	}
+	for _, codec := range append([]string{CODEC_NONE}, SUPPORTED_CODECS...) {
+		name := codecPath(path, codec)
+		_, err := os.Stat(name)
+		if err == nil {
+			return name, codec, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+	}
+	return "", "", ERR_BLOCK_NOT_FOUND
+}
+
+func codecPath(path string, codec string) string {
+	if codec == CODEC_NONE {
+		return path
+	}
+	return path + "." + codec
+}
+
+func isBlockHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ATOMIC_TMP_PREFIX+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var _ BlockBackend = new(DiskBackend)
+
+func NewDiskBackend(dir string) (*DiskBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating block store directory: %v", err)
+	}
+	return &DiskBackend{Dir: dir}, nil
+}
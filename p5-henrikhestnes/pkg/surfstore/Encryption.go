@@ -0,0 +1,239 @@
+package surfstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const ENCRYPTION_CONVERGENT string = "convergent"
+const ENCRYPTION_RANDOM string = "random"
+
+// Clients that share files must derive the same keys, so the salt is fixed
+const ENCRYPTION_SALT string = "surfstore block encryption"
+const ENCRYPTION_KDF_ITERATIONS int = 100000
+
+// In random mode, the nonces of the blocks of each local file are kept here
+// so that unchanged files encrypt to the same blocks on the next sync
+const NONCE_FILENAME string = ".surfstore-nonces"
+
+const nonceSize int = 12
+
+// BlockCipher encrypts blocks on the client with AES-256-GCM, so that the
+// servers only ever store and hash ciphertext. An encrypted block is its
+// nonce followed by the sealed data.
+//
+// In convergent mode the nonce is a keyed hash of the plaintext: equal blocks
+// encrypt to equal ciphertext and are still deduplicated, but the servers
+// can tell that two blocks are equal. In random mode each block gets a random
+// nonce the first time it is encrypted for a file, which hides equal content
+// in different files at the cost of deduplicating it.
+type BlockCipher struct {
+	Mode         string
+	EncryptNames bool
+
+	blockAEAD cipher.AEAD
+	blockMac  []byte
+	nameAEAD  cipher.AEAD
+	nameMac   []byte
+
+	// Random mode: file name -> hex fingerprint of a block -> nonce. Only
+	// the nonces used during this sync are saved.
+	nonces map[string]map[string][]byte
+	used   map[string]map[string][]byte
+	mtx    sync.Mutex
+}
+
+// EncryptBlock encrypts a block of the file fileName
+func (c *BlockCipher) EncryptBlock(fileName string, data []byte) []byte {
+	var nonce []byte
+	if c.Mode == ENCRYPTION_RANDOM {
+		nonce = c.randomNonce(fileName, c.fingerprint(data))
+	} else {
+		nonce = c.fingerprint(data)[:nonceSize]
+	}
+	return c.blockAEAD.Seal(nonce, nonce, data, nil)
+}
+
+// DecryptBlock decrypts a block of the file fileName. In random mode its
+// nonce is remembered so the file encrypts to the same blocks again.
+func (c *BlockCipher) DecryptBlock(fileName string, data []byte) ([]byte, error) {
+	if len(data) < nonceSize {
+		return nil, ERR_DECRYPTION_FAILED
+	}
+	nonce := data[:nonceSize]
+	plaintext, err := c.blockAEAD.Open(nil, nonce, data[nonceSize:], nil)
+	if err != nil {
+		return nil, ERR_DECRYPTION_FAILED
+	}
+	if c.Mode == ENCRYPTION_RANDOM {
+		c.mtx.Lock()
+		c.useNonce(fileName, hex.EncodeToString(c.fingerprint(plaintext)), append([]byte{}, nonce...))
+		c.mtx.Unlock()
+	}
+	return plaintext, nil
+}
+
+// EncryptName encrypts a file name if names are encrypted. Names encrypt
+// deterministically, so every client maps a file to the same server entry.
+func (c *BlockCipher) EncryptName(name string) string {
+	if !c.EncryptNames {
+		return name
+	}
+	mac := hmac.New(sha256.New, c.nameMac)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:nonceSize]
+	return base64.RawURLEncoding.EncodeToString(c.nameAEAD.Seal(nonce, nonce, []byte(name), nil))
+}
+
+func (c *BlockCipher) DecryptName(name string) (string, error) {
+	if !c.EncryptNames {
+		return name, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil || len(data) < nonceSize {
+		return "", ERR_DECRYPTION_FAILED
+	}
+	plaintext, err := c.nameAEAD.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", ERR_DECRYPTION_FAILED
+	}
+	return string(plaintext), nil
+}
+
+func (c *BlockCipher) fingerprint(data []byte) []byte {
+	mac := hmac.New(sha256.New, c.blockMac)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// randomNonce returns the nonce a block of the file was encrypted with
+// before, or a new random one
+func (c *BlockCipher) randomNonce(fileName string, fingerprint []byte) []byte {
+	key := hex.EncodeToString(fingerprint)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	nonce, ok := c.used[fileName][key]
+	if !ok {
+		nonce, ok = c.nonces[fileName][key]
+	}
+	if !ok {
+		nonce = make([]byte, nonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			panic(err)
+		}
+	}
+	c.useNonce(fileName, key, nonce)
+	return nonce
+}
+
+func (c *BlockCipher) useNonce(fileName string, key string, nonce []byte) {
+	if _, ok := c.used[fileName]; !ok {
+		c.used[fileName] = make(map[string][]byte)
+	}
+	c.used[fileName][key] = nonce
+}
+
+// loadNonces reads the nonces saved by the last sync of baseDir
+func (c *BlockCipher) loadNonces(baseDir string) error {
+	if c.Mode != ENCRYPTION_RANDOM {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(baseDir, NONCE_FILENAME))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return json.Unmarshal(data, &c.nonces)
+}
+
+// saveNonces writes the nonces used during this sync to baseDir
+func (c *BlockCipher) saveNonces(baseDir string) error {
+	if c.Mode != ENCRYPTION_RANDOM {
+		return nil
+	}
+	c.mtx.Lock()
+	data, err := json.Marshal(c.used)
+	c.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(baseDir, NONCE_FILENAME), data)
+}
+
+// pbkdf2 derives a key from a passphrase as in RFC 8018 with HMAC-SHA256
+func pbkdf2(passphrase []byte, salt []byte, iterations int, keyLen int) []byte {
+	key := make([]byte, 0, keyLen)
+	for block := uint32(1); len(key) < keyLen; block++ {
+		mac := hmac.New(sha256.New, passphrase)
+		mac.Write(salt)
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], block)
+		mac.Write(counter[:])
+		u := mac.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewBlockCipher derives the encryption keys from passphrase
+func NewBlockCipher(passphrase string, mode string, encryptNames bool) (*BlockCipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+	if mode != ENCRYPTION_CONVERGENT && mode != ENCRYPTION_RANDOM {
+		return nil, fmt.Errorf("unknown encryption mode %q", mode)
+	}
+
+	keys := pbkdf2([]byte(passphrase), []byte(ENCRYPTION_SALT), ENCRYPTION_KDF_ITERATIONS, 4*32)
+	blockAEAD, err := newGCM(keys[0:32])
+	if err != nil {
+		return nil, err
+	}
+	nameAEAD, err := newGCM(keys[64:96])
+	if err != nil {
+		return nil, err
+	}
+	return &BlockCipher{
+		Mode:         mode,
+		EncryptNames: encryptNames,
+		blockAEAD:    blockAEAD,
+		blockMac:     keys[32:64],
+		nameAEAD:     nameAEAD,
+		nameMac:      keys[96:128],
+		nonces:       make(map[string]map[string][]byte),
+		used:         make(map[string]map[string][]byte),
+	}, nil
+}
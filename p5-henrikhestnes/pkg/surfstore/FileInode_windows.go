@@ -0,0 +1,8 @@
+package surfstore
+
+import "os"
+
+// fileInode returns 0, file IDs are not part of the FileInfo on Windows
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
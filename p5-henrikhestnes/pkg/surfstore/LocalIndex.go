@@ -0,0 +1,208 @@
+package surfstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The local index is written as JSON lines: a header with the format version
+// and the cursor, then one entry per file sorted by name. Any file name can
+// be stored, and the file is replaced by a rename so that a crash during a
+// write leaves the previous index in place. Indexes in the legacy comma
+// separated format are read as well, and rewritten in this format by the
+// next sync.
+
+// Version of the local index format written by this client
+const INDEX_FORMAT_VERSION int = 2
+
+// Files modified this close to the start of a sync do not get their state
+// recorded, since a later write within the same mtime tick could go unseen
+const RACY_MTIME_WINDOW = 2 * time.Second
+
+// LocalFileState is what a regular file looked like on disk when it was last
+// synced, as its index entry describes it. A file in the same state is not
+// hashed again.
+type LocalFileState struct {
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+	Inode uint64 `json:"inode,omitempty"`
+}
+
+func newLocalFileState(info os.FileInfo) LocalFileState {
+	return LocalFileState{Size: info.Size(), Mtime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+}
+
+// LocalIndex is the content of the index file in a base directory
+type LocalIndex struct {
+	Files map[string]*FileMetaData
+	// Local state of the regular files in Files, where it is known
+	States map[string]LocalFileState
+	// Cursor of the server's changes the index is synced to, nil if unknown
+	Cursor *Cursor
+	// Fingerprint of the SyncFilter of the last sync
+	Filter string
+	// Namespace the files were synced in, empty for the caller's default
+	Namespace string
+}
+
+type indexHeader struct {
+	Format    int     `json:"surfstoreIndex"`
+	Cursor    *Cursor `json:"cursor,omitempty"`
+	Filter    string  `json:"filter,omitempty"`
+	Namespace string  `json:"namespace,omitempty"`
+}
+
+type indexEntry struct {
+	Name          string          `json:"name"`
+	Version       int32           `json:"version"`
+	BlockHashList []string        `json:"blockHashList"`
+	Mode          uint32          `json:"mode,omitempty"`
+	Mtime         int64           `json:"mtime,omitempty"`
+	FileType      FileType        `json:"fileType,omitempty"`
+	SymlinkTarget string          `json:"symlinkTarget,omitempty"`
+	Chunking      string          `json:"chunking,omitempty"`
+	Local         *LocalFileState `json:"local,omitempty"`
+}
+
+func NewLocalIndex() *LocalIndex {
+	return &LocalIndex{
+		Files:  make(map[string]*FileMetaData),
+		States: make(map[string]LocalFileState),
+	}
+}
+
+// LoadLocalIndex reads the index file of a base directory. A missing or empty
+// index file is an empty index.
+func LoadLocalIndex(baseDir string) (*LocalIndex, error) {
+	index := NewLocalIndex()
+	indexFile, err := os.Open(filepath.Join(baseDir, DEFAULT_META_FILENAME))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+
+	reader := bufio.NewReader(indexFile)
+	firstLine, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	var header indexHeader
+	if json.Unmarshal([]byte(firstLine), &header) != nil || header.Format == 0 {
+		err := index.readLegacy(io.MultiReader(strings.NewReader(firstLine), reader))
+		return index, err
+	}
+	if header.Format > INDEX_FORMAT_VERSION {
+		return nil, fmt.Errorf("index format %d is newer than this client's format %d", header.Format, INDEX_FORMAT_VERSION)
+	}
+	index.Cursor, index.Filter, index.Namespace = header.Cursor, header.Filter, header.Namespace
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var entry indexEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading index: %v", err)
+		}
+		index.Files[entry.Name] = &FileMetaData{
+			Filename:      entry.Name,
+			Version:       entry.Version,
+			BlockHashList: entry.BlockHashList,
+			Mode:          entry.Mode,
+			Mtime:         entry.Mtime,
+			FileType:      entry.FileType,
+			SymlinkTarget: entry.SymlinkTarget,
+			Chunking:      entry.Chunking,
+		}
+		if entry.Local != nil {
+			index.States[entry.Name] = *entry.Local
+		}
+	}
+}
+
+// readLegacy reads an index in the comma separated format, one file per line
+// and an optional cursor line
+func (index *LocalIndex) readLegacy(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, CURSOR_LINE_PREFIX):
+			index.Cursor = newCursorFromConfig(line)
+		default:
+			fileMetaData := NewFileMetaDataFromConfig(line)
+			index.Files[fileMetaData.Filename] = fileMetaData
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Write replaces the index file of a base directory with this index
+func (index *LocalIndex) Write(baseDir string) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(indexHeader{Format: INDEX_FORMAT_VERSION, Cursor: index.Cursor, Filter: index.Filter, Namespace: index.Namespace}); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(index.Files))
+	for name := range index.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fileMetaData := index.Files[name]
+		entry := indexEntry{
+			Name:          name,
+			Version:       fileMetaData.Version,
+			BlockHashList: fileMetaData.BlockHashList,
+			Mode:          fileMetaData.Mode,
+			Mtime:         fileMetaData.Mtime,
+			FileType:      fileMetaData.FileType,
+			SymlinkTarget: fileMetaData.SymlinkTarget,
+			Chunking:      fileMetaData.Chunking,
+		}
+		if state, ok := index.States[name]; ok {
+			entry.Local = &state
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := writeFileAtomic(filepath.Join(baseDir, DEFAULT_META_FILENAME), buf.Bytes()); err != nil {
+		return err
+	}
+	return syncDir(baseDir)
+}
+
+// syncDir flushes a directory, so that a file renamed into it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
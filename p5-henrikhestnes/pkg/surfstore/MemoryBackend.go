@@ -0,0 +1,81 @@
+package surfstore
+
+import (
+	"sync"
+)
+
+type BackendStats struct {
+	Blocks int64
+	Bytes  int64
+}
+
+// MemoryBackend keeps blocks in a map, they are lost when the server stops.
+type MemoryBackend struct {
+	BlockMap map[string]*Block
+	mtx      sync.RWMutex
+}
+
+func (m *MemoryBackend) Get(hash string) (*Block, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	block, ok := m.BlockMap[hash]
+	if !ok {
+		return nil, ERR_BLOCK_NOT_FOUND
+	}
+	return block, nil
+}
+
+func (m *MemoryBackend) Put(hash string, block *Block) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.BlockMap[hash] = block
+	return nil
+}
+
+func (m *MemoryBackend) Has(hash string) (bool, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	_, ok := m.BlockMap[hash]
+	return ok, nil
+}
+
+func (m *MemoryBackend) Delete(hash string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.BlockMap, hash)
+	return nil
+}
+
+func (m *MemoryBackend) Iterate(fn func(hash string) error) error {
+	m.mtx.RLock()
+	hashes := make([]string, 0, len(m.BlockMap))
+	for hash := range m.BlockMap {
+		hashes = append(hashes, hash)
+	}
+	m.mtx.RUnlock()
+
+	for _, hash := range hashes {
+		if err := fn(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryBackend) Stats() (BackendStats, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	stats := BackendStats{Blocks: int64(len(m.BlockMap))}
+	for _, block := range m.BlockMap {
+		stats.Bytes += int64(len(block.BlockData))
+	}
+	return stats, nil
+}
+
+var _ BlockBackend = new(MemoryBackend)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		BlockMap: map[string]*Block{},
+	}
+}
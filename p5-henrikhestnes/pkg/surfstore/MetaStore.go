@@ -3,49 +3,375 @@ package surfstore
 import (
 	context "context"
 
+	"strings"
 	"sync"
+	"time"
 
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
+// MetaStore keeps the files of every namespace. The files of a namespace
+// other than the default one are kept under their namespaced name, see
+// namespacedName, and each call sees the files of its context's namespace.
 type MetaStore struct {
-	FileMetaMap    map[string]*FileMetaData
-	BlockStoreAddr string
-	mtx            sync.Mutex
+	FileMetaMap        map[string]*FileMetaData
+	FileHistory        map[string][]*FileMetaData
+	HistoryLength      int
+	BlockStoreAddrs    []string
+	ConsistentHashRing *ConsistentHashRing
+	ReplicationFactor  int
+	deadBlockStores    map[string]bool
+	virtualNodes       int
+	mtx                sync.Mutex
+
+	// Number of updates accepted so far, the index of the latest change
+	Revision int64
+	// Tells the change numbering of this MetaStore from that of an earlier
+	// one, which started over from 1. The replicas of a Raft cluster share
+	// the epoch of their log.
+	Epoch int64
+	// The latest accepted updates, oldest first, at most ChangeLogLength
+	changes         []*FileChange
+	ChangeLogLength int
+	// Closed and replaced by every accepted update, to wake up watchers
+	changed chan struct{}
+
 	UnimplementedMetaStoreServer
 }
 
 func (m *MetaStore) GetFileInfoMap(ctx context.Context, _ *emptypb.Empty) (*FileInfoMap, error) {
-	return &FileInfoMap{FileInfoMap: m.FileMetaMap}, nil
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	// A copy, so that the map matches the cursor while it is sent
+	namespace := namespaceFromContext(ctx)
+	fileInfoMap := make(map[string]*FileMetaData)
+	for key, fileMetaData := range m.FileMetaMap {
+		if namespaceOf(key) == namespace {
+			fileInfoMap[fileMetaData.Filename] = fileMetaData
+		}
+	}
+	return &FileInfoMap{FileInfoMap: fileInfoMap, Cursor: m.cursor(), Namespace: namespace}, nil
+}
+
+// allFiles returns the files of every namespace, keyed by their namespaced
+// name, for inspecting the MetaStore's state
+func (m *MetaStore) allFiles() *FileInfoMap {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	fileInfoMap := make(map[string]*FileMetaData, len(m.FileMetaMap))
+	for key, fileMetaData := range m.FileMetaMap {
+		fileInfoMap[key] = fileMetaData
+	}
+	return &FileInfoMap{FileInfoMap: fileInfoMap, Cursor: m.cursor()}
+}
+
+// Returns the latest version of every file changed after the cursor. Fails
+// with ERR_CHANGES_EXPIRED if the cursor is from another epoch or some of the
+// changes after it are no longer kept.
+func (m *MetaStore) GetChangesSince(ctx context.Context, cursor *Cursor) (*FileChanges, error) {
+	epoch := m.currentEpoch()
+	if cursor.Epoch != epoch {
+		return nil, ERR_CHANGES_EXPIRED
+	}
+	changes, _, err := m.changesFrom(cursor.Index + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := namespaceFromContext(ctx)
+	latest := make(map[string]*FileMetaData)
+	for _, change := range changes {
+		if change.Namespace == namespace {
+			latest[change.FileMetaData.Filename] = change.FileMetaData
+		}
+	}
+	fileChanges := &FileChanges{
+		FileMetaData: make([]*FileMetaData, 0, len(latest)),
+		Cursor:       &Cursor{Epoch: epoch, Index: cursor.Index + int64(len(changes))},
+	}
+	for _, fileMetaData := range latest {
+		fileChanges.FileMetaData = append(fileChanges.FileMetaData, fileMetaData)
+	}
+	return fileChanges, nil
+}
+
+func (m *MetaStore) cursor() *Cursor {
+	return &Cursor{Epoch: m.Epoch, Index: m.Revision}
+}
+
+func (m *MetaStore) currentEpoch() int64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.Epoch
+}
+
+// setEpoch makes the MetaStore number its changes in the epoch of the Raft log
+// it applies
+func (m *MetaStore) setEpoch(epoch int64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.Epoch = epoch
 }
 
 func (m *MetaStore) UpdateFile(ctx context.Context, fileMetaData *FileMetaData) (*Version, error) {
-	filename := fileMetaData.Filename
+	if strings.Contains(fileMetaData.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
+	}
+	namespace := namespaceFromContext(ctx)
+	filename := namespacedName(namespace, fileMetaData.Filename)
 	version := fileMetaData.Version
 	m.mtx.Lock()
 	if _, ok := m.FileMetaMap[filename]; ok {
 		if version == m.FileMetaMap[filename].Version+1 {
+			m.recordHistory(filename, m.FileMetaMap[filename])
 			m.FileMetaMap[filename] = fileMetaData
+			m.recordChange(namespace, fileMetaData)
 		} else {
 			version = -1
 		}
 	} else {
 		m.FileMetaMap[filename] = fileMetaData
+		m.recordChange(namespace, fileMetaData)
 	}
 	m.mtx.Unlock()
 	return &Version{Version: version}, nil
 }
 
+// recordHistory keeps a replaced version of the file at key, dropping the
+// oldest one once HistoryLength versions are kept
+func (m *MetaStore) recordHistory(key string, fileMetaData *FileMetaData) {
+	if m.HistoryLength <= 0 {
+		return
+	}
+	history := append(m.FileHistory[key], fileMetaData)
+	if len(history) > m.HistoryLength {
+		history = history[len(history)-m.HistoryLength:]
+	}
+	m.FileHistory[key] = history
+}
+
+// recordChange adds an accepted update to the change log and wakes up the
+// watchers
+func (m *MetaStore) recordChange(namespace string, fileMetaData *FileMetaData) {
+	m.Revision++
+	m.changes = append(m.changes, &FileChange{Index: m.Revision, FileMetaData: fileMetaData, Namespace: namespace})
+	if len(m.changes) > m.ChangeLogLength {
+		m.changes = m.changes[len(m.changes)-m.ChangeLogLength:]
+	}
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// changesFrom returns the kept changes from index on, and a channel that is
+// closed by the next change. It fails with ERR_CHANGES_EXPIRED if some of
+// the changes are no longer kept.
+func (m *MetaStore) changesFrom(index int64) ([]*FileChange, <-chan struct{}, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	oldest := m.Revision - int64(len(m.changes)) + 1
+	// An index past the next change is from a MetaStore that got further
+	if index < oldest || index > m.Revision+1 {
+		return nil, nil, ERR_CHANGES_EXPIRED
+	}
+	if index > m.Revision {
+		return nil, m.changed, nil
+	}
+	changes := make([]*FileChange, m.Revision-index+1)
+	copy(changes, m.changes[index-oldest:])
+	return changes, m.changed, nil
+}
+
+func (m *MetaStore) Watch(request *WatchRequest, stream MetaStore_WatchServer) error {
+	return m.watch(stream.Context(), request, stream.Send, 0, nil)
+}
+
+// watch sends every change to the files of ctx's namespace from the
+// request's index on until ctx is done or send fails. If check is set, it is
+// called every checkInterval and ends the watch with its error.
+func (m *MetaStore) watch(ctx context.Context, request *WatchRequest, send func(*FileChange) error, checkInterval time.Duration, check func() error) error {
+	namespace := namespaceFromContext(ctx)
+	index := request.FromIndex
+	m.mtx.Lock()
+	epoch, revision := m.Epoch, m.Revision
+	m.mtx.Unlock()
+	if index <= 0 {
+		index = revision + 1
+	} else if request.Epoch != epoch {
+		return ERR_CHANGES_EXPIRED
+	}
+	var ticks <-chan time.Time
+	if check != nil {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		changes, changed, err := m.changesFrom(index)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			index = change.Index + 1
+			if change.Namespace != namespace {
+				continue
+			}
+			if err := send(change); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticks:
+			if err := check(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns the kept versions of a file followed by its current version
+func (m *MetaStore) GetFileHistory(ctx context.Context, fileVersion *FileVersion) (*FileHistory, error) {
+	if strings.Contains(fileVersion.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	key := namespacedName(namespaceFromContext(ctx), fileVersion.Filename)
+	current, ok := m.FileMetaMap[key]
+	if !ok {
+		return nil, ERR_FILE_NOT_FOUND
+	}
+	versions := make([]*FileMetaData, 0, len(m.FileHistory[key])+1)
+	versions = append(versions, m.FileHistory[key]...)
+	versions = append(versions, current)
+	return &FileHistory{Versions: versions}, nil
+}
+
+func (m *MetaStore) GetFileVersion(ctx context.Context, fileVersion *FileVersion) (*FileMetaData, error) {
+	if strings.Contains(fileVersion.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	key := namespacedName(namespaceFromContext(ctx), fileVersion.Filename)
+	if current, ok := m.FileMetaMap[key]; ok && current.Version == fileVersion.Version {
+		return current, nil
+	}
+	for _, fileMetaData := range m.FileHistory[key] {
+		if fileMetaData.Version == fileVersion.Version {
+			return fileMetaData, nil
+		}
+	}
+	return nil, ERR_VERSION_NOT_FOUND
+}
+
 func (m *MetaStore) GetBlockStoreAddr(ctx context.Context, _ *emptypb.Empty) (*BlockStoreAddr, error) {
-	return &BlockStoreAddr{Addr: m.BlockStoreAddr}, nil
+	if len(m.BlockStoreAddrs) == 0 {
+		return &BlockStoreAddr{}, nil
+	}
+	return &BlockStoreAddr{Addr: m.BlockStoreAddrs[0]}, nil
+}
+
+// Given a list of block hashes, returns which BlockStores each of them belongs to.
+// A hash is listed under every live BlockStore that should hold a replica of it.
+func (m *MetaStore) GetBlockStoreMap(ctx context.Context, blockHashesIn *BlockHashes) (*BlockStoreMap, error) {
+	m.mtx.Lock()
+	ring := m.ConsistentHashRing
+	replicationFactor := m.ReplicationFactor
+	m.mtx.Unlock()
+
+	blockStoreMap := make(map[string]*BlockHashes)
+	for _, hash := range blockHashesIn.Hashes {
+		for _, server := range ring.GetResponsibleServers(hash, replicationFactor) {
+			if _, ok := blockStoreMap[server]; !ok {
+				blockStoreMap[server] = &BlockHashes{}
+			}
+			blockStoreMap[server].Hashes = append(blockStoreMap[server].Hashes, hash)
+		}
+	}
+	return &BlockStoreMap{BlockStoreMap: blockStoreMap}, nil
+}
+
+// SetBlockStoreAlive adds or removes a BlockStore from the hash ring, so that
+// blocks are only placed on BlockStores that are believed to be up.
+func (m *MetaStore) SetBlockStoreAlive(addr string, alive bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if alive {
+		delete(m.deadBlockStores, addr)
+	} else {
+		m.deadBlockStores[addr] = true
+	}
+	m.ConsistentHashRing = NewConsistentHashRing(m.liveBlockStoreAddrs(), m.virtualNodes)
+}
+
+func (m *MetaStore) LiveBlockStoreAddrs() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.liveBlockStoreAddrs()
+}
+
+func (m *MetaStore) liveBlockStoreAddrs() []string {
+	addrs := make([]string, 0, len(m.BlockStoreAddrs))
+	for _, addr := range m.BlockStoreAddrs {
+		if !m.deadBlockStores[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// ReferencedBlockHashes returns every block hash used by a file of any
+// namespace in the FileMetaMap or by one of its kept versions
+func (m *MetaStore) ReferencedBlockHashes() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	seen := make(map[string]bool)
+	hashes := make([]string, 0)
+	addHashes := func(fileMetaData *FileMetaData) {
+		for _, hash := range fileMetaData.BlockHashList {
+			if isMarkerHash(hash) || seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	for _, fileMetaData := range m.FileMetaMap {
+		addHashes(fileMetaData)
+	}
+	for _, history := range m.FileHistory {
+		for _, fileMetaData := range history {
+			addHashes(fileMetaData)
+		}
+	}
+	return hashes
 }
 
 // This line guarantees all method for MetaStore are implemented
 var _ MetaStoreInterface = new(MetaStore)
 
-func NewMetaStore(blockStoreAddr string) *MetaStore {
+func NewMetaStore(blockStoreAddrs []string, virtualNodes int) *MetaStore {
 	return &MetaStore{
-		FileMetaMap:    map[string]*FileMetaData{},
-		BlockStoreAddr: blockStoreAddr,
+		FileMetaMap:        map[string]*FileMetaData{},
+		FileHistory:        map[string][]*FileMetaData{},
+		HistoryLength:      DEFAULT_HISTORY_LENGTH,
+		Epoch:              time.Now().UnixNano(),
+		ChangeLogLength:    CHANGE_LOG_LENGTH,
+		changed:            make(chan struct{}),
+		BlockStoreAddrs:    blockStoreAddrs,
+		ConsistentHashRing: NewConsistentHashRing(blockStoreAddrs, virtualNodes),
+		ReplicationFactor:  1,
+		deadBlockStores:    map[string]bool{},
+		virtualNodes:       virtualNodes,
 	}
 }
@@ -0,0 +1,183 @@
+package surfstore
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// File names in a FileMetaData are paths relative to the base directory,
+// separated by "/". Directories and symlinks are entries of their own, with
+// DIRECTORY_HASH or SYMLINK_HASH as their block hash list, so that empty
+// directories and links are synced too.
+
+// ValidateFileName checks that a file name is relative, clean and stays inside
+// the base directory. Control characters that break lines, backslashes and the
+// client's own files at the top of the base directory are rejected as well.
+func ValidateFileName(name string) error {
+	if name == "" || name == "." || path.IsAbs(name) || path.Clean(name) != name ||
+		name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("%w: %q", ERR_INVALID_PATH, name)
+	}
+	if strings.ContainsAny(name, "\r\n\x00\\") {
+		return fmt.Errorf("%w: %q", ERR_INVALID_PATH, name)
+	}
+	if isReservedName(name) {
+		return fmt.Errorf("%w: %q is used by the client", ERR_INVALID_PATH, name)
+	}
+	return nil
+}
+
+// isReservedName reports whether name is one of the client's own files,
+// including the temporary files they are written to
+func isReservedName(name string) bool {
+	for _, reserved := range []string{DEFAULT_META_FILENAME, NONCE_FILENAME, EXCLUDE_FILENAME} {
+		if name == reserved || strings.HasPrefix(name, ATOMIC_TMP_PREFIX+reserved) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, DOWNLOAD_TMP_PREFIX)
+}
+
+// isMarkerHash reports whether a hash marks a deleted file, a directory or a
+// symlink instead of naming a block
+func isMarkerHash(hash string) bool {
+	return hash == TOMBSTONE_HASH || hash == DIRECTORY_HASH || hash == SYMLINK_HASH
+}
+
+func isDirectory(blockHashList []string) bool {
+	return len(blockHashList) == 1 && blockHashList[0] == DIRECTORY_HASH
+}
+
+func isSymlink(blockHashList []string) bool {
+	return len(blockHashList) == 1 && blockHashList[0] == SYMLINK_HASH
+}
+
+// localPath returns the path of a file in the base directory
+func localPath(client RPCClient, name string) string {
+	return filepath.Join(client.BaseDir, filepath.FromSlash(name))
+}
+
+// validateSymlinkTarget checks that a symlink target can be stored in the index
+func validateSymlinkTarget(target string) error {
+	if target == "" || strings.ContainsAny(target, "\r\n\x00") {
+		return fmt.Errorf("%w: symlink target %q", ERR_INVALID_PATH, target)
+	}
+	return nil
+}
+
+// validateDownloadedSymlink checks that a symlink target from the server is
+// relative and stays inside the base directory, so that nothing written
+// through the link can end up outside of it. ".." is only allowed at the start
+// of the target, where it climbs the link's real parent directories, since
+// after another link it would climb that link's target instead.
+func validateDownloadedSymlink(name string, target string) error {
+	if err := validateSymlinkTarget(target); err != nil {
+		return err
+	}
+	if path.IsAbs(target) || filepath.IsAbs(target) {
+		return fmt.Errorf("%w: symlink %q points to absolute path %q", ERR_INVALID_PATH, name, target)
+	}
+	depth := strings.Count(name, "/")
+	climbing := true
+	for _, elem := range strings.Split(filepath.ToSlash(target), "/") {
+		if elem != ".." {
+			climbing = false
+			continue
+		}
+		if !climbing || depth == 0 {
+			return fmt.Errorf("%w: symlink %q points outside the base directory", ERR_INVALID_PATH, name)
+		}
+		depth--
+	}
+	return nil
+}
+
+// safeLocalPath returns the path of a file in the base directory after
+// checking its name, and that none of its parent directories is a symlink
+// that could lead out of the base directory.
+func safeLocalPath(client RPCClient, name string) (string, error) {
+	if err := ValidateFileName(name); err != nil {
+		return "", err
+	}
+	parent := client.BaseDir
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		parent = filepath.Join(parent, elem)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %q is inside a symlink", ERR_INVALID_PATH, name)
+		}
+	}
+	return localPath(client, name), nil
+}
+
+// scanBaseDir lists the files, directories and symlinks below the base
+// directory. Symlinks are not followed. Directories that cannot be read are
+// returned in unreadable, so that their contents are not mistaken for
+// deleted files. Files the filter leaves out are not listed.
+func scanBaseDir(baseDir string, filter *SyncFilter) (entries map[string]os.FileInfo, unreadable []string) {
+	entries = make(map[string]os.FileInfo)
+	filepath.Walk(baseDir, func(p string, info os.FileInfo, err error) error {
+		if p == baseDir {
+			if err != nil {
+				log.Println("Error when reading basedir: ", err)
+				unreadable = append(unreadable, "")
+			}
+			return nil
+		}
+		rel, relErr := filepath.Rel(baseDir, p)
+		if relErr != nil {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if err != nil {
+			log.Println("Error reading ", name, ": ", err)
+			unreadable = append(unreadable, name)
+			return nil
+		}
+		if isReservedName(name) {
+			return nil
+		}
+		if err := ValidateFileName(name); err != nil {
+			log.Println("Skipping file: ", err)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter.Skipped(name, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() || info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			entries[name] = info
+		}
+		return nil
+	})
+	return entries, unreadable
+}
+
+// isBelow reports whether name is one of dirs or inside one of them. The
+// empty directory name stands for the base directory.
+func isBelow(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if dir == "" || name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -22,4 +22,7 @@ type RaftSurfstoreInterface interface {
 	MetaStoreInterface
 	RaftInterface
 	RaftTestingInterface
+
+	// Stream the accepted updates from an index on, see MetaStore.Watch
+	Watch(request *WatchRequest, stream RaftSurfstore_WatchServer) error
 }
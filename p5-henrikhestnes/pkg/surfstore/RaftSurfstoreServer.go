@@ -4,12 +4,12 @@ import (
 	context "context"
 	//"log"
 	"math"
+	"strings"
 	"sync"
 	"time"
 
-	"google.golang.org/grpc/credentials/insecure"
-
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -27,9 +27,18 @@ type RaftSurfstore struct {
 	lastApplied int64
 
 	// Server Info
-	ip       string
-	ipList   []string
-	serverId int64
+	ip         string
+	clientAddr string
+	ipList     []string
+	serverId   int64
+	rpcTimeout time.Duration
+
+	// Checks the calls this server gets, and the options it calls its peers with
+	authenticator *Authenticator
+	dialOptions   []grpc.DialOption
+	// Served on the Raft address and on the client address
+	peerCredentials   credentials.TransportCredentials
+	clientCredentials credentials.TransportCredentials
 
 	// Leader protection
 	isLeaderMutex sync.RWMutex
@@ -37,6 +46,9 @@ type RaftSurfstore struct {
 
 	rpcClients []RaftSurfstoreClient
 
+	blockStoreMonitor *BlockStoreMonitor
+	blockCollector    *BlockGarbageCollector
+
 	/*--------------- Chaos Monkey --------------*/
 	isCrashed      bool
 	isCrashedMutex *sync.RWMutex
@@ -46,79 +58,90 @@ type RaftSurfstore struct {
 }
 
 func (s *RaftSurfstore) GetFileInfoMap(ctx context.Context, empty *emptypb.Empty) (*FileInfoMap, error) {
-	s.isCrashedMutex.RLock()
-	isCrashed := s.isCrashed
-	s.isCrashedMutex.RUnlock()
-	if isCrashed {
-		return nil, ERR_SERVER_CRASHED
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
 	}
 
-	s.isLeaderMutex.RLock()
-	isLeader := s.isLeader
-	s.isLeaderMutex.RUnlock()
-	if !isLeader {
-		return nil, ERR_NOT_LEADER
-	}
+	return s.metaStore.GetFileInfoMap(ctx, empty)
+}
 
-	for {
-		majorityAlive, _ := s.SendHeartbeat(ctx, empty)
-		if majorityAlive.Flag {
-			break
-		}
+func (s *RaftSurfstore) GetBlockStoreAddr(ctx context.Context, empty *emptypb.Empty) (*BlockStoreAddr, error) {
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
 	}
 
-	//if a majority of the nodes are working, should return the correct answer;
-	//if a majority of the nodes are crashed, should block until a majority recover.
+	return s.metaStore.GetBlockStoreAddr(ctx, empty)
+}
 
-	return s.metaStore.GetFileInfoMap(ctx, empty)
+func (s *RaftSurfstore) GetBlockStoreMap(ctx context.Context, hashes *BlockHashes) (*BlockStoreMap, error) {
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.metaStore.GetBlockStoreMap(ctx, hashes)
 }
 
-func (s *RaftSurfstore) GetBlockStoreAddr(ctx context.Context, empty *emptypb.Empty) (*BlockStoreAddr, error) {
-	s.isCrashedMutex.RLock()
-	isCrashed := s.isCrashed
-	s.isCrashedMutex.RUnlock()
-	if isCrashed {
-		return nil, ERR_SERVER_CRASHED
+func (s *RaftSurfstore) GetFileHistory(ctx context.Context, fileVersion *FileVersion) (*FileHistory, error) {
+	if strings.Contains(fileVersion.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
+	}
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
 	}
 
-	s.isLeaderMutex.RLock()
-	isLeader := s.isLeader
-	s.isLeaderMutex.RUnlock()
-	if !isLeader {
-		return nil, ERR_NOT_LEADER
+	return s.metaStore.GetFileHistory(ctx, fileVersion)
+}
+
+func (s *RaftSurfstore) GetFileVersion(ctx context.Context, fileVersion *FileVersion) (*FileMetaData, error) {
+	if strings.Contains(fileVersion.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
+	}
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
 	}
 
-	for {
-		majorityAlive, _ := s.SendHeartbeat(ctx, empty)
-		if majorityAlive.Flag {
-			break
-		}
+	return s.metaStore.GetFileVersion(ctx, fileVersion)
+}
+
+func (s *RaftSurfstore) GetChangesSince(ctx context.Context, cursor *Cursor) (*FileChanges, error) {
+	if err := s.waitForActiveLeader(ctx); err != nil {
+		return nil, err
 	}
 
-	//if a majority of the nodes are working, should return the correct answer;
-	//if a majority of the nodes are crashed, should block until a majority recover.
+	return s.metaStore.GetChangesSince(ctx, cursor)
+}
 
-	return s.metaStore.GetBlockStoreAddr(ctx, empty)
+// Watch streams the changes the leader applies until the client goes away, or
+// the server crashes or stops being the leader
+func (s *RaftSurfstore) Watch(request *WatchRequest, stream RaftSurfstore_WatchServer) error {
+	if err := s.waitForActiveLeader(stream.Context()); err != nil {
+		return err
+	}
+
+	return s.metaStore.watch(stream.Context(), request, stream.Send, s.rpcTimeout, s.checkActiveLeader)
 }
 
 func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData) (*Version, error) {
-	op := UpdateOperation{
-		Term:         s.term,
-		FileMetaData: filemeta,
+	if strings.Contains(filemeta.Filename, NAMESPACE_SEPARATOR) {
+		return nil, ERR_INVALID_PATH
 	}
-
-	s.isCrashedMutex.RLock()
-	isCrashed := s.isCrashed
-	s.isCrashedMutex.RUnlock()
-	if isCrashed {
-		return nil, ERR_SERVER_CRASHED
+	if err := s.checkActiveLeader(); err != nil {
+		return nil, err
 	}
 
-	s.isLeaderMutex.RLock()
-	isLeader := s.isLeader
-	s.isLeaderMutex.RUnlock()
-	if !isLeader {
-		return nil, ERR_NOT_LEADER
+	// Every entry of the log carries the epoch of its first entry, so that the
+	// replicas number their changes alike whichever of them is leader. The
+	// first entry keeps the epoch of the cursors this leader handed out while
+	// the log was empty.
+	epoch := s.metaStore.currentEpoch()
+	if len(s.log) > 0 {
+		epoch = s.log[0].Epoch
+	}
+	op := UpdateOperation{
+		Term:         s.term,
+		FileMetaData: filemeta,
+		Namespace:    namespaceFromContext(ctx),
+		Epoch:        epoch,
 	}
 
 	s.log = append(s.log, &op)
@@ -133,6 +156,7 @@ func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData)
 	success := <-committed
 	if success {
 		s.lastApplied = s.commitIndex
+		s.metaStore.setEpoch(op.Epoch)
 		return s.metaStore.UpdateFile(ctx, filemeta)
 	}
 
@@ -184,7 +208,7 @@ func (s *RaftSurfstore) commitEntry(serverIdx, entryIdx int64, commitChan chan *
 		}
 
 		addr := s.ipList[serverIdx]
-		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(addr, s.dialOptions...)
 		if err != nil {
 			return
 		}
@@ -205,7 +229,7 @@ func (s *RaftSurfstore) commitEntry(serverIdx, entryIdx int64, commitChan chan *
 			LeaderCommit: s.commitIndex,
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), s.rpcTimeout)
 		defer cancel()
 		output, _ := client.AppendEntries(ctx, input)
 
@@ -299,7 +323,8 @@ func (s *RaftSurfstore) AppendEntries(ctx context.Context, input *AppendEntryInp
 		for s.lastApplied < s.commitIndex {
 			s.lastApplied++
 			entry := s.log[s.lastApplied]
-			s.metaStore.UpdateFile(ctx, entry.FileMetaData)
+			s.metaStore.setEpoch(entry.Epoch)
+			s.metaStore.UpdateFile(withNamespace(ctx, entry.Namespace), entry.FileMetaData)
 		}
 	}
 	output.Success = true
@@ -346,7 +371,7 @@ func (s *RaftSurfstore) SendHeartbeat(ctx context.Context, _ *emptypb.Empty) (*S
 			continue
 		}
 
-		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(addr, s.dialOptions...)
 		if err != nil {
 			return &Success{Flag: false}, nil
 		}
@@ -368,7 +393,7 @@ func (s *RaftSurfstore) SendHeartbeat(ctx context.Context, _ *emptypb.Empty) (*S
 			LeaderCommit: s.commitIndex,
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), s.rpcTimeout)
 		defer cancel()
 		output, _ := client.AppendEntries(ctx, input)
 		if output != nil {
@@ -381,6 +406,40 @@ func (s *RaftSurfstore) SendHeartbeat(ctx context.Context, _ *emptypb.Empty) (*S
 	return &Success{Flag: majorityAlive}, nil
 }
 
+// checkActiveLeader fails with ERR_SERVER_CRASHED or ERR_NOT_LEADER unless
+// this server is an uncrashed leader
+func (s *RaftSurfstore) checkActiveLeader() error {
+	s.isCrashedMutex.RLock()
+	isCrashed := s.isCrashed
+	s.isCrashedMutex.RUnlock()
+	if isCrashed {
+		return ERR_SERVER_CRASHED
+	}
+
+	s.isLeaderMutex.RLock()
+	isLeader := s.isLeader
+	s.isLeaderMutex.RUnlock()
+	if !isLeader {
+		return ERR_NOT_LEADER
+	}
+	return nil
+}
+
+// waitForActiveLeader fails like checkActiveLeader, and otherwise blocks until
+// a majority of the servers answer, so that reads are only served by a leader
+// that can still reach its followers
+func (s *RaftSurfstore) waitForActiveLeader(ctx context.Context) error {
+	if err := s.checkActiveLeader(); err != nil {
+		return err
+	}
+	for {
+		majorityAlive, _ := s.SendHeartbeat(ctx, &emptypb.Empty{})
+		if majorityAlive.Flag {
+			return nil
+		}
+	}
+}
+
 func (s *RaftSurfstore) Crash(ctx context.Context, _ *emptypb.Empty) (*Success, error) {
 	s.isCrashedMutex.Lock()
 	s.isCrashed = true
@@ -403,7 +462,7 @@ func (s *RaftSurfstore) IsCrashed(ctx context.Context, _ *emptypb.Empty) (*Crash
 }
 
 func (s *RaftSurfstore) GetInternalState(ctx context.Context, empty *emptypb.Empty) (*RaftInternalState, error) {
-	fileInfoMap, _ := s.metaStore.GetFileInfoMap(ctx, empty)
+	fileInfoMap := s.metaStore.allFiles()
 	return &RaftInternalState{
 		IsLeader: s.isLeader,
 		Term:     s.term,
@@ -1,89 +1,149 @@
 package surfstore
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"io"
 	"log"
 	"net"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
-func LoadRaftConfigFile(filename string) (ipList []string) {
-	configFD, e := os.Open(filename)
-	if e != nil {
-		log.Fatal("Error Open config file:", e)
-	}
-	defer configFD.Close()
-
-	configReader := bufio.NewReader(configFD)
-	serverCount := 0
-
-	for index := 0; ; index++ {
-		lineContent, _, e := configReader.ReadLine()
-		if e != nil && e != io.EOF {
-			log.Fatal("Error During Reading Config", e)
-		}
-
-		if e == io.EOF {
-			return
-		}
+// Methods of the RaftSurfstore service between the Raft servers and for
+// testing, which are not served on a separate client address
+var PEER_METHODS = map[string]bool{
+	"AppendEntries":    true,
+	"SetLeader":        true,
+	"SendHeartbeat":    true,
+	"GetInternalState": true,
+	"IsCrashed":        true,
+	"Crash":            true,
+	"Restore":          true,
+}
 
-		lineString := string(lineContent)
-		splitRes := strings.Split(lineString, ": ")
-		if index == 0 {
-			serverCount, _ = strconv.Atoi(splitRes[1])
-			ipList = make([]string, serverCount, serverCount)
-		} else {
-			ipList[index-1] = splitRes[1]
-		}
+// LoadRaftConfigFile returns the Raft server addresses listed in a cluster
+// config file and exits if the file cannot be loaded. Use LoadClusterConfig
+// to handle errors or to read the rest of the configuration.
+func LoadRaftConfigFile(filename string) (ipList []string) {
+	config, err := LoadClusterConfig(filename)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	return
+	return config.RaftAddrs()
 }
 
-func NewRaftServer(id int64, ips []string, blockStoreAddr string) (*RaftSurfstore, error) {
-	// TODO any initialization you need to do here
+func NewRaftServer(id int64, config *ClusterConfig) (*RaftSurfstore, error) {
+	if id < 0 || id >= int64(len(config.Servers)) {
+		return nil, fmt.Errorf("server id %d not in config", id)
+	}
+	if len(config.BlockStores) == 0 {
+		return nil, fmt.Errorf("config has no blockStores")
+	}
+	if config.Replicas() > len(config.BlockStores) {
+		return nil, fmt.Errorf("replicationFactor %d exceeds the %d blockStores", config.Replicas(), len(config.BlockStores))
+	}
+	if err := config.TLS.Load(); err != nil {
+		return nil, err
+	}
+	if config.TLS.HasPeerIdentity() && config.Servers[id].RaftAddr == config.Servers[id].ClientAddr {
+		return nil, fmt.Errorf("server %d needs a clientAddr of its own to use separate tls peer certificates", id)
+	}
 
 	isCrashedMutex := &sync.RWMutex{}
 
 	server := RaftSurfstore{
-		// TODO initialize any fields you add here
-		ip:       ips[id],
-		ipList:   ips,
-		serverId: id,
+		ip:         config.Servers[id].RaftAddr,
+		clientAddr: config.Servers[id].ClientAddr,
+		ipList:     config.RaftAddrs(),
+		serverId:   id,
+		rpcTimeout: config.RPCTimeout(),
+
+		authenticator: NewAuthenticator(config.Auth),
+		dialOptions:   peerDialOptions(config),
+
+		peerCredentials:   config.TLS.PeerServerCredentials(),
+		clientCredentials: config.TLS.ServerCredentials(),
 
 		commitIndex: -1,
 		lastApplied: -1,
 
 		isLeader:       false,
 		term:           0,
-		metaStore:      NewMetaStore(blockStoreAddr),
+		metaStore:      NewMetaStore(config.BlockStoreAddrs(), config.VirtualNodes),
 		log:            make([]*UpdateOperation, 0),
 		isCrashed:      false,
 		notCrashedCond: sync.NewCond(isCrashedMutex),
 		isCrashedMutex: isCrashedMutex,
 	}
 
+	server.metaStore.ReplicationFactor = config.Replicas()
+	server.metaStore.HistoryLength = config.HistoryVersions()
+	isActiveLeader := func() bool { return server.checkActiveLeader() == nil }
+	server.blockStoreMonitor = NewBlockStoreMonitor(server.metaStore, config, isActiveLeader)
+	server.blockCollector = NewBlockGarbageCollector(server.metaStore, config, isActiveLeader)
+
 	return &server, nil
 }
 
-// TODO Start up the Raft server and any services here
+// ServeRaftServer serves the RaftSurfstore service on the server's Raft
+// address, and also on its client address when the two differ. The client
+// address refuses the PEER_METHODS.
 func ServeRaftServer(server *RaftSurfstore) error {
-	grpcServer := grpc.NewServer()
-	RegisterRaftSurfstoreServer(grpcServer, server)
+	addrs := []string{server.ip}
+	if server.clientAddr != server.ip {
+		addrs = append(addrs, server.clientAddr)
+	}
 
-	lis, err := net.Listen("tcp", server.ip)
-	if err != nil {
-		return fmt.Errorf("failed to listen: %v", err)
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("failed to listen: %v", err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	go server.blockStoreMonitor.Run()
+	go server.blockCollector.Run()
+
+	// A shared address serves client traffic, NewRaftServer makes sure
+	// there are no separate peer credentials then
+	creds := []credentials.TransportCredentials{server.clientCredentials}
+	if len(listeners) > 1 {
+		creds = []credentials.TransportCredentials{server.peerCredentials, server.clientCredentials}
+	}
+
+	errChan := make(chan error, len(listeners))
+	for i, lis := range listeners {
+		opts := append(server.authenticator.ServerOptions(), grpc.Creds(creds[i]))
+		if i > 0 {
+			opts = append(opts, grpc.ChainUnaryInterceptor(refusePeerMethods))
+		}
+		grpcServer := grpc.NewServer(opts...)
+		RegisterRaftSurfstoreServer(grpcServer, server)
+		go func(lis net.Listener) {
+			errChan <- grpcServer.Serve(lis)
+		}(lis)
 	}
-	if err := grpcServer.Serve(lis); err != nil {
+	if err := <-errChan; err != nil {
 		return fmt.Errorf("failed to serve: %v", err)
 	}
 	return nil
 }
+
+// refusePeerMethods fails the calls to PEER_METHODS on a client address
+func refusePeerMethods(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	if PEER_METHODS[method] {
+		return nil, status.Errorf(codes.Unimplemented, "%s is only served on the Raft address", method)
+	}
+	return handler(ctx, req)
+}
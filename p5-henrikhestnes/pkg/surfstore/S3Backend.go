@@ -0,0 +1,297 @@
+package surfstore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const DEFAULT_S3_REGION string = "us-east-1"
+
+const S3_CODEC_HEADER string = "x-amz-meta-codec"
+
+// S3Backend keeps blocks as objects in a bucket of an S3 compatible object
+// store such as MinIO. Objects are addressed path-style, <endpoint>/<bucket>/<hash>,
+// and requests are signed with AWS Signature Version 4. The codec of a
+// compressed block is kept in the object's x-amz-meta-codec metadata.
+type S3Backend struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Backend) Get(hash string) (*Block, error) {
+	resp, err := s.do(http.MethodGet, hash, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ERR_BLOCK_NOT_FOUND
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error("get", hash, resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading block %s: %v", hash, err)
+	}
+	return &Block{BlockData: data, BlockSize: int32(len(data)), Codec: resp.Header.Get(S3_CODEC_HEADER)}, nil
+}
+
+func (s *S3Backend) Put(hash string, block *Block) error {
+	header := http.Header{}
+	if block.Codec != CODEC_NONE {
+		header.Set(S3_CODEC_HEADER, block.Codec)
+	}
+	resp, err := s.do(http.MethodPut, hash, nil, header, block.BlockData)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", hash, resp)
+	}
+	return nil
+}
+
+func (s *S3Backend) Has(hash string) (bool, error) {
+	resp, err := s.do(http.MethodHead, hash, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3Error("head", hash, resp)
+}
+
+func (s *S3Backend) Delete(hash string) error {
+	resp, err := s.do(http.MethodDelete, hash, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("delete", hash, resp)
+	}
+	return nil
+}
+
+func (s *S3Backend) Iterate(fn func(hash string) error) error {
+	return s.list(func(hash string, size int64) error {
+		return fn(hash)
+	})
+}
+
+func (s *S3Backend) Stats() (BackendStats, error) {
+	var stats BackendStats
+	err := s.list(func(hash string, size int64) error {
+		stats.Blocks++
+		stats.Bytes += size
+		return nil
+	})
+	return stats, err
+}
+
+// list pages through the bucket with ListObjectsV2
+func (s *S3Backend) list(fn func(hash string, size int64) error) error {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error("list", s.Bucket, resp)
+			resp.Body.Close()
+			return err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error decoding bucket listing: %v", err)
+		}
+
+		for _, object := range result.Contents {
+			if !isBlockHash(object.Key) {
+				continue
+			}
+			if err := fn(object.Key, object.Size); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// createBucket creates the bucket unless it already exists
+func (s *S3Backend) createBucket() error {
+	resp, err := s.do(http.MethodHead, "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = s.do(http.MethodPut, "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("create bucket", s.Bucket, resp)
+	}
+	return nil
+}
+
+// do sends a signed request for an object, or for the bucket if key is empty
+func (s *S3Backend) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	target := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket
+	if key != "" {
+		target += "/" + key
+	}
+	rawQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, rawQuery, body, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting object store: %v", err)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *S3Backend) sign(req *http.Request, rawQuery string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Sign the host and every x-amz-* header
+	canonicalHeaders := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			canonicalHeaders[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(canonicalHeaders))
+	for name := range canonicalHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headerLines strings.Builder
+	for _, name := range names {
+		headerLines.WriteString(name + ":" + canonicalHeaders[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		rawQuery,
+		headerLines.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3Error(op string, name string, resp *http.Response) error {
+	message, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("object store %s %s failed: %s %s", op, name, resp.Status, strings.TrimSpace(string(message)))
+}
+
+var _ BlockBackend = new(S3Backend)
+
+// NewS3Backend connects to the object store at endpoint and creates the bucket
+// if it does not exist yet.
+func NewS3Backend(endpoint string, bucket string, region string, accessKey string, secretKey string) (*S3Backend, error) {
+	if region == "" {
+		region = DEFAULT_S3_REGION
+	}
+	backend := &S3Backend{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := backend.createBucket(); err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
@@ -21,8 +21,38 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type FileType int32
+
+const (
+	FileType_REGULAR   FileType = 0
+	FileType_DIRECTORY FileType = 1
+	FileType_SYMLINK   FileType = 2
+)
+
+var FileType_name = map[int32]string{
+	0: "REGULAR",
+	1: "DIRECTORY",
+	2: "SYMLINK",
+}
+
+var FileType_value = map[string]int32{
+	"REGULAR":   0,
+	"DIRECTORY": 1,
+	"SYMLINK":   2,
+}
+
+func (x FileType) String() string {
+	return proto.EnumName(FileType_name, int32(x))
+}
+
+func (FileType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{0}
+}
+
 type BlockHash struct {
-	Hash                 string   `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Hash string `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	// Codecs the caller can decompress, the block is returned uncompressed otherwise
+	Codecs               []string `protobuf:"bytes,2,rep,name=codecs,proto3" json:"codecs,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -60,8 +90,18 @@ func (m *BlockHash) GetHash() string {
 	return ""
 }
 
+func (m *BlockHash) GetCodecs() []string {
+	if m != nil {
+		return m.Codecs
+	}
+	return nil
+}
+
 type BlockHashes struct {
-	Hashes               []string `protobuf:"bytes,1,rep,name=hashes,proto3" json:"hashes,omitempty"`
+	Hashes []string `protobuf:"bytes,1,rep,name=hashes,proto3" json:"hashes,omitempty"`
+	// GetBlocks: codecs the caller can decompress
+	// HasBlocks reply: codecs the BlockStore accepts in PutBlock
+	Codecs               []string `protobuf:"bytes,2,rep,name=codecs,proto3" json:"codecs,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -99,9 +139,67 @@ func (m *BlockHashes) GetHashes() []string {
 	return nil
 }
 
+func (m *BlockHashes) GetCodecs() []string {
+	if m != nil {
+		return m.Codecs
+	}
+	return nil
+}
+
+type DeleteBlocksRequest struct {
+	Hashes []string `protobuf:"bytes,1,rep,name=hashes,proto3" json:"hashes,omitempty"`
+	// Blocks written less than this many milliseconds ago are kept
+	GracePeriodMs        int64    `protobuf:"varint,2,opt,name=gracePeriodMs,proto3" json:"gracePeriodMs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteBlocksRequest) Reset()         { *m = DeleteBlocksRequest{} }
+func (m *DeleteBlocksRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteBlocksRequest) ProtoMessage()    {}
+func (*DeleteBlocksRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{2}
+}
+
+func (m *DeleteBlocksRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteBlocksRequest.Unmarshal(m, b)
+}
+func (m *DeleteBlocksRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteBlocksRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteBlocksRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteBlocksRequest.Merge(m, src)
+}
+func (m *DeleteBlocksRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteBlocksRequest.Size(m)
+}
+func (m *DeleteBlocksRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteBlocksRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteBlocksRequest proto.InternalMessageInfo
+
+func (m *DeleteBlocksRequest) GetHashes() []string {
+	if m != nil {
+		return m.Hashes
+	}
+	return nil
+}
+
+func (m *DeleteBlocksRequest) GetGracePeriodMs() int64 {
+	if m != nil {
+		return m.GracePeriodMs
+	}
+	return 0
+}
+
 type Block struct {
-	BlockData            []byte   `protobuf:"bytes,1,opt,name=blockData,proto3" json:"blockData,omitempty"`
-	BlockSize            int32    `protobuf:"varint,2,opt,name=blockSize,proto3" json:"blockSize,omitempty"`
+	BlockData []byte `protobuf:"bytes,1,opt,name=blockData,proto3" json:"blockData,omitempty"`
+	// Size of the uncompressed data
+	BlockSize int32 `protobuf:"varint,2,opt,name=blockSize,proto3" json:"blockSize,omitempty"`
+	// Compression of blockData, empty if it is uncompressed
+	Codec                string   `protobuf:"bytes,3,opt,name=codec,proto3" json:"codec,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -111,7 +209,7 @@ func (m *Block) Reset()         { *m = Block{} }
 func (m *Block) String() string { return proto.CompactTextString(m) }
 func (*Block) ProtoMessage()    {}
 func (*Block) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{2}
+	return fileDescriptor_74dffadc931fead4, []int{3}
 }
 
 func (m *Block) XXX_Unmarshal(b []byte) error {
@@ -146,6 +244,13 @@ func (m *Block) GetBlockSize() int32 {
 	return 0
 }
 
+func (m *Block) GetCodec() string {
+	if m != nil {
+		return m.Codec
+	}
+	return ""
+}
+
 type Success struct {
 	Flag                 bool     `protobuf:"varint,1,opt,name=flag,proto3" json:"flag,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -157,7 +262,7 @@ func (m *Success) Reset()         { *m = Success{} }
 func (m *Success) String() string { return proto.CompactTextString(m) }
 func (*Success) ProtoMessage()    {}
 func (*Success) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{3}
+	return fileDescriptor_74dffadc931fead4, []int{4}
 }
 
 func (m *Success) XXX_Unmarshal(b []byte) error {
@@ -186,9 +291,17 @@ func (m *Success) GetFlag() bool {
 }
 
 type FileMetaData struct {
-	Filename             string   `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
-	Version              int32    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
-	BlockHashList        []string `protobuf:"bytes,3,rep,name=blockHashList,proto3" json:"blockHashList,omitempty"`
+	Filename      string   `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	Version       int32    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	BlockHashList []string `protobuf:"bytes,3,rep,name=blockHashList,proto3" json:"blockHashList,omitempty"`
+	// Permission bits, 0 if unknown
+	Mode uint32 `protobuf:"varint,4,opt,name=mode,proto3" json:"mode,omitempty"`
+	// Modification time in nanoseconds since the Unix epoch, 0 if unknown
+	Mtime         int64    `protobuf:"varint,5,opt,name=mtime,proto3" json:"mtime,omitempty"`
+	FileType      FileType `protobuf:"varint,6,opt,name=fileType,proto3,enum=surfstore.FileType" json:"fileType,omitempty"`
+	SymlinkTarget string   `protobuf:"bytes,7,opt,name=symlinkTarget,proto3" json:"symlinkTarget,omitempty"`
+	// How the file was cut into blocks, e.g. fixed/4096, empty if unknown
+	Chunking             string   `protobuf:"bytes,8,opt,name=chunking,proto3" json:"chunking,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -198,7 +311,7 @@ func (m *FileMetaData) Reset()         { *m = FileMetaData{} }
 func (m *FileMetaData) String() string { return proto.CompactTextString(m) }
 func (*FileMetaData) ProtoMessage()    {}
 func (*FileMetaData) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{4}
+	return fileDescriptor_74dffadc931fead4, []int{5}
 }
 
 func (m *FileMetaData) XXX_Unmarshal(b []byte) error {
@@ -240,18 +353,58 @@ func (m *FileMetaData) GetBlockHashList() []string {
 	return nil
 }
 
+func (m *FileMetaData) GetMode() uint32 {
+	if m != nil {
+		return m.Mode
+	}
+	return 0
+}
+
+func (m *FileMetaData) GetMtime() int64 {
+	if m != nil {
+		return m.Mtime
+	}
+	return 0
+}
+
+func (m *FileMetaData) GetFileType() FileType {
+	if m != nil {
+		return m.FileType
+	}
+	return FileType_REGULAR
+}
+
+func (m *FileMetaData) GetSymlinkTarget() string {
+	if m != nil {
+		return m.SymlinkTarget
+	}
+	return ""
+}
+
+func (m *FileMetaData) GetChunking() string {
+	if m != nil {
+		return m.Chunking
+	}
+	return ""
+}
+
 type FileInfoMap struct {
-	FileInfoMap          map[string]*FileMetaData `protobuf:"bytes,1,rep,name=fileInfoMap,proto3" json:"fileInfoMap,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	XXX_NoUnkeyedLiteral struct{}                 `json:"-"`
-	XXX_unrecognized     []byte                   `json:"-"`
-	XXX_sizecache        int32                    `json:"-"`
+	FileInfoMap map[string]*FileMetaData `protobuf:"bytes,1,rep,name=fileInfoMap,proto3" json:"fileInfoMap,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Position of the map in the MetaStore's changes
+	Cursor *Cursor `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	// Namespace the files are in, the one the server picked if the caller
+	// named none
+	Namespace            string   `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *FileInfoMap) Reset()         { *m = FileInfoMap{} }
 func (m *FileInfoMap) String() string { return proto.CompactTextString(m) }
 func (*FileInfoMap) ProtoMessage()    {}
 func (*FileInfoMap) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{5}
+	return fileDescriptor_74dffadc931fead4, []int{6}
 }
 
 func (m *FileInfoMap) XXX_Unmarshal(b []byte) error {
@@ -279,6 +432,118 @@ func (m *FileInfoMap) GetFileInfoMap() map[string]*FileMetaData {
 	return nil
 }
 
+func (m *FileInfoMap) GetCursor() *Cursor {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
+func (m *FileInfoMap) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+type Cursor struct {
+	// Changes are numbered anew each time a MetaStore or Raft cluster starts,
+	// with a new epoch
+	Epoch int64 `protobuf:"varint,1,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	// Index of the latest change included
+	Index                int64    `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Cursor) Reset()         { *m = Cursor{} }
+func (m *Cursor) String() string { return proto.CompactTextString(m) }
+func (*Cursor) ProtoMessage()    {}
+func (*Cursor) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{7}
+}
+
+func (m *Cursor) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Cursor.Unmarshal(m, b)
+}
+func (m *Cursor) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Cursor.Marshal(b, m, deterministic)
+}
+func (m *Cursor) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Cursor.Merge(m, src)
+}
+func (m *Cursor) XXX_Size() int {
+	return xxx_messageInfo_Cursor.Size(m)
+}
+func (m *Cursor) XXX_DiscardUnknown() {
+	xxx_messageInfo_Cursor.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Cursor proto.InternalMessageInfo
+
+func (m *Cursor) GetEpoch() int64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *Cursor) GetIndex() int64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+type FileChanges struct {
+	// The latest version of each file changed after the cursor
+	FileMetaData         []*FileMetaData `protobuf:"bytes,1,rep,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
+	Cursor               *Cursor         `protobuf:"bytes,2,opt,name=cursor,proto3" json:"cursor,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *FileChanges) Reset()         { *m = FileChanges{} }
+func (m *FileChanges) String() string { return proto.CompactTextString(m) }
+func (*FileChanges) ProtoMessage()    {}
+func (*FileChanges) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{8}
+}
+
+func (m *FileChanges) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileChanges.Unmarshal(m, b)
+}
+func (m *FileChanges) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileChanges.Marshal(b, m, deterministic)
+}
+func (m *FileChanges) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileChanges.Merge(m, src)
+}
+func (m *FileChanges) XXX_Size() int {
+	return xxx_messageInfo_FileChanges.Size(m)
+}
+func (m *FileChanges) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileChanges.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileChanges proto.InternalMessageInfo
+
+func (m *FileChanges) GetFileMetaData() []*FileMetaData {
+	if m != nil {
+		return m.FileMetaData
+	}
+	return nil
+}
+
+func (m *FileChanges) GetCursor() *Cursor {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
 type Version struct {
 	Version              int32    `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -290,7 +555,7 @@ func (m *Version) Reset()         { *m = Version{} }
 func (m *Version) String() string { return proto.CompactTextString(m) }
 func (*Version) ProtoMessage()    {}
 func (*Version) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{6}
+	return fileDescriptor_74dffadc931fead4, []int{9}
 }
 
 func (m *Version) XXX_Unmarshal(b []byte) error {
@@ -318,6 +583,201 @@ func (m *Version) GetVersion() int32 {
 	return 0
 }
 
+type FileVersion struct {
+	Filename string `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	// Ignored by GetFileHistory
+	Version              int32    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileVersion) Reset()         { *m = FileVersion{} }
+func (m *FileVersion) String() string { return proto.CompactTextString(m) }
+func (*FileVersion) ProtoMessage()    {}
+func (*FileVersion) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{10}
+}
+
+func (m *FileVersion) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileVersion.Unmarshal(m, b)
+}
+func (m *FileVersion) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileVersion.Marshal(b, m, deterministic)
+}
+func (m *FileVersion) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileVersion.Merge(m, src)
+}
+func (m *FileVersion) XXX_Size() int {
+	return xxx_messageInfo_FileVersion.Size(m)
+}
+func (m *FileVersion) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileVersion.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileVersion proto.InternalMessageInfo
+
+func (m *FileVersion) GetFilename() string {
+	if m != nil {
+		return m.Filename
+	}
+	return ""
+}
+
+func (m *FileVersion) GetVersion() int32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type FileHistory struct {
+	Versions             []*FileMetaData `protobuf:"bytes,1,rep,name=versions,proto3" json:"versions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *FileHistory) Reset()         { *m = FileHistory{} }
+func (m *FileHistory) String() string { return proto.CompactTextString(m) }
+func (*FileHistory) ProtoMessage()    {}
+func (*FileHistory) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{11}
+}
+
+func (m *FileHistory) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileHistory.Unmarshal(m, b)
+}
+func (m *FileHistory) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileHistory.Marshal(b, m, deterministic)
+}
+func (m *FileHistory) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileHistory.Merge(m, src)
+}
+func (m *FileHistory) XXX_Size() int {
+	return xxx_messageInfo_FileHistory.Size(m)
+}
+func (m *FileHistory) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileHistory.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileHistory proto.InternalMessageInfo
+
+func (m *FileHistory) GetVersions() []*FileMetaData {
+	if m != nil {
+		return m.Versions
+	}
+	return nil
+}
+
+type WatchRequest struct {
+	// Index of the first change to send, changes are numbered from 1.
+	// 0 sends only the changes made after the call.
+	FromIndex int64 `protobuf:"varint,1,opt,name=fromIndex,proto3" json:"fromIndex,omitempty"`
+	// Epoch of the cursor fromIndex continues from, must match the
+	// MetaStore's unless fromIndex is 0
+	Epoch                int64    `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
+func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRequest) ProtoMessage()    {}
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{12}
+}
+
+func (m *WatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchRequest.Unmarshal(m, b)
+}
+func (m *WatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchRequest.Merge(m, src)
+}
+func (m *WatchRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchRequest.Size(m)
+}
+func (m *WatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchRequest proto.InternalMessageInfo
+
+func (m *WatchRequest) GetFromIndex() int64 {
+	if m != nil {
+		return m.FromIndex
+	}
+	return 0
+}
+
+func (m *WatchRequest) GetEpoch() int64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type FileChange struct {
+	// Position of the update among all updates the MetaStore accepted
+	Index        int64         `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	FileMetaData *FileMetaData `protobuf:"bytes,2,opt,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
+	// Namespace of the file, the default namespace if empty
+	Namespace            string   `protobuf:"bytes,3,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileChange) Reset()         { *m = FileChange{} }
+func (m *FileChange) String() string { return proto.CompactTextString(m) }
+func (*FileChange) ProtoMessage()    {}
+func (*FileChange) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{13}
+}
+
+func (m *FileChange) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileChange.Unmarshal(m, b)
+}
+func (m *FileChange) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileChange.Marshal(b, m, deterministic)
+}
+func (m *FileChange) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileChange.Merge(m, src)
+}
+func (m *FileChange) XXX_Size() int {
+	return xxx_messageInfo_FileChange.Size(m)
+}
+func (m *FileChange) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileChange.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileChange proto.InternalMessageInfo
+
+func (m *FileChange) GetIndex() int64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *FileChange) GetFileMetaData() *FileMetaData {
+	if m != nil {
+		return m.FileMetaData
+	}
+	return nil
+}
+
+func (m *FileChange) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
 type BlockStoreAddr struct {
 	Addr                 string   `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -329,7 +789,7 @@ func (m *BlockStoreAddr) Reset()         { *m = BlockStoreAddr{} }
 func (m *BlockStoreAddr) String() string { return proto.CompactTextString(m) }
 func (*BlockStoreAddr) ProtoMessage()    {}
 func (*BlockStoreAddr) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{7}
+	return fileDescriptor_74dffadc931fead4, []int{14}
 }
 
 func (m *BlockStoreAddr) XXX_Unmarshal(b []byte) error {
@@ -357,6 +817,45 @@ func (m *BlockStoreAddr) GetAddr() string {
 	return ""
 }
 
+type BlockStoreMap struct {
+	BlockStoreMap        map[string]*BlockHashes `protobuf:"bytes,1,rep,name=blockStoreMap,proto3" json:"blockStoreMap,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}                `json:"-"`
+	XXX_unrecognized     []byte                  `json:"-"`
+	XXX_sizecache        int32                   `json:"-"`
+}
+
+func (m *BlockStoreMap) Reset()         { *m = BlockStoreMap{} }
+func (m *BlockStoreMap) String() string { return proto.CompactTextString(m) }
+func (*BlockStoreMap) ProtoMessage()    {}
+func (*BlockStoreMap) Descriptor() ([]byte, []int) {
+	return fileDescriptor_74dffadc931fead4, []int{15}
+}
+
+func (m *BlockStoreMap) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockStoreMap.Unmarshal(m, b)
+}
+func (m *BlockStoreMap) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockStoreMap.Marshal(b, m, deterministic)
+}
+func (m *BlockStoreMap) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockStoreMap.Merge(m, src)
+}
+func (m *BlockStoreMap) XXX_Size() int {
+	return xxx_messageInfo_BlockStoreMap.Size(m)
+}
+func (m *BlockStoreMap) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockStoreMap.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockStoreMap proto.InternalMessageInfo
+
+func (m *BlockStoreMap) GetBlockStoreMap() map[string]*BlockHashes {
+	if m != nil {
+		return m.BlockStoreMap
+	}
+	return nil
+}
+
 type CrashedState struct {
 	IsCrashed            bool     `protobuf:"varint,1,opt,name=isCrashed,proto3" json:"isCrashed,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -368,7 +867,7 @@ func (m *CrashedState) Reset()         { *m = CrashedState{} }
 func (m *CrashedState) String() string { return proto.CompactTextString(m) }
 func (*CrashedState) ProtoMessage()    {}
 func (*CrashedState) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{8}
+	return fileDescriptor_74dffadc931fead4, []int{16}
 }
 
 func (m *CrashedState) XXX_Unmarshal(b []byte) error {
@@ -411,7 +910,7 @@ func (m *AppendEntryInput) Reset()         { *m = AppendEntryInput{} }
 func (m *AppendEntryInput) String() string { return proto.CompactTextString(m) }
 func (*AppendEntryInput) ProtoMessage()    {}
 func (*AppendEntryInput) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{9}
+	return fileDescriptor_74dffadc931fead4, []int{17}
 }
 
 func (m *AppendEntryInput) XXX_Unmarshal(b []byte) error {
@@ -481,7 +980,7 @@ func (m *AppendEntryOutput) Reset()         { *m = AppendEntryOutput{} }
 func (m *AppendEntryOutput) String() string { return proto.CompactTextString(m) }
 func (*AppendEntryOutput) ProtoMessage()    {}
 func (*AppendEntryOutput) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{10}
+	return fileDescriptor_74dffadc931fead4, []int{18}
 }
 
 func (m *AppendEntryOutput) XXX_Unmarshal(b []byte) error {
@@ -531,18 +1030,23 @@ func (m *AppendEntryOutput) GetMatchedIndex() int64 {
 }
 
 type UpdateOperation struct {
-	Term                 int64         `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	FileMetaData         *FileMetaData `protobuf:"bytes,3,opt,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
-	XXX_unrecognized     []byte        `json:"-"`
-	XXX_sizecache        int32         `json:"-"`
+	Term         int64         `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	FileMetaData *FileMetaData `protobuf:"bytes,3,opt,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
+	// Namespace of the file, the default namespace if empty
+	Namespace string `protobuf:"bytes,4,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Epoch of the changes, picked by the leader that appended the first
+	// entry of the log and shared by every replica
+	Epoch                int64    `protobuf:"varint,5,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *UpdateOperation) Reset()         { *m = UpdateOperation{} }
 func (m *UpdateOperation) String() string { return proto.CompactTextString(m) }
 func (*UpdateOperation) ProtoMessage()    {}
 func (*UpdateOperation) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{11}
+	return fileDescriptor_74dffadc931fead4, []int{19}
 }
 
 func (m *UpdateOperation) XXX_Unmarshal(b []byte) error {
@@ -577,6 +1081,20 @@ func (m *UpdateOperation) GetFileMetaData() *FileMetaData {
 	return nil
 }
 
+func (m *UpdateOperation) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *UpdateOperation) GetEpoch() int64 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
 type RaftInternalState struct {
 	IsLeader             bool               `protobuf:"varint,1,opt,name=isLeader,proto3" json:"isLeader,omitempty"`
 	Term                 int64              `protobuf:"varint,2,opt,name=term,proto3" json:"term,omitempty"`
@@ -591,7 +1109,7 @@ func (m *RaftInternalState) Reset()         { *m = RaftInternalState{} }
 func (m *RaftInternalState) String() string { return proto.CompactTextString(m) }
 func (*RaftInternalState) ProtoMessage()    {}
 func (*RaftInternalState) Descriptor() ([]byte, []int) {
-	return fileDescriptor_74dffadc931fead4, []int{12}
+	return fileDescriptor_74dffadc931fead4, []int{20}
 }
 
 func (m *RaftInternalState) XXX_Unmarshal(b []byte) error {
@@ -641,15 +1159,25 @@ func (m *RaftInternalState) GetMetaMap() *FileInfoMap {
 }
 
 func init() {
+	proto.RegisterEnum("surfstore.FileType", FileType_name, FileType_value)
 	proto.RegisterType((*BlockHash)(nil), "surfstore.BlockHash")
 	proto.RegisterType((*BlockHashes)(nil), "surfstore.BlockHashes")
+	proto.RegisterType((*DeleteBlocksRequest)(nil), "surfstore.DeleteBlocksRequest")
 	proto.RegisterType((*Block)(nil), "surfstore.Block")
 	proto.RegisterType((*Success)(nil), "surfstore.Success")
 	proto.RegisterType((*FileMetaData)(nil), "surfstore.FileMetaData")
 	proto.RegisterType((*FileInfoMap)(nil), "surfstore.FileInfoMap")
 	proto.RegisterMapType((map[string]*FileMetaData)(nil), "surfstore.FileInfoMap.FileInfoMapEntry")
+	proto.RegisterType((*Cursor)(nil), "surfstore.Cursor")
+	proto.RegisterType((*FileChanges)(nil), "surfstore.FileChanges")
 	proto.RegisterType((*Version)(nil), "surfstore.Version")
+	proto.RegisterType((*FileVersion)(nil), "surfstore.FileVersion")
+	proto.RegisterType((*FileHistory)(nil), "surfstore.FileHistory")
+	proto.RegisterType((*WatchRequest)(nil), "surfstore.WatchRequest")
+	proto.RegisterType((*FileChange)(nil), "surfstore.FileChange")
 	proto.RegisterType((*BlockStoreAddr)(nil), "surfstore.BlockStoreAddr")
+	proto.RegisterType((*BlockStoreMap)(nil), "surfstore.BlockStoreMap")
+	proto.RegisterMapType((map[string]*BlockHashes)(nil), "surfstore.BlockStoreMap.BlockStoreMapEntry")
 	proto.RegisterType((*CrashedState)(nil), "surfstore.CrashedState")
 	proto.RegisterType((*AppendEntryInput)(nil), "surfstore.AppendEntryInput")
 	proto.RegisterType((*AppendEntryOutput)(nil), "surfstore.AppendEntryOutput")
@@ -660,60 +1188,91 @@ func init() {
 func init() { proto.RegisterFile("pkg/surfstore/SurfStore.proto", fileDescriptor_74dffadc931fead4) }
 
 var fileDescriptor_74dffadc931fead4 = []byte{
-	// 874 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xdc, 0x56, 0xdd, 0x6e, 0xe3, 0x44,
-	0x14, 0xb6, 0x9b, 0x66, 0x13, 0x9f, 0xa4, 0x4b, 0x3a, 0x42, 0x25, 0x78, 0xbb, 0xa2, 0x1a, 0x16,
-	0xd1, 0x8b, 0x25, 0x41, 0xa1, 0x2b, 0x7e, 0x56, 0x20, 0x6d, 0xcb, 0xd2, 0x06, 0x75, 0xb5, 0xc8,
-	0xe1, 0x47, 0xe2, 0x6e, 0x12, 0x1f, 0x27, 0xde, 0x3a, 0xb6, 0x35, 0x33, 0x8e, 0x28, 0x77, 0xbc,
-	0x08, 0x37, 0x3c, 0x01, 0x17, 0xbc, 0x02, 0x8f, 0xc0, 0xf3, 0xa0, 0x19, 0xff, 0x64, 0x92, 0x8d,
-	0x57, 0xea, 0xed, 0xde, 0x9d, 0x73, 0xe6, 0xfc, 0x7d, 0xe7, 0xcf, 0x86, 0x87, 0xe9, 0xcd, 0x7c,
-	0x28, 0x32, 0x1e, 0x08, 0x99, 0x70, 0x1c, 0x4e, 0x32, 0x1e, 0x4c, 0x14, 0x35, 0x48, 0x79, 0x22,
-	0x13, 0xe2, 0x54, 0x4f, 0xee, 0x83, 0x79, 0x92, 0xcc, 0x23, 0x1c, 0xea, 0x87, 0x69, 0x16, 0x0c,
-	0x71, 0x99, 0xca, 0xdb, 0x5c, 0x8f, 0x7e, 0x00, 0xce, 0x79, 0x94, 0xcc, 0x6e, 0xae, 0x98, 0x58,
-	0x10, 0x02, 0xfb, 0x0b, 0x26, 0x16, 0x7d, 0xfb, 0xc4, 0x3e, 0x75, 0x3c, 0x4d, 0xd3, 0x8f, 0xa0,
-	0x53, 0x29, 0xa0, 0x20, 0x47, 0x70, 0x6f, 0xa1, 0xa9, 0xbe, 0x7d, 0xd2, 0x38, 0x75, 0xbc, 0x82,
-	0xa3, 0x17, 0xd0, 0xd4, 0x6a, 0xe4, 0x18, 0x9c, 0xa9, 0x22, 0xbe, 0x65, 0x92, 0x69, 0x47, 0x5d,
-	0x6f, 0x2d, 0xa8, 0x5e, 0x27, 0xe1, 0xef, 0xd8, 0xdf, 0x3b, 0xb1, 0x4f, 0x9b, 0xde, 0x5a, 0x40,
-	0x1f, 0x42, 0x6b, 0x92, 0xcd, 0x66, 0x28, 0x84, 0x4a, 0x25, 0x88, 0xd8, 0x5c, 0x7b, 0x68, 0x7b,
-	0x9a, 0xa6, 0xaf, 0xa0, 0xfb, 0x5d, 0x18, 0xe1, 0x0b, 0x94, 0x4c, 0x3b, 0x73, 0xa1, 0x1d, 0x84,
-	0x11, 0xc6, 0x6c, 0x89, 0x45, 0xca, 0x15, 0x4f, 0xfa, 0xd0, 0x5a, 0x21, 0x17, 0x61, 0x12, 0x17,
-	0x61, 0x4a, 0x96, 0x3c, 0x82, 0x83, 0x69, 0x09, 0xe8, 0x3a, 0x14, 0xb2, 0xdf, 0xd0, 0x40, 0x36,
-	0x85, 0xf4, 0x6f, 0x1b, 0x3a, 0x2a, 0xd8, 0x38, 0x0e, 0x92, 0x17, 0x2c, 0x25, 0x63, 0xe8, 0x04,
-	0x6b, 0x56, 0x83, 0xef, 0x8c, 0x3e, 0x1e, 0x54, 0x55, 0x1e, 0x18, 0xca, 0x26, 0xfd, 0x3c, 0x96,
-	0xfc, 0xd6, 0x33, 0x6d, 0xdd, 0x5f, 0xa0, 0xb7, 0xad, 0x40, 0x7a, 0xd0, 0xb8, 0xc1, 0xdb, 0x02,
-	0x85, 0x22, 0xc9, 0x27, 0xd0, 0x5c, 0xb1, 0x28, 0xcb, 0xab, 0xd4, 0x19, 0xbd, 0xb7, 0x15, 0xaa,
-	0x2c, 0x82, 0x97, 0x6b, 0x7d, 0xb5, 0xf7, 0x85, 0x4d, 0x3f, 0x84, 0xd6, 0xcf, 0x05, 0x48, 0x03,
-	0xbe, 0xbd, 0x01, 0x9f, 0x3e, 0x82, 0xfb, 0xba, 0x51, 0x7a, 0x58, 0x9e, 0xf9, 0x3e, 0x57, 0xa5,
-	0x66, 0xbe, 0xcf, 0xcb, 0xae, 0x2b, 0x9a, 0x3e, 0x86, 0xee, 0x05, 0x57, 0x9d, 0xf5, 0x27, 0x92,
-	0x49, 0x54, 0x7d, 0x0b, 0x45, 0x21, 0x29, 0x7a, 0xb2, 0x16, 0xd0, 0x7f, 0x6d, 0xe8, 0x3d, 0x4b,
-	0x53, 0x8c, 0x7d, 0x8d, 0x66, 0x1c, 0xa7, 0x99, 0x54, 0x6e, 0x25, 0xf2, 0xa5, 0xd6, 0x6e, 0x78,
-	0x9a, 0x26, 0x14, 0xba, 0x29, 0xc7, 0xd5, 0x75, 0x32, 0x1f, 0xc7, 0x3e, 0xfe, 0xa6, 0xb1, 0x35,
-	0xbc, 0x0d, 0x19, 0x39, 0x81, 0x4e, 0xc1, 0xff, 0xa8, 0xcc, 0x1b, 0x5a, 0xc5, 0x14, 0x91, 0x33,
-	0x68, 0x61, 0x2c, 0x79, 0x88, 0xa2, 0xbf, 0xaf, 0xfb, 0xe0, 0x1a, 0xc5, 0xf9, 0x29, 0xf5, 0x99,
-	0xc4, 0x97, 0x29, 0x72, 0x26, 0xc3, 0x24, 0xf6, 0x4a, 0x55, 0x15, 0x3b, 0x42, 0xe6, 0x23, 0xbf,
-	0x48, 0x96, 0xcb, 0x50, 0xf6, 0x9b, 0x79, 0x6c, 0x53, 0x46, 0xff, 0xb0, 0xe1, 0xd0, 0x00, 0xf2,
-	0x32, 0x93, 0x0a, 0x89, 0x0b, 0x6d, 0x81, 0x7c, 0x85, 0x7c, 0xec, 0x17, 0x68, 0x2a, 0xbe, 0x42,
-	0xb9, 0x67, 0xa0, 0xec, 0x43, 0x4b, 0xe4, 0x63, 0xac, 0xb3, 0x6f, 0x7b, 0x25, 0xab, 0x72, 0x58,
-	0x32, 0x39, 0x5b, 0xa0, 0x9f, 0xe3, 0xdf, 0xcf, 0x73, 0x30, 0x65, 0x74, 0x0a, 0xef, 0x6c, 0x61,
-	0xd8, 0x59, 0xca, 0xa7, 0xd0, 0x0d, 0x8c, 0x39, 0xd0, 0x91, 0xde, 0x30, 0x26, 0x1b, 0xca, 0xf4,
-	0x2f, 0x1b, 0x0e, 0x3d, 0x16, 0xc8, 0x71, 0x2c, 0x91, 0xc7, 0x2c, 0xca, 0x9b, 0xec, 0x42, 0x3b,
-	0x14, 0xd7, 0xba, 0x1e, 0x45, 0x8f, 0x2b, 0x7e, 0x27, 0xce, 0xc7, 0xd0, 0x88, 0x92, 0xb9, 0xde,
-	0x9f, 0x37, 0xf7, 0x40, 0xa9, 0x91, 0x4f, 0xa1, 0xb5, 0x44, 0xc9, 0xd4, 0xf6, 0xec, 0xeb, 0x5c,
-	0x8f, 0x76, 0x6f, 0x8f, 0x57, 0xaa, 0x8d, 0xfe, 0xb1, 0x01, 0xd6, 0xb3, 0x4a, 0xce, 0xa0, 0x7d,
-	0x89, 0x32, 0xbf, 0x32, 0xef, 0x1a, 0xb6, 0xd5, 0x79, 0x72, 0x7b, 0xdb, 0x52, 0x6a, 0x91, 0x11,
-	0xb4, 0x7f, 0xc8, 0x0a, 0xab, 0xd7, 0xde, 0x5d, 0x62, 0x48, 0x8a, 0xd3, 0x43, 0x2d, 0xf2, 0x35,
-	0x38, 0x57, 0x4c, 0x68, 0x0d, 0x41, 0x8e, 0x76, 0x85, 0x42, 0xe1, 0xd6, 0xc8, 0xa9, 0x35, 0xfa,
-	0xcf, 0x06, 0x47, 0x95, 0x3a, 0x4f, 0xfb, 0x1c, 0xee, 0x5f, 0xa2, 0x34, 0x6f, 0xc9, 0xd1, 0x20,
-	0xbf, 0xc8, 0x83, 0xf2, 0x22, 0x0f, 0x9e, 0xab, 0x8b, 0xec, 0xd6, 0x14, 0x84, 0x5a, 0xe4, 0x29,
-	0x40, 0x5e, 0x53, 0x25, 0x26, 0x75, 0x4d, 0xde, 0x40, 0x53, 0x5c, 0x02, 0x6a, 0x91, 0x2b, 0x38,
-	0x2c, 0xeb, 0xb6, 0x5e, 0xfa, 0xba, 0x1c, 0xde, 0xdf, 0x46, 0x55, 0x99, 0x50, 0x6b, 0xf4, 0x67,
-	0x13, 0x0e, 0xd4, 0xd8, 0x4c, 0x4a, 0x15, 0x72, 0x0d, 0x07, 0xeb, 0x7d, 0x51, 0x5b, 0xf6, 0xc0,
-	0xb0, 0xdf, 0x3e, 0x09, 0xee, 0xf1, 0xee, 0xc7, 0x7c, 0xcd, 0xa8, 0x45, 0xbe, 0x04, 0x67, 0x82,
-	0xb2, 0x98, 0xb8, 0xba, 0x0c, 0xeb, 0x5a, 0x76, 0x30, 0xc1, 0xd8, 0xbf, 0x42, 0xc6, 0xe5, 0x14,
-	0x99, 0xbc, 0xa3, 0xf9, 0xdb, 0xd3, 0x24, 0xf2, 0x3d, 0xf4, 0x2e, 0x71, 0x6b, 0xb3, 0xeb, 0x1c,
-	0x99, 0x0d, 0x79, 0xed, 0x1e, 0x50, 0x8b, 0x7c, 0x03, 0xce, 0xb8, 0xbc, 0xf2, 0xb5, 0x4e, 0x4c,
-	0xa4, 0xe6, 0x47, 0x83, 0x5a, 0xe4, 0x73, 0x68, 0x79, 0x98, 0x4f, 0xca, 0xdd, 0xfa, 0xf1, 0x04,
-	0x9a, 0xda, 0xd5, 0xdd, 0xcc, 0xce, 0x8f, 0x7f, 0x75, 0x67, 0x02, 0x47, 0xa3, 0x33, 0xf5, 0xb3,
-	0xf3, 0xea, 0xc9, 0x70, 0xe3, 0x1f, 0x69, 0x7a, 0x4f, 0xfb, 0xf8, 0xec, 0xff, 0x00, 0x00, 0x00,
-	0xff, 0xff, 0x98, 0xc4, 0x04, 0x04, 0x3b, 0x09, 0x00, 0x00,
+	// 1372 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xec, 0x58, 0xcd, 0x72, 0x1b, 0xc5,
+	0x13, 0xd7, 0x5a, 0x92, 0x25, 0xb5, 0xa4, 0x44, 0x9e, 0xe4, 0x9f, 0xff, 0xa2, 0x38, 0x94, 0x6b,
+	0x48, 0x15, 0x06, 0x82, 0x9d, 0x52, 0x9c, 0x0a, 0x90, 0x4a, 0xaa, 0x62, 0xc5, 0xb1, 0x05, 0x36,
+	0x09, 0x2b, 0x87, 0x10, 0x6e, 0x6b, 0x6d, 0x4b, 0x5a, 0x2c, 0xed, 0x8a, 0x9d, 0x91, 0x0b, 0x53,
+	0xc5, 0x81, 0x13, 0x6f, 0xc0, 0x0b, 0xc0, 0x2b, 0x70, 0xe3, 0xcc, 0xd3, 0xf0, 0x04, 0x9c, 0xa8,
+	0xf9, 0x58, 0xed, 0xac, 0xa4, 0x4d, 0x70, 0xce, 0xb9, 0x4d, 0xf7, 0x74, 0xf7, 0x76, 0xff, 0xfa,
+	0x6b, 0x6a, 0xe1, 0xc6, 0xe4, 0x74, 0xb0, 0xcd, 0xa6, 0x51, 0x9f, 0xf1, 0x30, 0xc2, 0xed, 0xee,
+	0x34, 0xea, 0x77, 0xc5, 0x69, 0x6b, 0x12, 0x85, 0x3c, 0x24, 0x95, 0xd9, 0x55, 0xf3, 0xfa, 0x20,
+	0x0c, 0x07, 0x23, 0xdc, 0x96, 0x17, 0x27, 0xd3, 0xfe, 0x36, 0x8e, 0x27, 0xfc, 0x5c, 0xc9, 0xd1,
+	0x7b, 0x50, 0xd9, 0x1d, 0x85, 0xbd, 0xd3, 0x03, 0x97, 0x0d, 0x09, 0x81, 0xc2, 0xd0, 0x65, 0x43,
+	0xdb, 0xda, 0xb0, 0x36, 0x2b, 0x8e, 0x3c, 0x93, 0x6b, 0xb0, 0xda, 0x0b, 0x3d, 0xec, 0x31, 0x7b,
+	0x65, 0x23, 0xbf, 0x59, 0x71, 0x34, 0x45, 0x1f, 0x40, 0x75, 0xa6, 0x88, 0x4c, 0x88, 0x0d, 0xe5,
+	0xc9, 0xb6, 0x94, 0xd8, 0x70, 0xc6, 0x5f, 0xaa, 0xde, 0x85, 0x2b, 0x8f, 0x71, 0x84, 0x1c, 0xa5,
+	0x11, 0xe6, 0xe0, 0xf7, 0x53, 0x64, 0x3c, 0xd3, 0xcc, 0x4d, 0xa8, 0x0f, 0x22, 0xb7, 0x87, 0xcf,
+	0x30, 0xf2, 0x43, 0xef, 0x48, 0x58, 0xb3, 0x36, 0xf3, 0x4e, 0x9a, 0x49, 0x5f, 0x42, 0x51, 0x9a,
+	0x23, 0xeb, 0x50, 0x39, 0x11, 0x87, 0xc7, 0x2e, 0x77, 0x65, 0x34, 0x35, 0x27, 0x61, 0xcc, 0x6e,
+	0xbb, 0xfe, 0x8f, 0x28, 0x0d, 0x15, 0x9d, 0x84, 0x41, 0xae, 0x42, 0x51, 0xfa, 0x68, 0xe7, 0x25,
+	0x0a, 0x8a, 0xa0, 0x37, 0xa0, 0xd4, 0x9d, 0xf6, 0x7a, 0xc8, 0x98, 0x40, 0xa9, 0x3f, 0x72, 0x07,
+	0xd2, 0x6e, 0xd9, 0x91, 0x67, 0xfa, 0xcb, 0x0a, 0xd4, 0x9e, 0xf8, 0x23, 0x3c, 0x42, 0xee, 0xca,
+	0x6f, 0x34, 0xa1, 0xdc, 0xf7, 0x47, 0x18, 0xb8, 0x63, 0xd4, 0x70, 0xce, 0x68, 0x62, 0x43, 0xe9,
+	0x0c, 0x23, 0xe6, 0x87, 0x81, 0xfe, 0x7a, 0x4c, 0x8a, 0x30, 0x4f, 0x62, 0x50, 0x0f, 0x7d, 0xc6,
+	0xed, 0xbc, 0x44, 0x21, 0xcd, 0x14, 0x0e, 0x8c, 0x43, 0x0f, 0xed, 0xc2, 0x86, 0xb5, 0x59, 0x77,
+	0xe4, 0x59, 0x78, 0x3d, 0xe6, 0xfe, 0x18, 0xed, 0xa2, 0x04, 0x46, 0x11, 0x64, 0x5b, 0x79, 0x71,
+	0x7c, 0x3e, 0x41, 0x7b, 0x75, 0xc3, 0xda, 0xbc, 0xd4, 0xba, 0xb2, 0x35, 0x2b, 0x8c, 0xad, 0x27,
+	0xfa, 0xca, 0x99, 0x09, 0x09, 0x07, 0xd8, 0xf9, 0x78, 0xe4, 0x07, 0xa7, 0xc7, 0x6e, 0x34, 0x40,
+	0x6e, 0x97, 0xa4, 0xef, 0x69, 0xa6, 0x08, 0xae, 0x37, 0x9c, 0x06, 0xa7, 0x7e, 0x30, 0xb0, 0xcb,
+	0x2a, 0xb8, 0x98, 0xa6, 0xff, 0x58, 0x50, 0x15, 0x86, 0x3b, 0x41, 0x3f, 0x3c, 0x72, 0x27, 0xa4,
+	0x03, 0xd5, 0x7e, 0x42, 0xca, 0xb4, 0x56, 0x5b, 0xef, 0xcf, 0x79, 0xa1, 0x6f, 0xcd, 0xf3, 0x5e,
+	0xc0, 0xa3, 0x73, 0xc7, 0xd4, 0x25, 0x1f, 0xc0, 0x6a, 0x6f, 0x1a, 0xb1, 0x30, 0x92, 0xb0, 0x55,
+	0x5b, 0x6b, 0x86, 0x95, 0xb6, 0xbc, 0x70, 0xb4, 0x80, 0x48, 0xb1, 0x80, 0x9a, 0x4d, 0xdc, 0x1e,
+	0xea, 0x44, 0x26, 0x8c, 0xe6, 0x0b, 0x68, 0xcc, 0x7f, 0x89, 0x34, 0x20, 0x7f, 0x8a, 0xe7, 0x3a,
+	0x57, 0xe2, 0x48, 0x3e, 0x86, 0xe2, 0x99, 0x3b, 0x9a, 0xa2, 0xfe, 0xda, 0xff, 0xe7, 0x7c, 0x8e,
+	0x53, 0xed, 0x28, 0xa9, 0xcf, 0x56, 0x3e, 0xb1, 0xe8, 0x0e, 0xac, 0x2a, 0x47, 0x44, 0x3e, 0x70,
+	0x12, 0xf6, 0x54, 0x2f, 0xe5, 0x1d, 0x45, 0x08, 0xae, 0x1f, 0x78, 0xf8, 0x83, 0x2e, 0x5f, 0x45,
+	0xd0, 0xa9, 0x42, 0xac, 0x3d, 0x74, 0x83, 0x01, 0x32, 0x72, 0x1f, 0x6a, 0x7d, 0xc3, 0xbe, 0x86,
+	0x2c, 0xf3, 0xf3, 0x29, 0xe1, 0x0b, 0x60, 0x44, 0xdf, 0x83, 0xd2, 0xd7, 0xba, 0xee, 0x8c, 0x8a,
+	0xb4, 0x52, 0x15, 0x49, 0xdb, 0xca, 0xb7, 0x58, 0xf0, 0x8d, 0xca, 0x9a, 0xee, 0x2a, 0x23, 0x07,
+	0xbe, 0xf0, 0xe3, 0x9c, 0xdc, 0x81, 0xb2, 0xbe, 0x61, 0xaf, 0x0b, 0x6e, 0x26, 0x48, 0x77, 0xa1,
+	0xf6, 0xc2, 0xe5, 0xbd, 0x61, 0x3c, 0x29, 0xd6, 0xa1, 0xd2, 0x8f, 0xc2, 0x71, 0x47, 0xc2, 0xa9,
+	0x40, 0x4e, 0x18, 0x09, 0xfc, 0x2b, 0x06, 0xfc, 0xf4, 0x27, 0x80, 0x04, 0xe8, 0x24, 0x19, 0x96,
+	0x91, 0x8c, 0x05, 0xf4, 0x5f, 0x93, 0xfc, 0x34, 0xfa, 0xaf, 0x2c, 0x3b, 0x7a, 0x13, 0x2e, 0xc9,
+	0xf1, 0x24, 0xe7, 0xf4, 0x23, 0xcf, 0x8b, 0x44, 0x27, 0xbb, 0x9e, 0x17, 0xc5, 0x03, 0x57, 0x9c,
+	0xe9, 0x9f, 0x16, 0xd4, 0x13, 0x31, 0x51, 0xf7, 0x5f, 0xe9, 0xa9, 0x10, 0x33, 0x34, 0x68, 0x1f,
+	0x19, 0x3e, 0xa5, 0x14, 0xd2, 0x94, 0x6a, 0xa4, 0xb4, 0x85, 0xe6, 0x37, 0x40, 0x16, 0x85, 0x96,
+	0xf4, 0xc0, 0xad, 0x74, 0x0f, 0x5c, 0x9b, 0xff, 0xa4, 0x9a, 0xfe, 0x66, 0x0b, 0xdc, 0x82, 0x5a,
+	0x3b, 0x12, 0x5c, 0xaf, 0xcb, 0x5d, 0x8e, 0x02, 0x12, 0x9f, 0x69, 0x8e, 0x1e, 0x99, 0x09, 0x83,
+	0xfe, 0x65, 0x41, 0xe3, 0xd1, 0x64, 0x82, 0x81, 0x27, 0x3d, 0xe8, 0x04, 0x93, 0xa9, 0x9c, 0x6f,
+	0x1c, 0xa3, 0xb1, 0xce, 0x8b, 0x3c, 0x13, 0x0a, 0xb5, 0x49, 0x84, 0x67, 0x87, 0xe1, 0xa0, 0x63,
+	0x34, 0x50, 0x8a, 0x47, 0x36, 0xa0, 0xaa, 0xe9, 0x63, 0xa1, 0x9e, 0x97, 0x22, 0x26, 0x8b, 0xec,
+	0x40, 0x09, 0x03, 0x1e, 0xf9, 0xc8, 0xec, 0x82, 0xc4, 0xb0, 0x69, 0x04, 0xf4, 0x7c, 0xe2, 0xb9,
+	0x1c, 0x9f, 0x4e, 0x30, 0x72, 0xb9, 0x1f, 0x06, 0x4e, 0x2c, 0x2a, 0xbe, 0x3d, 0x42, 0xd7, 0xc3,
+	0xa8, 0x1d, 0x8e, 0xc7, 0x3e, 0xd7, 0x23, 0x36, 0xc5, 0xa3, 0x3f, 0x5b, 0xb0, 0x66, 0x04, 0xf2,
+	0x74, 0xca, 0x45, 0x24, 0x4d, 0x28, 0x33, 0x8c, 0xce, 0x30, 0xea, 0x78, 0x3a, 0x9a, 0x19, 0x3d,
+	0x8b, 0x72, 0xc5, 0x88, 0xd2, 0x86, 0x12, 0x53, 0x5b, 0x46, 0x7a, 0x5f, 0x76, 0x62, 0x52, 0xf8,
+	0x30, 0x16, 0xe5, 0x8f, 0x9e, 0x8a, 0xbf, 0xa0, 0x7c, 0x30, 0x79, 0xf4, 0x57, 0x0b, 0x2e, 0xcf,
+	0x05, 0xb1, 0x14, 0xcb, 0xf9, 0x12, 0xcf, 0xbf, 0x71, 0x89, 0x17, 0xe6, 0x4a, 0x3c, 0xe9, 0xbb,
+	0xa2, 0xd9, 0x77, 0xbf, 0x59, 0xb0, 0xe6, 0xb8, 0x7d, 0xde, 0x09, 0x38, 0x46, 0x81, 0x3b, 0x52,
+	0x95, 0xd1, 0x84, 0xb2, 0xcf, 0x0e, 0x25, 0x88, 0xba, 0x30, 0x66, 0xf4, 0x52, 0x70, 0x6e, 0x41,
+	0x7e, 0x14, 0x0e, 0xec, 0xfc, 0x6b, 0x13, 0x27, 0xc4, 0xc8, 0x6d, 0x28, 0x8d, 0x91, 0xbb, 0xa2,
+	0x5d, 0x0a, 0x0b, 0xb5, 0x6b, 0x4c, 0x7f, 0x27, 0x16, 0xfb, 0xf0, 0x0e, 0x94, 0xe3, 0x8d, 0x48,
+	0xaa, 0x50, 0x72, 0xf6, 0xf6, 0x9f, 0x1f, 0x3e, 0x72, 0x1a, 0x39, 0x52, 0x87, 0xca, 0xe3, 0x8e,
+	0xb3, 0xd7, 0x3e, 0x7e, 0xea, 0xbc, 0x6c, 0x58, 0xe2, 0xae, 0xfb, 0xf2, 0xe8, 0xb0, 0xf3, 0xe5,
+	0x17, 0x8d, 0x95, 0xd6, 0x1f, 0x79, 0x80, 0xa4, 0x93, 0xc8, 0x0e, 0x94, 0xf7, 0x91, 0x4b, 0x06,
+	0xb9, 0xba, 0xac, 0x59, 0x9a, 0x8d, 0x79, 0x2e, 0xcd, 0x91, 0x16, 0x94, 0x9f, 0x4d, 0xb5, 0xd6,
+	0xc2, 0x7d, 0x93, 0x18, 0x1c, 0xfd, 0x06, 0xa1, 0x39, 0xf2, 0x00, 0x2a, 0x07, 0x2e, 0x93, 0x12,
+	0x8c, 0x64, 0xf4, 0x65, 0x33, 0x83, 0x4f, 0x73, 0xe4, 0x21, 0x80, 0x78, 0x4b, 0xcc, 0xf4, 0xd5,
+	0x1b, 0x71, 0x2b, 0x7e, 0x23, 0x6e, 0xed, 0x89, 0x37, 0xe2, 0x2b, 0xf4, 0x0f, 0xa0, 0x66, 0xbe,
+	0xdf, 0xc8, 0xbb, 0x86, 0xe4, 0x92, 0x87, 0xdd, 0x2b, 0x2c, 0x7d, 0x0a, 0x95, 0x38, 0x78, 0xb6,
+	0x24, 0xfa, 0x4c, 0xc5, 0x4d, 0x4b, 0xa8, 0xee, 0x63, 0x12, 0xc3, 0x72, 0x0c, 0x96, 0x00, 0x7e,
+	0xdb, 0x6a, 0xfd, 0x5e, 0x80, 0x8a, 0xa8, 0x69, 0x95, 0xb6, 0x5d, 0xb8, 0xb4, 0x8f, 0xdc, 0x7c,
+	0xb6, 0xfc, 0x17, 0x44, 0x0c, 0x79, 0x9a, 0x23, 0xf7, 0x01, 0x54, 0x21, 0x0a, 0x36, 0xc9, 0xea,
+	0xa6, 0x54, 0x36, 0xf5, 0x56, 0x95, 0x70, 0xae, 0xc5, 0x91, 0x24, 0xdb, 0x21, 0xcb, 0x87, 0x77,
+	0x96, 0x0e, 0x7e, 0xa1, 0x42, 0x73, 0xe4, 0x09, 0x34, 0x52, 0x96, 0x54, 0x30, 0xcb, 0xa1, 0xb1,
+	0xb3, 0x36, 0x08, 0xcd, 0x19, 0x90, 0xc4, 0x6b, 0x7b, 0x3e, 0x74, 0xed, 0xfd, 0x02, 0x24, 0x5a,
+	0x9e, 0xe6, 0x48, 0x7b, 0x66, 0x43, 0xcb, 0x66, 0xda, 0xc8, 0x82, 0x4b, 0xe2, 0x5a, 0x94, 0x8b,
+	0x3f, 0x05, 0xa9, 0xf9, 0x14, 0x68, 0xfe, 0x6f, 0x4e, 0x59, 0xed, 0x77, 0x91, 0x66, 0xf2, 0x10,
+	0x2e, 0xef, 0x23, 0x57, 0x0c, 0xd6, 0xf5, 0x83, 0x1e, 0x92, 0xc5, 0x17, 0xd1, 0x42, 0x04, 0x5a,
+	0x9e, 0xe6, 0x5a, 0x7f, 0x97, 0xa0, 0x2e, 0x26, 0x57, 0x37, 0xbe, 0x27, 0x87, 0x50, 0x4f, 0xe6,
+	0xbc, 0xd8, 0x0e, 0xd7, 0x0d, 0xe5, 0xf9, 0x55, 0xd6, 0x5c, 0x5f, 0x7e, 0xa9, 0xd6, 0x83, 0x2a,
+	0xfe, 0x2e, 0x72, 0x3d, 0xf4, 0xb2, 0xf2, 0x9d, 0x35, 0x00, 0xea, 0x5d, 0x0c, 0xbc, 0x03, 0x74,
+	0x23, 0x7e, 0x82, 0x2e, 0xbf, 0xa0, 0xfa, 0xdb, 0x92, 0x7f, 0x5b, 0xf2, 0xcb, 0x4a, 0x9e, 0x7c,
+	0x2e, 0xd1, 0x4c, 0xaf, 0xea, 0xac, 0xb4, 0x98, 0xe5, 0xbd, 0xb0, 0xe0, 0xe5, 0x96, 0xa9, 0x74,
+	0xe2, 0xb7, 0x5e, 0xa6, 0x11, 0x33, 0x48, 0xf3, 0xe9, 0x48, 0x73, 0xe4, 0x1e, 0x94, 0x1c, 0x94,
+	0x37, 0x17, 0xac, 0xee, 0xbb, 0x50, 0x94, 0xa6, 0x2e, 0xa6, 0xb6, 0xbb, 0xfe, 0x6d, 0xb3, 0xc7,
+	0xb0, 0xd5, 0xda, 0x11, 0x3f, 0x4b, 0xbe, 0xbb, 0xbb, 0x9d, 0xfa, 0xc7, 0x72, 0xb2, 0x2a, 0x6d,
+	0xdc, 0xf9, 0x77, 0x00, 0xcb, 0x33, 0x10, 0x41, 0x7b, 0x11, 0x00, 0x00,
 }
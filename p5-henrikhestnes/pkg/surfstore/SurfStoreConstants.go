@@ -1,10 +1,48 @@
 package surfstore
 
+import "fmt"
+
 const DEFAULT_META_FILENAME string = "index.txt"
 
+// Downloads are written to a file with this prefix in the base directory
+// before they replace the local file
+const DOWNLOAD_TMP_PREFIX string = ".surfstore-download-"
+
+// Files replaced atomically are written to a file with this prefix followed
+// by their name first
+const ATOMIC_TMP_PREFIX string = ".tmp-"
+
+const DEFAULT_SYNC_CONCURRENCY int = 4
+
+// Accepted updates the MetaStore keeps for watchers that fall behind
+const CHANGE_LOG_LENGTH int = 10000
+
+const TOMBSTONE_HASH string = "0"
+
+// Block hash lists of a directory and a symlink entry
+const DIRECTORY_HASH string = "dir"
+const SYMLINK_HASH string = "link"
+
 const FILENAME_INDEX int = 0
 const VERSION_INDEX int = 1
 const HASH_LIST_INDEX int = 2
+const MODE_INDEX int = 3
+const MTIME_INDEX int = 4
+const FILE_TYPE_INDEX int = 5
+const SYMLINK_TARGET_INDEX int = 6
 
 const CONFIG_DELIMITER string = ","
+
+// Starts the line of a legacy local metadata file that holds the cursor of the
+// server's changes, followed by its epoch and index
+const CURSOR_LINE_PREFIX string = ",cursor,"
 const HASH_DELIMITER string = " "
+
+var ERR_FILE_NOT_FOUND = fmt.Errorf("file not found")
+var ERR_VERSION_NOT_FOUND = fmt.Errorf("file version not found")
+var ERR_CHANGES_EXPIRED = fmt.Errorf("changes are no longer kept, fetch the whole file info map")
+var ERR_UPDATE_REJECTED = fmt.Errorf("file was changed by another client")
+var ERR_INVALID_PATH = fmt.Errorf("invalid file name")
+var ERR_BLOCK_NOT_FOUND = fmt.Errorf("block not found")
+var ERR_UNKNOWN_CODEC = fmt.Errorf("unknown codec")
+var ERR_DECRYPTION_FAILED = fmt.Errorf("decryption failed, wrong passphrase?")
@@ -26,6 +26,12 @@ type BlockStoreClient interface {
 	GetBlock(ctx context.Context, in *BlockHash, opts ...grpc.CallOption) (*Block, error)
 	PutBlock(ctx context.Context, in *Block, opts ...grpc.CallOption) (*Success, error)
 	HasBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockHashes, error)
+	ListBlocks(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*BlockHashes, error)
+	DeleteBlocks(ctx context.Context, in *DeleteBlocksRequest, opts ...grpc.CallOption) (*BlockHashes, error)
+	// Stores every block sent on the stream, returns the hashes stored
+	PutBlocks(ctx context.Context, opts ...grpc.CallOption) (BlockStore_PutBlocksClient, error)
+	// Streams the blocks in the order of the hashes
+	GetBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (BlockStore_GetBlocksClient, error)
 }
 
 type blockStoreClient struct {
@@ -63,6 +69,90 @@ func (c *blockStoreClient) HasBlocks(ctx context.Context, in *BlockHashes, opts
 	return out, nil
 }
 
+func (c *blockStoreClient) ListBlocks(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/ListBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) DeleteBlocks(ctx context.Context, in *DeleteBlocksRequest, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/DeleteBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) PutBlocks(ctx context.Context, opts ...grpc.CallOption) (BlockStore_PutBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BlockStore_ServiceDesc.Streams[0], "/surfstore.BlockStore/PutBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blockStorePutBlocksClient{stream}
+	return x, nil
+}
+
+type BlockStore_PutBlocksClient interface {
+	Send(*Block) error
+	CloseAndRecv() (*BlockHashes, error)
+	grpc.ClientStream
+}
+
+type blockStorePutBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *blockStorePutBlocksClient) Send(m *Block) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *blockStorePutBlocksClient) CloseAndRecv() (*BlockHashes, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(BlockHashes)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blockStoreClient) GetBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (BlockStore_GetBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BlockStore_ServiceDesc.Streams[1], "/surfstore.BlockStore/GetBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blockStoreGetBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BlockStore_GetBlocksClient interface {
+	Recv() (*Block, error)
+	grpc.ClientStream
+}
+
+type blockStoreGetBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *blockStoreGetBlocksClient) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // BlockStoreServer is the server API for BlockStore service.
 // All implementations must embed UnimplementedBlockStoreServer
 // for forward compatibility
@@ -70,6 +160,12 @@ type BlockStoreServer interface {
 	GetBlock(context.Context, *BlockHash) (*Block, error)
 	PutBlock(context.Context, *Block) (*Success, error)
 	HasBlocks(context.Context, *BlockHashes) (*BlockHashes, error)
+	ListBlocks(context.Context, *empty.Empty) (*BlockHashes, error)
+	DeleteBlocks(context.Context, *DeleteBlocksRequest) (*BlockHashes, error)
+	// Stores every block sent on the stream, returns the hashes stored
+	PutBlocks(BlockStore_PutBlocksServer) error
+	// Streams the blocks in the order of the hashes
+	GetBlocks(*BlockHashes, BlockStore_GetBlocksServer) error
 	mustEmbedUnimplementedBlockStoreServer()
 }
 
@@ -86,6 +182,18 @@ func (UnimplementedBlockStoreServer) PutBlock(context.Context, *Block) (*Success
 func (UnimplementedBlockStoreServer) HasBlocks(context.Context, *BlockHashes) (*BlockHashes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HasBlocks not implemented")
 }
+func (UnimplementedBlockStoreServer) ListBlocks(context.Context, *empty.Empty) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) DeleteBlocks(context.Context, *DeleteBlocksRequest) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) PutBlocks(BlockStore_PutBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) GetBlocks(*BlockHashes, BlockStore_GetBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlocks not implemented")
+}
 func (UnimplementedBlockStoreServer) mustEmbedUnimplementedBlockStoreServer() {}
 
 // UnsafeBlockStoreServer may be embedded to opt out of forward compatibility for this service.
@@ -153,6 +261,89 @@ func _BlockStore_HasBlocks_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BlockStore_ListBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).ListBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/ListBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).ListBlocks(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_DeleteBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBlocksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).DeleteBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/DeleteBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).DeleteBlocks(ctx, req.(*DeleteBlocksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_PutBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BlockStoreServer).PutBlocks(&blockStorePutBlocksServer{stream})
+}
+
+type BlockStore_PutBlocksServer interface {
+	SendAndClose(*BlockHashes) error
+	Recv() (*Block, error)
+	grpc.ServerStream
+}
+
+type blockStorePutBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *blockStorePutBlocksServer) SendAndClose(m *BlockHashes) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *blockStorePutBlocksServer) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _BlockStore_GetBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockHashes)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlockStoreServer).GetBlocks(m, &blockStoreGetBlocksServer{stream})
+}
+
+type BlockStore_GetBlocksServer interface {
+	Send(*Block) error
+	grpc.ServerStream
+}
+
+type blockStoreGetBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *blockStoreGetBlocksServer) Send(m *Block) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // BlockStore_ServiceDesc is the grpc.ServiceDesc for BlockStore service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -172,8 +363,27 @@ var BlockStore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "HasBlocks",
 			Handler:    _BlockStore_HasBlocks_Handler,
 		},
+		{
+			MethodName: "ListBlocks",
+			Handler:    _BlockStore_ListBlocks_Handler,
+		},
+		{
+			MethodName: "DeleteBlocks",
+			Handler:    _BlockStore_DeleteBlocks_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutBlocks",
+			Handler:       _BlockStore_PutBlocks_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetBlocks",
+			Handler:       _BlockStore_GetBlocks_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/surfstore/SurfStore.proto",
 }
 
@@ -184,6 +394,15 @@ type MetaStoreClient interface {
 	GetFileInfoMap(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*FileInfoMap, error)
 	UpdateFile(ctx context.Context, in *FileMetaData, opts ...grpc.CallOption) (*Version, error)
 	GetBlockStoreAddr(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*BlockStoreAddr, error)
+	GetBlockStoreMap(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockStoreMap, error)
+	// Versions of a file kept by the MetaStore, oldest first
+	GetFileHistory(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileHistory, error)
+	GetFileVersion(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileMetaData, error)
+	// Streams every accepted update from an index on, waiting for new ones
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetaStore_WatchClient, error)
+	// The files changed after a cursor returned by GetFileInfoMap or an
+	// earlier GetChangesSince
+	GetChangesSince(ctx context.Context, in *Cursor, opts ...grpc.CallOption) (*FileChanges, error)
 }
 
 type metaStoreClient struct {
@@ -221,6 +440,74 @@ func (c *metaStoreClient) GetBlockStoreAddr(ctx context.Context, in *empty.Empty
 	return out, nil
 }
 
+func (c *metaStoreClient) GetBlockStoreMap(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockStoreMap, error) {
+	out := new(BlockStoreMap)
+	err := c.cc.Invoke(ctx, "/surfstore.MetaStore/GetBlockStoreMap", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metaStoreClient) GetFileHistory(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileHistory, error) {
+	out := new(FileHistory)
+	err := c.cc.Invoke(ctx, "/surfstore.MetaStore/GetFileHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metaStoreClient) GetFileVersion(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileMetaData, error) {
+	out := new(FileMetaData)
+	err := c.cc.Invoke(ctx, "/surfstore.MetaStore/GetFileVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metaStoreClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetaStore_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetaStore_ServiceDesc.Streams[0], "/surfstore.MetaStore/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metaStoreWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetaStore_WatchClient interface {
+	Recv() (*FileChange, error)
+	grpc.ClientStream
+}
+
+type metaStoreWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *metaStoreWatchClient) Recv() (*FileChange, error) {
+	m := new(FileChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metaStoreClient) GetChangesSince(ctx context.Context, in *Cursor, opts ...grpc.CallOption) (*FileChanges, error) {
+	out := new(FileChanges)
+	err := c.cc.Invoke(ctx, "/surfstore.MetaStore/GetChangesSince", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetaStoreServer is the server API for MetaStore service.
 // All implementations must embed UnimplementedMetaStoreServer
 // for forward compatibility
@@ -228,6 +515,15 @@ type MetaStoreServer interface {
 	GetFileInfoMap(context.Context, *empty.Empty) (*FileInfoMap, error)
 	UpdateFile(context.Context, *FileMetaData) (*Version, error)
 	GetBlockStoreAddr(context.Context, *empty.Empty) (*BlockStoreAddr, error)
+	GetBlockStoreMap(context.Context, *BlockHashes) (*BlockStoreMap, error)
+	// Versions of a file kept by the MetaStore, oldest first
+	GetFileHistory(context.Context, *FileVersion) (*FileHistory, error)
+	GetFileVersion(context.Context, *FileVersion) (*FileMetaData, error)
+	// Streams every accepted update from an index on, waiting for new ones
+	Watch(*WatchRequest, MetaStore_WatchServer) error
+	// The files changed after a cursor returned by GetFileInfoMap or an
+	// earlier GetChangesSince
+	GetChangesSince(context.Context, *Cursor) (*FileChanges, error)
 	mustEmbedUnimplementedMetaStoreServer()
 }
 
@@ -244,6 +540,21 @@ func (UnimplementedMetaStoreServer) UpdateFile(context.Context, *FileMetaData) (
 func (UnimplementedMetaStoreServer) GetBlockStoreAddr(context.Context, *empty.Empty) (*BlockStoreAddr, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlockStoreAddr not implemented")
 }
+func (UnimplementedMetaStoreServer) GetBlockStoreMap(context.Context, *BlockHashes) (*BlockStoreMap, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockStoreMap not implemented")
+}
+func (UnimplementedMetaStoreServer) GetFileHistory(context.Context, *FileVersion) (*FileHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileHistory not implemented")
+}
+func (UnimplementedMetaStoreServer) GetFileVersion(context.Context, *FileVersion) (*FileMetaData, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileVersion not implemented")
+}
+func (UnimplementedMetaStoreServer) Watch(*WatchRequest, MetaStore_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedMetaStoreServer) GetChangesSince(context.Context, *Cursor) (*FileChanges, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChangesSince not implemented")
+}
 func (UnimplementedMetaStoreServer) mustEmbedUnimplementedMetaStoreServer() {}
 
 // UnsafeMetaStoreServer may be embedded to opt out of forward compatibility for this service.
@@ -311,6 +622,99 @@ func _MetaStore_GetBlockStoreAddr_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetaStore_GetBlockStoreMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockHashes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetaStoreServer).GetBlockStoreMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.MetaStore/GetBlockStoreMap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetaStoreServer).GetBlockStoreMap(ctx, req.(*BlockHashes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetaStore_GetFileHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileVersion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetaStoreServer).GetFileHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.MetaStore/GetFileHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetaStoreServer).GetFileHistory(ctx, req.(*FileVersion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetaStore_GetFileVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileVersion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetaStoreServer).GetFileVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.MetaStore/GetFileVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetaStoreServer).GetFileVersion(ctx, req.(*FileVersion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetaStore_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetaStoreServer).Watch(m, &metaStoreWatchServer{stream})
+}
+
+type MetaStore_WatchServer interface {
+	Send(*FileChange) error
+	grpc.ServerStream
+}
+
+type metaStoreWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *metaStoreWatchServer) Send(m *FileChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetaStore_GetChangesSince_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Cursor)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetaStoreServer).GetChangesSince(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.MetaStore/GetChangesSince",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetaStoreServer).GetChangesSince(ctx, req.(*Cursor))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetaStore_ServiceDesc is the grpc.ServiceDesc for MetaStore service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -330,8 +734,30 @@ var MetaStore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlockStoreAddr",
 			Handler:    _MetaStore_GetBlockStoreAddr_Handler,
 		},
+		{
+			MethodName: "GetBlockStoreMap",
+			Handler:    _MetaStore_GetBlockStoreMap_Handler,
+		},
+		{
+			MethodName: "GetFileHistory",
+			Handler:    _MetaStore_GetFileHistory_Handler,
+		},
+		{
+			MethodName: "GetFileVersion",
+			Handler:    _MetaStore_GetFileVersion_Handler,
+		},
+		{
+			MethodName: "GetChangesSince",
+			Handler:    _MetaStore_GetChangesSince_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _MetaStore_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pkg/surfstore/SurfStore.proto",
 }
 
@@ -347,6 +773,11 @@ type RaftSurfstoreClient interface {
 	GetFileInfoMap(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*FileInfoMap, error)
 	UpdateFile(ctx context.Context, in *FileMetaData, opts ...grpc.CallOption) (*Version, error)
 	GetBlockStoreAddr(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*BlockStoreAddr, error)
+	GetBlockStoreMap(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockStoreMap, error)
+	GetFileHistory(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileHistory, error)
+	GetFileVersion(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileMetaData, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (RaftSurfstore_WatchClient, error)
+	GetChangesSince(ctx context.Context, in *Cursor, opts ...grpc.CallOption) (*FileChanges, error)
 	// testing interface
 	GetInternalState(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*RaftInternalState, error)
 	IsCrashed(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*CrashedState, error)
@@ -416,6 +847,74 @@ func (c *raftSurfstoreClient) GetBlockStoreAddr(ctx context.Context, in *empty.E
 	return out, nil
 }
 
+func (c *raftSurfstoreClient) GetBlockStoreMap(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockStoreMap, error) {
+	out := new(BlockStoreMap)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetBlockStoreMap", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) GetFileHistory(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileHistory, error) {
+	out := new(FileHistory)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetFileHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) GetFileVersion(ctx context.Context, in *FileVersion, opts ...grpc.CallOption) (*FileMetaData, error) {
+	out := new(FileMetaData)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetFileVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (RaftSurfstore_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RaftSurfstore_ServiceDesc.Streams[0], "/surfstore.RaftSurfstore/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &raftSurfstoreWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RaftSurfstore_WatchClient interface {
+	Recv() (*FileChange, error)
+	grpc.ClientStream
+}
+
+type raftSurfstoreWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *raftSurfstoreWatchClient) Recv() (*FileChange, error) {
+	m := new(FileChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *raftSurfstoreClient) GetChangesSince(ctx context.Context, in *Cursor, opts ...grpc.CallOption) (*FileChanges, error) {
+	out := new(FileChanges)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetChangesSince", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *raftSurfstoreClient) GetInternalState(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*RaftInternalState, error) {
 	out := new(RaftInternalState)
 	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetInternalState", in, out, opts...)
@@ -464,6 +963,11 @@ type RaftSurfstoreServer interface {
 	GetFileInfoMap(context.Context, *empty.Empty) (*FileInfoMap, error)
 	UpdateFile(context.Context, *FileMetaData) (*Version, error)
 	GetBlockStoreAddr(context.Context, *empty.Empty) (*BlockStoreAddr, error)
+	GetBlockStoreMap(context.Context, *BlockHashes) (*BlockStoreMap, error)
+	GetFileHistory(context.Context, *FileVersion) (*FileHistory, error)
+	GetFileVersion(context.Context, *FileVersion) (*FileMetaData, error)
+	Watch(*WatchRequest, RaftSurfstore_WatchServer) error
+	GetChangesSince(context.Context, *Cursor) (*FileChanges, error)
 	// testing interface
 	GetInternalState(context.Context, *empty.Empty) (*RaftInternalState, error)
 	IsCrashed(context.Context, *empty.Empty) (*CrashedState, error)
@@ -494,6 +998,21 @@ func (UnimplementedRaftSurfstoreServer) UpdateFile(context.Context, *FileMetaDat
 func (UnimplementedRaftSurfstoreServer) GetBlockStoreAddr(context.Context, *empty.Empty) (*BlockStoreAddr, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlockStoreAddr not implemented")
 }
+func (UnimplementedRaftSurfstoreServer) GetBlockStoreMap(context.Context, *BlockHashes) (*BlockStoreMap, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockStoreMap not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) GetFileHistory(context.Context, *FileVersion) (*FileHistory, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileHistory not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) GetFileVersion(context.Context, *FileVersion) (*FileMetaData, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFileVersion not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) Watch(*WatchRequest, RaftSurfstore_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) GetChangesSince(context.Context, *Cursor) (*FileChanges, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChangesSince not implemented")
+}
 func (UnimplementedRaftSurfstoreServer) GetInternalState(context.Context, *empty.Empty) (*RaftInternalState, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInternalState not implemented")
 }
@@ -627,6 +1146,99 @@ func _RaftSurfstore_GetBlockStoreAddr_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RaftSurfstore_GetBlockStoreMap_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockHashes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).GetBlockStoreMap(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/GetBlockStoreMap",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).GetBlockStoreMap(ctx, req.(*BlockHashes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_GetFileHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileVersion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).GetFileHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/GetFileHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).GetFileHistory(ctx, req.(*FileVersion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_GetFileVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileVersion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).GetFileVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/GetFileVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).GetFileVersion(ctx, req.(*FileVersion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RaftSurfstoreServer).Watch(m, &raftSurfstoreWatchServer{stream})
+}
+
+type RaftSurfstore_WatchServer interface {
+	Send(*FileChange) error
+	grpc.ServerStream
+}
+
+type raftSurfstoreWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *raftSurfstoreWatchServer) Send(m *FileChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _RaftSurfstore_GetChangesSince_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Cursor)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).GetChangesSince(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/GetChangesSince",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).GetChangesSince(ctx, req.(*Cursor))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _RaftSurfstore_GetInternalState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(empty.Empty)
 	if err := dec(in); err != nil {
@@ -730,6 +1342,22 @@ var RaftSurfstore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlockStoreAddr",
 			Handler:    _RaftSurfstore_GetBlockStoreAddr_Handler,
 		},
+		{
+			MethodName: "GetBlockStoreMap",
+			Handler:    _RaftSurfstore_GetBlockStoreMap_Handler,
+		},
+		{
+			MethodName: "GetFileHistory",
+			Handler:    _RaftSurfstore_GetFileHistory_Handler,
+		},
+		{
+			MethodName: "GetFileVersion",
+			Handler:    _RaftSurfstore_GetFileVersion_Handler,
+		},
+		{
+			MethodName: "GetChangesSince",
+			Handler:    _RaftSurfstore_GetChangesSince_Handler,
+		},
 		{
 			MethodName: "GetInternalState",
 			Handler:    _RaftSurfstore_GetInternalState_Handler,
@@ -747,6 +1375,12 @@ var RaftSurfstore_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _RaftSurfstore_Crash_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _RaftSurfstore_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "pkg/surfstore/SurfStore.proto",
 }
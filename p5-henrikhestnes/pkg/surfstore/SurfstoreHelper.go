@@ -1,14 +1,9 @@
 package surfstore
 
 import (
-	"bufio"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"io"
-	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -37,94 +32,66 @@ func ConcatPath(baseDir, fileDir string) string {
 // NewFileMetaDataFromConfig returns a FileMetaData struct
 // associated with one line in the local metadata file.
 func NewFileMetaDataFromConfig(configString string) *FileMetaData {
-	configItems := strings.Split(configString, CONFIG_DELIMITER)
+	// The symlink target is last and may itself contain the delimiter
+	configItems := strings.SplitN(configString, CONFIG_DELIMITER, SYMLINK_TARGET_INDEX+1)
 
 	filename := configItems[FILENAME_INDEX]
 	version, _ := strconv.Atoi(configItems[VERSION_INDEX])
 	blockHashList := strings.Split(configItems[HASH_LIST_INDEX], HASH_DELIMITER)
 
-	return &FileMetaData{
+	fileMetaData := &FileMetaData{
 		Filename:      filename,
 		Version:       int32(version),
 		BlockHashList: blockHashList[:len(blockHashList)-1],
 	}
+
+	// Indexes written before file attributes were synced end here
+	if len(configItems) > SYMLINK_TARGET_INDEX {
+		mode, _ := strconv.ParseUint(configItems[MODE_INDEX], 8, 32)
+		mtime, _ := strconv.ParseInt(configItems[MTIME_INDEX], 10, 64)
+		fileType, _ := strconv.Atoi(configItems[FILE_TYPE_INDEX])
+		fileMetaData.Mode = uint32(mode)
+		fileMetaData.Mtime = mtime
+		fileMetaData.FileType = FileType(fileType)
+		fileMetaData.SymlinkTarget = configItems[SYMLINK_TARGET_INDEX]
+	}
+	return fileMetaData
 }
 
 // LoadMetaFromMetaFiles loads the local metadata file into a file meta map.
 // The key is the file's name and the value is the file's metadata.
 // You can use this function to load the index.txt file in this project.
 func LoadMetaFromMetaFile(baseDir string) (fileMetaMap map[string]*FileMetaData, e error) {
-	metaFilePath, _ := filepath.Abs(ConcatPath(baseDir, DEFAULT_META_FILENAME))
-
-	fileMetaMap = make(map[string]*FileMetaData)
-
-	metaFileStats, e := os.Stat(metaFilePath)
-	if e != nil || metaFileStats.IsDir() {
-		return fileMetaMap, nil
-	}
-	metaFD, e := os.Open(metaFilePath)
+	index, e := LoadLocalIndex(baseDir)
 	if e != nil {
-		log.Fatal("Error When Opening Meta")
-	}
-	defer metaFD.Close()
-
-	leftOverContent := ""
-	metaReader := bufio.NewReader(metaFD)
-	for {
-		lineContent, isPrefix, e := metaReader.ReadLine()
-		if e != nil && e != io.EOF {
-			log.Fatal("Error During Reading Meta")
-		}
-
-		leftOverContent += string(lineContent)
-		if isPrefix {
-			continue
-		}
-
-		if len(leftOverContent) == 0 {
-			break
-		}
-
-		currFileMeta := NewFileMetaDataFromConfig(leftOverContent)
-
-		leftOverContent = ""
-		fileMetaMap[currFileMeta.Filename] = currFileMeta
+		return nil, e
 	}
-
-	return fileMetaMap, nil
+	return index.Files, nil
 }
 
-// FileMetaDataToString converts a FileMetaData struct
-// to a string for writing back to local metadata file
-func FileMetaDataToString(fm *FileMetaData) (result string) {
-	result += fm.Filename + ","
-	result += strconv.Itoa(int(fm.Version)) + ","
-
-	for _, blockHash := range fm.BlockHashList {
-		result += blockHash + " "
+// newCursorFromConfig parses the cursor line of a legacy local metadata
+// file, which has an empty file name so that it cannot clash with a file
+func newCursorFromConfig(configString string) *Cursor {
+	configItems := strings.Split(strings.TrimPrefix(configString, CURSOR_LINE_PREFIX), CONFIG_DELIMITER)
+	if len(configItems) != 2 {
+		return nil
 	}
-
-	result += "\n"
-	return
-}
-
-// WriteMetaFile writes the file meta map back to local metadata file
-func WriteMetaFile(fileMetas map[string]*FileMetaData, baseDir string) error {
-	outputMetaPath := ConcatPath(baseDir, DEFAULT_META_FILENAME)
-
-	outFD, err := os.Create(outputMetaPath)
+	epoch, err := strconv.ParseInt(configItems[0], 10, 64)
 	if err != nil {
-		log.Fatal("Error During Meta Write Back")
+		return nil
 	}
-
-	for _, fileMeta := range fileMetas {
-		_, err := outFD.WriteString(FileMetaDataToString(fileMeta))
-		if err != nil {
-			log.Fatal("Error During Meta Write Back")
-		}
+	index, err := strconv.ParseInt(configItems[1], 10, 64)
+	if err != nil {
+		return nil
 	}
+	return &Cursor{Epoch: epoch, Index: index}
+}
 
-	return nil
+// WriteMetaFile writes the file meta map back to local metadata file
+func WriteMetaFile(fileMetas map[string]*FileMetaData, baseDir string) error {
+	index := NewLocalIndex()
+	index.Files = fileMetas
+	return index.Write(baseDir)
 }
 
 /*
@@ -15,6 +15,24 @@ type MetaStoreInterface interface {
 
 	// Get the the BlockStore address
 	GetBlockStoreAddr(ctx context.Context, _ *emptypb.Empty) (*BlockStoreAddr, error)
+
+	// Get the BlockStore responsible for each of the given block hashes
+	GetBlockStoreMap(ctx context.Context, blockHashesIn *BlockHashes) (*BlockStoreMap, error)
+
+	// Get the kept versions of a file, oldest first, ending with the current one
+	GetFileHistory(ctx context.Context, fileVersion *FileVersion) (*FileHistory, error)
+
+	// Get one version of a file, ERR_VERSION_NOT_FOUND if it is no longer kept
+	GetFileVersion(ctx context.Context, fileVersion *FileVersion) (*FileMetaData, error)
+
+	// Get the latest version of every file changed after a cursor,
+	// ERR_CHANGES_EXPIRED if they are no longer known
+	GetChangesSince(ctx context.Context, cursor *Cursor) (*FileChanges, error)
+
+	// Stream every accepted update from an index on, waiting for new ones.
+	// Not part of MetaStoreInterface, whose methods RaftSurfstore shares,
+	// because the stream type differs between the two services:
+	// Watch(request *WatchRequest, stream MetaStore_WatchServer) error
 }
 
 type BlockStoreInterface interface {
@@ -28,16 +46,58 @@ type BlockStoreInterface interface {
 	// Given a list of hashes “in”, returns a list containing the
 	// subset of in that are stored in the key-value store
 	HasBlocks(ctx context.Context, blockHashesIn *BlockHashes) (*BlockHashes, error)
+
+	// Get the hashes of all stored blocks
+	ListBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error)
+
+	// Delete blocks that were not written within the grace period
+	DeleteBlocks(ctx context.Context, request *DeleteBlocksRequest) (*BlockHashes, error)
+
+	// Put every block sent on the stream
+	PutBlocks(stream BlockStore_PutBlocksServer) error
+
+	// Stream the blocks of the given hashes
+	GetBlocks(blockHashesIn *BlockHashes, stream BlockStore_GetBlocksServer) error
 }
 
 type ClientInterface interface {
 	// MetaStore
-	GetFileInfoMap(serverFileInfoMap *map[string]*FileMetaData) error
+	GetFileInfoMap(serverFileInfoMap *map[string]*FileMetaData, cursor *Cursor, namespace *string) error
+	GetChangesSince(cursor *Cursor, changes *map[string]*FileMetaData) error
 	UpdateFile(fileMetaData *FileMetaData, latestVersion *int32) error
 	GetBlockStoreAddr(blockStoreAddr *string) error
+	GetBlockStoreMap(blockHashesIn []string, blockStoreMap *map[string][]string) error
+	GetFileHistory(fileName string, versions *[]*FileMetaData) error
+	GetFileVersion(fileName string, version int32, fileMetaData *FileMetaData) error
+	Watch(from *Cursor, stop <-chan struct{}, changes chan<- *FileChange) error
 
 	// BlockStore
 	GetBlock(blockHash string, blockStoreAddr string, block *Block) error
 	PutBlock(block *Block, blockStoreAddr string, succ *bool) error
-	HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string) error
+	HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string, codecs *[]string) error
+	PutBlocks(blockStoreAddr string, blocks <-chan *Block, blockHashesOut *[]string) error
+	GetBlocks(blockHashesIn []string, blockStoreAddr string, blocks chan<- *Block) error
+}
+
+// BlockBackend is the storage behind a BlockStore server. Blocks are keyed by
+// the hex SHA-256 hash of their uncompressed data. A backend stores the data
+// and the codec of a block, its uncompressed size is not kept.
+type BlockBackend interface {
+	// Get a block, ERR_BLOCK_NOT_FOUND if it is not stored
+	Get(hash string) (*Block, error)
+
+	// Store a block under its hash
+	Put(hash string, block *Block) error
+
+	// Check whether a block is stored
+	Has(hash string) (bool, error)
+
+	// Remove a block, a missing block is not an error
+	Delete(hash string) error
+
+	// Call fn with the hash of every stored block, stopping at the first error
+	Iterate(fn func(hash string) error) error
+
+	// Number of blocks and bytes stored
+	Stats() (BackendStats, error)
 }
@@ -3,10 +3,13 @@ package surfstore
 import (
 	context "context"
 	"errors"
+	"io"
+	"log"
 	"strings"
 	"time"
 
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
@@ -15,25 +18,62 @@ type RPCClient struct {
 	MetaStoreAddrs []string
 	BaseDir        string
 	BlockSize      int
+	Timeout        time.Duration
+	// Number of files transferred at the same time
+	Concurrency int
+	// CHUNKING_FIXED or CHUNKING_CDC, and the block size bounds in cdc mode
+	Chunking     string
+	MinChunkSize int
+	MaxChunkSize int
+	// Codec blocks are uploaded with if the BlockStore accepts it, CODEC_NONE
+	// to upload them uncompressed
+	Compression string
+	// Encrypts blocks and file names before they leave the client, nil to
+	// sync in plaintext
+	Encryption *BlockCipher
+	// Hash every file, instead of trusting the size, mtime and inode of the
+	// files recorded in the index
+	Verify bool
+	// Sent with every call to authenticate the client, empty if the servers
+	// do not check callers
+	Token string
+	// Namespace the client syncs in, empty for the one the servers pick
+	Namespace string
+	// Certificates to connect with, nil to connect in plaintext
+	TLS *TLSConfig
+}
+
+// dialOptions returns the options to dial the servers with
+func (surfClient *RPCClient) dialOptions() []grpc.DialOption {
+	var creds credentials.TransportCredentials = insecure.NewCredentials()
+	if surfClient.TLS != nil {
+		creds = surfClient.TLS.ClientCredentials()
+	}
+	return authDialOptions(creds, surfClient.Token, surfClient.Namespace)
 }
 
 func (surfClient *RPCClient) GetBlock(blockHash string, blockStoreAddr string, block *Block) error {
 	// connect to the server
-	conn, err := grpc.Dial(blockStoreAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(blockStoreAddr, surfClient.dialOptions()...)
 	if err != nil {
 		return err
 	}
 	c := NewBlockStoreClient(conn)
 
 	// perform the call
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 	defer cancel()
-	b, err := c.GetBlock(ctx, &BlockHash{Hash: blockHash})
+	b, err := c.GetBlock(ctx, &BlockHash{Hash: blockHash, Codecs: SUPPORTED_CODECS})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	data, err := decompressBlock(b, int(b.BlockSize))
 	if err != nil {
 		conn.Close()
 		return err
 	}
-	block.BlockData = b.BlockData
+	block.BlockData = data
 	block.BlockSize = b.BlockSize
 
 	// close the connection
@@ -41,13 +81,13 @@ func (surfClient *RPCClient) GetBlock(blockHash string, blockStoreAddr string, b
 }
 
 func (surfClient *RPCClient) PutBlock(block *Block, blockStoreAddr string, succ *bool) error {
-	conn, err := grpc.Dial(blockStoreAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(blockStoreAddr, surfClient.dialOptions()...)
 	if err != nil {
 		return err
 	}
 	c := NewBlockStoreClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 	defer cancel()
 	s, err := c.PutBlock(ctx, block)
 	if err != nil {
@@ -58,14 +98,16 @@ func (surfClient *RPCClient) PutBlock(block *Block, blockStoreAddr string, succ
 	return conn.Close()
 }
 
-func (surfClient *RPCClient) HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string) error {
-	conn, err := grpc.Dial(blockStoreAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// HasBlocks returns which of the blocks the BlockStore stores and the codecs
+// it accepts for uploads
+func (surfClient *RPCClient) HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string, codecs *[]string) error {
+	conn, err := grpc.Dial(blockStoreAddr, surfClient.dialOptions()...)
 	if err != nil {
 		return err
 	}
 	c := NewBlockStoreClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 	defer cancel()
 	b, err := c.HasBlocks(ctx, &BlockHashes{Hashes: blockHashesIn})
 	if err != nil {
@@ -73,19 +115,107 @@ func (surfClient *RPCClient) HasBlocks(blockHashesIn []string, blockStoreAddr st
 		return err
 	}
 	*blockHashesOut = b.Hashes
+	*codecs = b.Codecs
 
 	return conn.Close()
 }
 
-func (surfClient *RPCClient) GetFileInfoMap(serverFileInfoMap *map[string]*FileMetaData) error {
+// PutBlocks sends every block received on blocks to the BlockStore over one
+// stream and returns the hashes it stored. It always consumes blocks until the
+// channel is closed, also after an error. The stream is cancelled if no block
+// can be sent for longer than the client's Timeout.
+func (surfClient *RPCClient) PutBlocks(blockStoreAddr string, blocks <-chan *Block, blockHashesOut *[]string) error {
+	defer func() {
+		for range blocks {
+		}
+	}()
+
+	conn, err := grpc.Dial(blockStoreAddr, surfClient.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(surfClient.Timeout, cancel)
+	defer idle.Stop()
+
+	stream, err := c.PutBlocks(ctx)
+	if err != nil {
+		return err
+	}
+	for block := range blocks {
+		idle.Reset(surfClient.Timeout)
+		if err := stream.Send(block); err != nil {
+			// The server ended the stream, its status is returned by CloseAndRecv
+			break
+		}
+	}
+	idle.Reset(surfClient.Timeout)
+	b, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	*blockHashesOut = b.Hashes
+	return nil
+}
+
+// GetBlocks streams the blocks of the given hashes from the BlockStore into
+// blocks, in order and decompressed, and closes the channel when done. The stream is cancelled
+// if the BlockStore sends nothing for longer than the client's Timeout.
+func (surfClient *RPCClient) GetBlocks(blockHashesIn []string, blockStoreAddr string, blocks chan<- *Block) error {
+	defer close(blocks)
+
+	conn, err := grpc.Dial(blockStoreAddr, surfClient.dialOptions()...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(surfClient.Timeout, cancel)
+	defer idle.Stop()
+
+	stream, err := c.GetBlocks(ctx, &BlockHashes{Hashes: blockHashesIn, Codecs: SUPPORTED_CODECS})
+	if err != nil {
+		return err
+	}
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := decompressBlock(block, int(block.BlockSize))
+		if err != nil {
+			return err
+		}
+
+		// Waiting for the receiver is not the BlockStore's fault
+		idle.Stop()
+		blocks <- &Block{BlockData: data, BlockSize: block.BlockSize}
+		idle.Reset(surfClient.Timeout)
+	}
+}
+
+// GetFileInfoMap fetches the server's index, and the cursor to pass to
+// GetChangesSince for the changes after it if cursor is not nil. The
+// namespace of the index is returned in namespace if it is not nil.
+func (surfClient *RPCClient) GetFileInfoMap(serverFileInfoMap *map[string]*FileMetaData, cursor *Cursor, namespace *string) error {
 	for _, metaStore := range surfClient.MetaStoreAddrs {
-		conn, err := grpc.Dial(metaStore, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
 		if err != nil {
 			return err
 		}
 		c := NewRaftSurfstoreClient(conn)
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 		defer cancel()
 		f, err := c.GetFileInfoMap(ctx, &emptypb.Empty{})
 
@@ -99,7 +229,50 @@ func (surfClient *RPCClient) GetFileInfoMap(serverFileInfoMap *map[string]*FileM
 			conn.Close()
 			return err
 		}
-		*serverFileInfoMap = f.FileInfoMap
+		*serverFileInfoMap = surfClient.decryptFileInfoMap(f.FileInfoMap)
+		if cursor != nil && f.Cursor != nil {
+			*cursor = Cursor{Epoch: f.Cursor.Epoch, Index: f.Cursor.Index}
+		}
+		if namespace != nil {
+			*namespace = f.Namespace
+		}
+		return conn.Close()
+	}
+	return errors.New("cluster down")
+}
+
+// GetChangesSince fetches the latest version of every file changed after
+// cursor, and moves cursor past them
+func (surfClient *RPCClient) GetChangesSince(cursor *Cursor, changes *map[string]*FileMetaData) error {
+	for _, metaStore := range surfClient.MetaStoreAddrs {
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
+		defer cancel()
+		f, err := c.GetChangesSince(ctx, &Cursor{Epoch: cursor.Epoch, Index: cursor.Index})
+		if err != nil {
+			if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
+				continue
+			}
+			if strings.Contains(err.Error(), ERR_NOT_LEADER.Error()) {
+				continue
+			}
+			conn.Close()
+			if strings.Contains(err.Error(), ERR_CHANGES_EXPIRED.Error()) {
+				return ERR_CHANGES_EXPIRED
+			}
+			return err
+		}
+		fileInfoMap := make(map[string]*FileMetaData, len(f.FileMetaData))
+		for _, fileMetaData := range f.FileMetaData {
+			fileInfoMap[fileMetaData.Filename] = fileMetaData
+		}
+		*changes = surfClient.decryptFileInfoMap(fileInfoMap)
+		*cursor = Cursor{Epoch: f.Cursor.Epoch, Index: f.Cursor.Index}
 		return conn.Close()
 	}
 	return errors.New("cluster down")
@@ -107,15 +280,15 @@ func (surfClient *RPCClient) GetFileInfoMap(serverFileInfoMap *map[string]*FileM
 
 func (surfClient *RPCClient) UpdateFile(fileMetaData *FileMetaData, latestVersion *int32) error {
 	for _, metaStore := range surfClient.MetaStoreAddrs {
-		conn, err := grpc.Dial(metaStore, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
 		if err != nil {
 			return err
 		}
 		c := NewRaftSurfstoreClient(conn)
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 		defer cancel()
-		v, err := c.UpdateFile(ctx, fileMetaData)
+		v, err := c.UpdateFile(ctx, surfClient.encryptFileMetaData(fileMetaData))
 
 		if err != nil {
 			if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
@@ -133,16 +306,166 @@ func (surfClient *RPCClient) UpdateFile(fileMetaData *FileMetaData, latestVersio
 	return errors.New("cluster down")
 }
 
+// Watch sends the changes the MetaStore accepts after the cursor from to
+// changes until stop is closed, and closes changes when it returns. Without a
+// cursor, only the changes made after the call are sent. Changes to files
+// whose name does not decrypt are left out. It fails when the leader's
+// stream breaks, after which the caller should fetch the whole file info map.
+func (surfClient *RPCClient) Watch(from *Cursor, stop <-chan struct{}, changes chan<- *FileChange) error {
+	defer close(changes)
+
+	var fromIndex, epoch int64
+	if from != nil {
+		fromIndex, epoch = from.Index+1, from.Epoch
+	}
+
+	for _, metaStore := range surfClient.MetaStoreAddrs {
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		stream, err := c.Watch(ctx, &WatchRequest{FromIndex: fromIndex, Epoch: epoch})
+		if err == nil {
+			err = surfClient.receiveChanges(stream, &fromIndex, changes)
+		}
+		cancel()
+		conn.Close()
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
+			continue
+		}
+		if strings.Contains(err.Error(), ERR_NOT_LEADER.Error()) {
+			continue
+		}
+		return err
+	}
+	return errors.New("cluster down")
+}
+
+// receiveChanges passes on the changes of a Watch stream until it ends, and
+// moves fromIndex past them so that another server can take over the stream
+func (surfClient *RPCClient) receiveChanges(stream RaftSurfstore_WatchClient, fromIndex *int64, changes chan<- *FileChange) error {
+	for {
+		change, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("watch ended")
+		}
+		if err != nil {
+			return err
+		}
+		*fromIndex = change.Index + 1
+		fileName := change.FileMetaData.Filename
+		if surfClient.Encryption != nil && surfClient.Encryption.EncryptNames {
+			if fileName, err = surfClient.Encryption.DecryptName(fileName); err != nil {
+				continue
+			}
+		}
+		fileMetaData, err := surfClient.decryptFileMetaData(fileName, change.FileMetaData)
+		if err != nil {
+			continue
+		}
+		changes <- &FileChange{Index: change.Index, FileMetaData: fileMetaData}
+	}
+}
+
+// decryptFileInfoMap decrypts the file names of a server index. Files whose
+// name does not decrypt belong to another passphrase and are left out.
+func (surfClient *RPCClient) decryptFileInfoMap(fileInfoMap map[string]*FileMetaData) map[string]*FileMetaData {
+	if surfClient.Encryption == nil || !surfClient.Encryption.EncryptNames {
+		return fileInfoMap
+	}
+	decrypted := make(map[string]*FileMetaData)
+	for name, metaData := range fileInfoMap {
+		fileName, err := surfClient.Encryption.DecryptName(name)
+		if err != nil {
+			log.Println("Skipping file with a name that does not decrypt: ", name)
+			continue
+		}
+		if metaData, err = surfClient.decryptFileMetaData(fileName, metaData); err != nil {
+			log.Println("Skipping symlink with a target that does not decrypt: ", fileName)
+			continue
+		}
+		decrypted[fileName] = metaData
+	}
+	return decrypted
+}
+
+// decryptFileMetaData returns a copy of a server entry of fileName with its
+// symlink target decrypted
+func (surfClient *RPCClient) decryptFileMetaData(fileName string, fileMetaData *FileMetaData) (*FileMetaData, error) {
+	target := fileMetaData.SymlinkTarget
+	if target != "" && surfClient.Encryption != nil {
+		var err error
+		if target, err = surfClient.Encryption.DecryptName(target); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMetaData{
+		Filename:      fileName,
+		Version:       fileMetaData.Version,
+		BlockHashList: fileMetaData.BlockHashList,
+		Mode:          fileMetaData.Mode,
+		Mtime:         fileMetaData.Mtime,
+		FileType:      fileMetaData.FileType,
+		SymlinkTarget: target,
+		Chunking:      fileMetaData.Chunking,
+	}, nil
+}
+
+// encryptFileMetaData encrypts the file name and symlink target of an entry,
+// which link targets reveal as much as names do
+func (surfClient *RPCClient) encryptFileMetaData(fileMetaData *FileMetaData) *FileMetaData {
+	if surfClient.Encryption == nil || !surfClient.Encryption.EncryptNames {
+		return fileMetaData
+	}
+	target := fileMetaData.SymlinkTarget
+	if target != "" {
+		target = surfClient.Encryption.EncryptName(target)
+	}
+	return &FileMetaData{
+		Filename:      surfClient.encryptFileName(fileMetaData.Filename),
+		Version:       fileMetaData.Version,
+		BlockHashList: fileMetaData.BlockHashList,
+		Mode:          fileMetaData.Mode,
+		Mtime:         fileMetaData.Mtime,
+		FileType:      fileMetaData.FileType,
+		SymlinkTarget: target,
+		Chunking:      fileMetaData.Chunking,
+	}
+}
+
+func (surfClient *RPCClient) encryptFileName(fileName string) string {
+	if surfClient.Encryption == nil {
+		return fileName
+	}
+	return surfClient.Encryption.EncryptName(fileName)
+}
+
 func (surfClient *RPCClient) GetBlockStoreAddr(blockStoreAddr *string) error {
 	for _, metaStore := range surfClient.MetaStoreAddrs {
-		conn, err := grpc.Dial(metaStore, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
 		if err != nil {
 			return err
 		}
 		c := NewRaftSurfstoreClient(conn)
 
 		// perform the call
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
 		defer cancel()
 		addr, err := c.GetBlockStoreAddr(ctx, &emptypb.Empty{})
 		if err != nil {
@@ -162,6 +485,101 @@ func (surfClient *RPCClient) GetBlockStoreAddr(blockStoreAddr *string) error {
 
 }
 
+func (surfClient *RPCClient) GetBlockStoreMap(blockHashesIn []string, blockStoreMap *map[string][]string) error {
+	for _, metaStore := range surfClient.MetaStoreAddrs {
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
+		defer cancel()
+		m, err := c.GetBlockStoreMap(ctx, &BlockHashes{Hashes: blockHashesIn})
+		if err != nil {
+			if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
+				continue
+			}
+			if strings.Contains(err.Error(), ERR_NOT_LEADER.Error()) {
+				continue
+			}
+			conn.Close()
+			return err
+		}
+		*blockStoreMap = make(map[string][]string)
+		for addr, hashes := range m.BlockStoreMap {
+			(*blockStoreMap)[addr] = hashes.Hashes
+		}
+		return conn.Close()
+	}
+	return errors.New("cluster down")
+}
+
+func (surfClient *RPCClient) GetFileHistory(fileName string, versions *[]*FileMetaData) error {
+	for _, metaStore := range surfClient.MetaStoreAddrs {
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
+		defer cancel()
+		h, err := c.GetFileHistory(ctx, &FileVersion{Filename: surfClient.encryptFileName(fileName)})
+		if err != nil {
+			if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
+				continue
+			}
+			if strings.Contains(err.Error(), ERR_NOT_LEADER.Error()) {
+				continue
+			}
+			conn.Close()
+			return err
+		}
+		*versions = make([]*FileMetaData, len(h.Versions))
+		for i, fileMetaData := range h.Versions {
+			if (*versions)[i], err = surfClient.decryptFileMetaData(fileName, fileMetaData); err != nil {
+				conn.Close()
+				return err
+			}
+		}
+		return conn.Close()
+	}
+	return errors.New("cluster down")
+}
+
+func (surfClient *RPCClient) GetFileVersion(fileName string, version int32, fileMetaData *FileMetaData) error {
+	for _, metaStore := range surfClient.MetaStoreAddrs {
+		conn, err := grpc.Dial(metaStore, surfClient.dialOptions()...)
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), surfClient.Timeout)
+		defer cancel()
+		f, err := c.GetFileVersion(ctx, &FileVersion{Filename: surfClient.encryptFileName(fileName), Version: version})
+		if err != nil {
+			if strings.Contains(err.Error(), ERR_SERVER_CRASHED.Error()) {
+				continue
+			}
+			if strings.Contains(err.Error(), ERR_NOT_LEADER.Error()) {
+				continue
+			}
+			conn.Close()
+			return err
+		}
+		decrypted, err := surfClient.decryptFileMetaData(fileName, f)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		*fileMetaData = *decrypted
+		return conn.Close()
+	}
+	return errors.New("cluster down")
+}
+
 // This line guarantees all method for RPCClient are implemented
 var _ ClientInterface = new(RPCClient)
 
@@ -171,5 +589,8 @@ func NewSurfstoreRPCClient(addrs []string, baseDir string, blockSize int) RPCCli
 		MetaStoreAddrs: addrs,
 		BaseDir:        baseDir,
 		BlockSize:      blockSize,
+		Timeout:        DEFAULT_RPC_TIMEOUT,
+		Concurrency:    DEFAULT_SYNC_CONCURRENCY,
+		Chunking:       CHUNKING_FIXED,
 	}
 }
@@ -2,193 +2,1177 @@ package surfstore
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
 	"os"
-	"reflect"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// SyncStats counts the blocks a sync uploaded and the ones it skipped because
+// every replica already stored them.
+type SyncStats struct {
+	BlocksUploaded int
+	BytesUploaded  int64
+	// Size of the uploaded blocks after compression
+	BytesSent     int64
+	BlocksSkipped int
+	BytesSaved    int64
+
+	// Files changed here and on the server, whose local changes were saved
+	// as a conflicted copy
+	Conflicts []string
+	// Files whose update the MetaStore rejected because another client
+	// changed them first, retried on the next sync
+	Rejected []string
+}
+
+func (s *SyncStats) add(other SyncStats) {
+	s.BlocksUploaded += other.BlocksUploaded
+	s.BytesUploaded += other.BytesUploaded
+	s.BytesSent += other.BytesSent
+	s.BlocksSkipped += other.BlocksSkipped
+	s.BytesSaved += other.BytesSaved
+	s.Conflicts = append(s.Conflicts, other.Conflicts...)
+	s.Rejected = append(s.Rejected, other.Rejected...)
+}
+
 // Implement the logic for a client syncing with the server here.
-func ClientSync(client RPCClient) {
+//
+// Fails without changing the base directory or the index if the ignore rules,
+// the index or the server's index cannot be loaded.
+func ClientSync(client RPCClient) (SyncStats, error) {
 	indexPath := client.BaseDir + "/index.txt"
 	if _, err := os.Stat(indexPath); errors.Is(err, os.ErrNotExist) {
 		indexFile, _ := os.Create(indexPath)
 		indexFile.Close() //TODO: defer??
 	}
 
-	files, err := ioutil.ReadDir(client.BaseDir)
+	filter, err := LoadSyncFilter(client.BaseDir)
 	if err != nil {
-		log.Println("Error when reading basedir: ", err)
+		log.Println("Could not load ignore rules: ", err)
+		return SyncStats{}, err
 	}
+	syncStart := time.Now()
+	entries, unreadable := scanBaseDir(client.BaseDir, filter)
 
-	localIndex, err := LoadMetaFromMetaFile(client.BaseDir)
+	index, err := LoadLocalIndex(client.BaseDir)
 	if err != nil {
 		log.Println("Could not load meta from meta file: ", err)
-		log.Panic()
+		return SyncStats{}, err
+	}
+	if client.Namespace == "" {
+		// Keep syncing in the namespace the server picked for the index
+		client.Namespace = index.Namespace
+	} else if index.Namespace != client.Namespace {
+		// The index describes the files of another namespace
+		index = NewLocalIndex()
+	}
+	localIndex, cursor := index.Files, index.Cursor
+	if index.Filter != filter.Fingerprint {
+		// Changes to files that were left out were skipped
+		cursor = nil
+	}
+	if client.Encryption != nil {
+		if err := client.Encryption.loadNonces(client.BaseDir); err != nil {
+			log.Println("Could not load block nonces: ", err)
+		}
 	}
 	
+	// Index entries as of the last sync, restored when a file cannot be synced
+	syncedIndex := make(map[string]FileMetaData)
+	for fileName, metaData := range localIndex {
+		syncedIndex[fileName] = *metaData
+	}
+	restoreSynced := func(fileName string) {
+		if synced, ok := syncedIndex[fileName]; ok {
+			*localIndex[fileName] = synced
+		} else {
+			delete(localIndex, fileName)
+		}
+	}
+
+	// Files left out of the sync keep their index entries as they are
+	skipped := make(map[string]*FileMetaData)
+	for fileName, metaData := range localIndex {
+		if filter.Skipped(fileName, isDirectory(metaData.BlockHashList)) {
+			skipped[fileName] = metaData
+			delete(localIndex, fileName)
+		}
+	}
+
 	//Sync local index
-	hashMap := make(map[string][]string)
-	for _, file := range files {
-		if file.Name() == "index.txt" {
-			continue
+	scanned := make(map[string]*FileMetaData)
+	// Regular files as they were scanned, to tell which of them are still
+	// described by their index entry once the sync is done
+	scannedFiles := make(map[string]FileMetaData)
+	for fileName, info := range entries {
+		var err error
+		metaData := cachedFileMetaData(client, index, fileName, info)
+		if metaData == nil {
+			metaData, err = localFileMetaData(client, fileName, info)
 		}
-		var numBlocks int = int(math.Ceil(float64(file.Size()) / float64(client.BlockSize)))
-		fileToRead, err := os.Open(client.BaseDir + "/" + file.Name())
 		if err != nil {
 			log.Println("Error reading file in basedir: ", err)
+			unreadable = append(unreadable, fileName)
+			continue
 		}
-		
-		for i := 0; i < numBlocks; i++ {
-			byteSlice := make([]byte, client.BlockSize)
-			len, err := fileToRead.Read(byteSlice)
-			if err != nil{
-				log.Println("Error reading bytes from file in basedir: ", err)
-			}
-			byteSlice = byteSlice[:len]
-			hash := GetBlockHashString(byteSlice)
-			hashMap[file.Name()] = append(hashMap[file.Name()], hash)
+		scanned[fileName] = metaData
+		if info.Mode().IsRegular() {
+			scannedFiles[fileName] = *metaData
 		}
+	}
 
-		if val, ok := localIndex[file.Name()]; ok{
-			if !reflect.DeepEqual(hashMap[file.Name()], val.BlockHashList){ //TODO: Works??
-				localIndex[file.Name()].BlockHashList = hashMap[file.Name()]
-				localIndex[file.Name()].Version++
+	modified := make(map[string]bool)
+	for fileName, current := range scanned {
+		if val, ok := localIndex[fileName]; ok{
+			if changedLocally(client, val, current) {
+				current.Version = val.Version + 1
+				localIndex[fileName] = current
+				modified[fileName] = true
+			} else {
+				// Fill in attributes missing from older indexes
+				if val.Mode == 0 {
+					val.Mode = current.Mode
+				}
+				val.FileType = current.FileType
 			}
 		} else{
 				// New file
-				meta := FileMetaData{Filename: file.Name(), Version: 1, BlockHashList: hashMap[file.Name()]}
-				localIndex[file.Name()] = &meta
+				current.Version = 1
+				localIndex[fileName] = current
+				modified[fileName] = true
 		}
 	}
 
 	//Check for deleted files
 	for fileName, metaData := range localIndex {
-		if _, ok := hashMap[fileName]; !ok {
-			if len(metaData.BlockHashList) != 1 || metaData.BlockHashList[0] != "0" {
-				metaData.Version++
-				metaData.BlockHashList = []string{"0"}
+		if _, ok := scanned[fileName]; !ok && !isBelow(fileName, unreadable) {
+			if !isTombstone(metaData.BlockHashList) {
+				localIndex[fileName] = &FileMetaData{Filename: fileName, Version: metaData.Version + 1, BlockHashList: []string{TOMBSTONE_HASH}}
+				modified[fileName] = true
 			}
 		}
 	}
 
-	var blockStoreAddr string
-	if err := client.GetBlockStoreAddr(&blockStoreAddr); err != nil {
-		log.Println("Could not get blockStoreAddr: ", err)
-		log.Panic(err)
-	}
-	
-	remoteIndex := make(map[string]*FileMetaData)
-	if err := client.GetFileInfoMap(&remoteIndex); err != nil {
+	remoteIndex, cursor, namespace, err := getRemoteIndex(client, syncedIndex, cursor)
+	if err != nil {
 		log.Println("Error getting index from server: ", err)
-		log.Panic(err)
+		return SyncStats{}, err
 	}
-	
+	// Cleared when a file is left out of sync, so that the next sync fetches
+	// the whole index again
+	complete := true
+	for fileName, remoteMetaData := range remoteIndex {
+		if err := ValidateFileName(fileName); err != nil || remoteMetaData.Filename != fileName {
+			log.Println("Ignoring file with an invalid name on the server: ", fileName)
+			delete(remoteIndex, fileName)
+		} else if filter.Skipped(fileName, isDirectory(remoteMetaData.BlockHashList)) {
+			delete(remoteIndex, fileName)
+		}
+	}
+
+	//Keep both copies of files changed here and on the server since the last sync
+	var stats SyncStats
+	conflicts := make([]string, 0)
+	for fileName := range modified {
+		localMetaData := localIndex[fileName]
+		remoteMetaData, ok := remoteIndex[fileName]
+		if !ok || localMetaData.Version > remoteMetaData.Version {
+			continue
+		}
+		if sameLocalContent(client, localMetaData, remoteMetaData) {
+			// Both sides made the same change
+			*localMetaData = *remoteMetaData
+			continue
+		}
+		// Edits win over deletes, so nothing is lost without a conflict
+		if isTombstone(localMetaData.BlockHashList) {
+			continue
+		}
+		if isTombstone(remoteMetaData.BlockHashList) {
+			localMetaData.Version = remoteMetaData.Version + 1
+			continue
+		}
+		conflicts = append(conflicts, fileName)
+	}
+	sort.Strings(conflicts)
+	for _, fileName := range conflicts {
+		if _, ok := localIndex[fileName]; !ok {
+			// Moved along with a conflicting directory
+			continue
+		}
+		copyName, err := saveConflictCopy(client, fileName, localIndex, remoteIndex)
+		if err != nil {
+			log.Println("Could not save conflicted copy of ", fileName, ": ", err)
+			// Neither upload nor download the file until the next sync
+			complete = false
+			restoreSynced(fileName)
+			delete(remoteIndex, fileName)
+			continue
+		}
+		log.Println("Conflict on ", fileName, ", local changes saved as ", copyName)
+		stats.Conflicts = append(stats.Conflicts, fileName)
+	}
+
+	// Block streams of all files share the pool, so that at most
+	// client.Concurrency of them are open at the same time
+	pool := newTransferPool(client.Concurrency)
+
 	//Check if server has locas files, upload changes
+	var mtx sync.Mutex
+	failed := make(map[string]bool)
+	uploads := make([]func(), 0)
 	for fileName, localMetaData := range localIndex {
-		if remoteMetaData, ok := remoteIndex[fileName]; ok {
-			if localMetaData.Version > remoteMetaData.Version {
-				uploadFile(client, localMetaData, blockStoreAddr)
-			}
-		} else{
-			uploadFile(client, localMetaData, blockStoreAddr)
+		remoteMetaData, ok := remoteIndex[fileName]
+		if ok && localMetaData.Version <= remoteMetaData.Version {
+			continue
 		}
+		fileName, localMetaData := fileName, localMetaData
+		uploads = append(uploads, func() {
+			var fileStats SyncStats
+			err := uploadFile(client, pool, localMetaData, &fileStats)
+			mtx.Lock()
+			defer mtx.Unlock()
+			stats.add(fileStats)
+			if err == nil {
+				return
+			}
+			// Keep the local changes for the next sync, which turns a
+			// rejected update into a conflict
+			failed[fileName] = true
+			complete = false
+			if err == ERR_UPDATE_REJECTED {
+				stats.Rejected = append(stats.Rejected, fileName)
+			}
+			restoreSynced(fileName)
+		})
 	}
+	runWorkers(client.Concurrency, uploads)
 
 	//Check for updates on server, download
+	pending := make([]string, 0)
 	for filename, remoteMetaData := range remoteIndex {
+		if failed[filename] {
+			continue
+		}
 		if localMetaData, ok := localIndex[filename]; ok {
-			if localMetaData.Version < remoteMetaData.Version {
-				downloadFile(client, localMetaData, remoteMetaData, blockStoreAddr)
-			} else if localMetaData.Version == remoteMetaData.Version && !reflect.DeepEqual(localMetaData.BlockHashList, remoteMetaData.BlockHashList) {
-				downloadFile(client, localMetaData, remoteMetaData, blockStoreAddr)
+			if localMetaData.Version < remoteMetaData.Version || (localMetaData.Version == remoteMetaData.Version && !sameContent(localMetaData, remoteMetaData)) {
+				pending = append(pending, filename)
 			}
 		} else{
-			localIndex[filename] = &FileMetaData{}
-			localMetaData := localIndex[filename]
-			downloadFile(client, localMetaData, remoteMetaData, blockStoreAddr)
+			pending = append(pending, filename)
+		}
+	}
+	downloaded := make(map[string]bool)
+	states := make(map[string]LocalFileState)
+	download := func(filename string) {
+		localMetaData := &FileMetaData{}
+		state, err := downloadFile(client, pool, localMetaData, remoteIndex[filename])
+		mtx.Lock()
+		defer mtx.Unlock()
+		downloaded[filename] = true
+		if err != nil {
+			complete = false
+			return
+		}
+		localIndex[filename] = localMetaData
+		if state != nil {
+			states[filename] = *state
 		}
 	}
 
-	WriteMetaFile(localIndex, client.BaseDir)
+	// Deleted entries go first, contents before their directory. New
+	// directories follow, parents first, and then the files inside them.
+	// Symlinks are created one by one after everything else, so that no
+	// file is written through a link the server just created.
+	sort.Strings(pending)
+	for i := len(pending) - 1; i >= 0; i-- {
+		if isTombstone(remoteIndex[pending[i]].BlockHashList) {
+			download(pending[i])
+		}
+	}
+	downloads := make([]func(), 0)
+	for _, filename := range pending {
+		filename := filename
+		switch hashes := remoteIndex[filename].BlockHashList; {
+		case isTombstone(hashes):
+		case isDirectory(hashes):
+			download(filename)
+		case isSymlink(hashes):
+		default:
+			downloads = append(downloads, func() {
+				download(filename)
+			})
+		}
+	}
+	runWorkers(client.Concurrency, downloads)
+	for _, filename := range pending {
+		if isSymlink(remoteIndex[filename].BlockHashList) {
+			download(filename)
+		}
+	}
+
+	// Directory modes are applied last, so that read-only directories
+	// could still be filled
+	for _, filename := range pending {
+		if metaData := localIndex[filename]; isDirectory(metaData.GetBlockHashList()) && metaData.Mode != 0 {
+			if err := os.Chmod(localPath(client, filename), os.FileMode(metaData.Mode)); err != nil {
+				log.Println("Could not set directory mode: ", err)
+			}
+		}
+	}
+
+	for fileName, metaData := range skipped {
+		localIndex[fileName] = metaData
+	}
+
+	// Files that were not downloaded keep the state they were scanned in, as
+	// long as their index entry still describes them
+	for fileName, metaData := range scannedFiles {
+		if indexed, ok := localIndex[fileName]; ok && !downloaded[fileName] && !changedLocally(client, indexed, &metaData) {
+			states[fileName] = newLocalFileState(entries[fileName])
+		}
+	}
+	// A file written around the time it was scanned or downloaded may change
+	// again without a new mtime, so it is hashed by the next sync
+	for fileName, state := range states {
+		if state.Mtime >= syncStart.Add(-RACY_MTIME_WINDOW).UnixNano() {
+			delete(states, fileName)
+		}
+	}
+
+	if !complete {
+		cursor = nil
+	}
+	index.States, index.Cursor, index.Filter, index.Namespace = states, cursor, filter.Fingerprint, namespace
+	if err := index.Write(client.BaseDir); err != nil {
+		log.Println("Could not write meta file: ", err)
+	}
+	if client.Encryption != nil {
+		if err := client.Encryption.saveNonces(client.BaseDir); err != nil {
+			log.Println("Could not save block nonces: ", err)
+		}
+	}
+
+	log.Printf("Uploaded %d blocks (%d bytes, %d sent), skipped %d blocks already stored (%d bytes saved)\n",
+		stats.BlocksUploaded, stats.BytesUploaded, stats.BytesSent, stats.BlocksSkipped, stats.BytesSaved)
+	if len(stats.Conflicts) > 0 || len(stats.Rejected) > 0 {
+		log.Printf("%d conflicts, %d rejected updates\n", len(stats.Conflicts), len(stats.Rejected))
+	}
+	return stats, nil
 }
 
-func uploadFile(client RPCClient, metaData *FileMetaData, blockStoreAddr string) error {
-	path := client.BaseDir + "/" + metaData.Filename
-	var latestVersion int32
-	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
-		err = client.UpdateFile(metaData, &latestVersion)
+// ClientRestore makes a previous version of a file its current version and
+// syncs it to the base directory. The blocks of that version must still be on
+// the BlockStores, which the MetaStore guarantees for the versions it keeps.
+func ClientRestore(client RPCClient, fileName string, version int32) error {
+	// Local changes become a version of their own before they are replaced
+	if _, err := ClientSync(client); err != nil {
+		return err
+	}
+
+	var restored FileMetaData
+	if err := client.GetFileVersion(fileName, version, &restored); err != nil {
+		return err
+	}
+
+	if !isTombstone(restored.BlockHashList) && !isDirectory(restored.BlockHashList) {
+		owners, err := getBlockOwners(client, restored.BlockHashList)
 		if err != nil {
-			log.Println("Could not upload file: ", err)
+			return err
 		}
-		metaData.Version = latestVersion
+		stored, _ := getStoredReplicas(client, owners)
+		for _, hash := range restored.BlockHashList {
+			if len(stored[hash]) == 0 {
+				return fmt.Errorf("block %s of version %d is no longer stored", hash, version)
+			}
+		}
+	}
+
+	remoteIndex := make(map[string]*FileMetaData)
+	if err := client.GetFileInfoMap(&remoteIndex, nil, nil); err != nil {
 		return err
 	}
+	current, ok := remoteIndex[fileName]
+	if !ok {
+		return ERR_FILE_NOT_FOUND
+	}
+	if !sameContent(current, &restored) || current.Mode != restored.Mode {
+		var latestVersion int32
+		update := &FileMetaData{
+			Filename:      fileName,
+			Version:       current.Version + 1,
+			BlockHashList: restored.BlockHashList,
+			Mode:          restored.Mode,
+			Mtime:         restored.Mtime,
+			FileType:      restored.FileType,
+			SymlinkTarget: restored.SymlinkTarget,
+			Chunking:      restored.Chunking,
+		}
+		if err := client.UpdateFile(update, &latestVersion); err != nil {
+			return err
+		}
+		if latestVersion == -1 {
+			return fmt.Errorf("%s changed during the restore", fileName)
+		}
+		log.Printf("Restored %s to version %d as version %d\n", fileName, version, latestVersion)
+	}
 
-	file, err := os.Open(path)
+	_, err := ClientSync(client)
+	return err
+}
+
+// getRemoteIndex fetches the server's index, the cursor of its changes and
+// the namespace the index is in. With the cursor of the last sync, only the
+// files changed since are fetched and applied to the index entries of that
+// sync. The whole index is fetched when the server no longer knows the changes.
+func getRemoteIndex(client RPCClient, syncedIndex map[string]FileMetaData, cursor *Cursor) (map[string]*FileMetaData, *Cursor, string, error) {
+	if cursor != nil {
+		changes := make(map[string]*FileMetaData)
+		err := client.GetChangesSince(cursor, &changes)
+		if err == nil {
+			remoteIndex := make(map[string]*FileMetaData, len(syncedIndex)+len(changes))
+			for fileName, metaData := range syncedIndex {
+				metaData := metaData
+				remoteIndex[fileName] = &metaData
+			}
+			for fileName, metaData := range changes {
+				// The entries of the last sync include its own uploads, which
+				// may be later than the cursor
+				if synced, ok := remoteIndex[fileName]; !ok || metaData.Version >= synced.Version {
+					remoteIndex[fileName] = metaData
+				}
+			}
+			log.Println("Fetched ", len(changes), " changed files from the server")
+			return remoteIndex, cursor, client.Namespace, nil
+		}
+		log.Println("Could not fetch changes, fetching the whole index: ", err)
+	}
+
+	remoteIndex := make(map[string]*FileMetaData)
+	cursor = &Cursor{}
+	var namespace string
+	if err := client.GetFileInfoMap(&remoteIndex, cursor, &namespace); err != nil {
+		return nil, nil, "", err
+	}
+	return remoteIndex, cursor, namespace, nil
+}
+
+func isTombstone(blockHashList []string) bool {
+	return len(blockHashList) == 1 && blockHashList[0] == TOMBSTONE_HASH
+}
+
+// hashFile returns the hashes of the blocks of a file in the base directory,
+// cut into blocks the way chunking describes
+func hashFile(client RPCClient, fileName string, chunking string) ([]string, error) {
+	file, err := os.Open(localPath(client, fileName))
 	if err != nil {
-		log.Println("Error opening file: ", err)
+		return nil, err
 	}
 	defer file.Close()
 
-	fileStat, _ := os.Stat(path)
-	var numBlocks int = int(math.Ceil(float64(fileStat.Size()) / float64(client.BlockSize)))
-	for i := 0; i < numBlocks; i++ {
-		byteSlice := make([]byte, client.BlockSize)
-		len, err := file.Read(byteSlice)
-		if err != nil && err != io.EOF {
-			log.Println("Error reading bytes from file in basedir: ", err)
+	var hashes []string
+	chunker, err := newChunkerFor(client, chunking, file)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, GetBlockHashString(sealBlock(client, fileName, chunk)))
+	}
+}
+
+// localFileMetaData describes a file, directory or symlink in the base
+// directory. Its version is left at 0.
+func localFileMetaData(client RPCClient, fileName string, info os.FileInfo) (*FileMetaData, error) {
+	metaData := &FileMetaData{Filename: fileName}
+	switch {
+	case info.IsDir():
+		metaData.FileType = FileType_DIRECTORY
+		metaData.BlockHashList = []string{DIRECTORY_HASH}
+		metaData.Mode = uint32(info.Mode().Perm())
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(localPath(client, fileName))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateSymlinkTarget(target); err != nil {
+			return nil, err
+		}
+		metaData.FileType = FileType_SYMLINK
+		metaData.BlockHashList = []string{SYMLINK_HASH}
+		metaData.SymlinkTarget = target
+	default:
+		chunking := client.ChunkingSpec()
+		hashes, err := hashFile(client, fileName, chunking)
+		if err != nil {
+			return nil, err
+		}
+		metaData.FileType = FileType_REGULAR
+		metaData.BlockHashList = hashes
+		metaData.Chunking = chunking
+		metaData.Mode = uint32(info.Mode().Perm())
+		metaData.Mtime = info.ModTime().UnixNano()
+	}
+	return metaData, nil
+}
+
+// cachedFileMetaData describes a regular file from its index entry without
+// hashing it, if the file is in the state it was last synced in. It returns
+// nil if the file has to be hashed, and always with client.Verify set.
+func cachedFileMetaData(client RPCClient, index *LocalIndex, fileName string, info os.FileInfo) *FileMetaData {
+	indexed, ok := index.Files[fileName]
+	state, known := index.States[fileName]
+	if client.Verify || !ok || !known || !info.Mode().IsRegular() || indexed.FileType != FileType_REGULAR ||
+		isTombstone(indexed.BlockHashList) || state != newLocalFileState(info) {
+		return nil
+	}
+	return &FileMetaData{
+		Filename:      fileName,
+		FileType:      FileType_REGULAR,
+		BlockHashList: indexed.BlockHashList,
+		Mode:          uint32(info.Mode().Perm()),
+		Mtime:         info.ModTime().UnixNano(),
+		Chunking:      indexed.Chunking,
+	}
+}
+
+// changedLocally reports whether a file differs from its index entry. A new
+// mtime alone is not a change. A mode of 0 was written by an older client.
+func changedLocally(client RPCClient, indexed *FileMetaData, current *FileMetaData) bool {
+	return !sameLocalContent(client, current, indexed) || (indexed.Mode != 0 && indexed.Mode != current.Mode)
+}
+
+// sameLocalContent reports whether a file scanned in the base directory has
+// the content of an index or server entry. If the entry's blocks were cut
+// another way, the file is hashed again the way the entry's were.
+func sameLocalContent(client RPCClient, current *FileMetaData, other *FileMetaData) bool {
+	if current.Chunking == other.Chunking || current.Chunking == "" || other.Chunking == "" ||
+		current.FileType != FileType_REGULAR || other.FileType != FileType_REGULAR || isTombstone(other.BlockHashList) {
+		return sameContent(current, other)
+	}
+	hashes, err := hashFile(client, current.Filename, other.Chunking)
+	if err != nil {
+		log.Println("Could not compare file with ", other.Chunking, " blocks: ", err)
+		return false
+	}
+	return sameContent(&FileMetaData{BlockHashList: hashes}, other)
+}
+
+// sameContent reports whether two versions of a file have the same blocks or
+// link to the same target
+func sameContent(a *FileMetaData, b *FileMetaData) bool {
+	if len(a.BlockHashList) != len(b.BlockHashList) || a.SymlinkTarget != b.SymlinkTarget {
+		return false
+	}
+	for i := range a.BlockHashList {
+		if a.BlockHashList[i] != b.BlockHashList[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// saveConflictCopy moves the local version of a conflicting file or directory
+// to a new name and adds it to the local index as a new file. The file itself
+// is then replaced by the remote version.
+func saveConflictCopy(client RPCClient, fileName string, localIndex map[string]*FileMetaData, remoteIndex map[string]*FileMetaData) (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	dir, file := path.Split(fileName)
+	ext := path.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+	if base == "" {
+		base, ext = file, ""
+	}
+
+	var copyName string
+	for i := 1; ; i++ {
+		copyName = fmt.Sprintf("%s%s (conflicted copy from %s)%s", dir, base, host, ext)
+		if i > 1 {
+			copyName = fmt.Sprintf("%s%s (conflicted copy from %s %d)%s", dir, base, host, i, ext)
+		}
+		_, local := localIndex[copyName]
+		_, remote := remoteIndex[copyName]
+		if _, err := os.Lstat(localPath(client, copyName)); !local && !remote && errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
+	if err := ValidateFileName(copyName); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(localPath(client, fileName), localPath(client, copyName)); err != nil {
+		return "", err
+	}
+	renamed := []string{fileName}
+	if isDirectory(localIndex[fileName].BlockHashList) {
+		// The directory is new since the last sync, and so is everything in it
+		for name, metaData := range localIndex {
+			if strings.HasPrefix(name, fileName+"/") && !isTombstone(metaData.BlockHashList) {
+				renamed = append(renamed, name)
+			}
+		}
+	}
+	for _, name := range renamed {
+		newName := copyName + strings.TrimPrefix(name, fileName)
+		info, err := os.Lstat(localPath(client, newName))
+		if err != nil {
+			return "", err
+		}
+		metaData, err := localFileMetaData(client, newName, info)
+		if err != nil {
+			return "", err
+		}
+		metaData.Version = 1
+		localIndex[newName] = metaData
+		delete(localIndex, name)
+	}
+	// Version 0 makes the remote version replace the file
+	localIndex[fileName] = &FileMetaData{Filename: fileName, Version: 0}
+	return copyName, nil
+}
+
+// getBlockOwners asks the MetaStore which BlockStores hold a replica of each block
+func getBlockOwners(client RPCClient, blockHashes []string) (map[string][]string, error) {
+	var blockStoreMap map[string][]string
+	if err := client.GetBlockStoreMap(blockHashes, &blockStoreMap); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	for blockStoreAddr, hashes := range blockStoreMap {
+		for _, hash := range hashes {
+			owners[hash] = append(owners[hash], blockStoreAddr)
+		}
+	}
+	return owners, nil
+}
+
+// getStoredReplicas asks every BlockStore which of its blocks it already
+// stores, in one HasBlocks call per BlockStore, and returns the codecs each
+// BlockStore accepts from the same reply. A BlockStore that cannot be reached
+// is treated as storing none of them.
+func getStoredReplicas(client RPCClient, owners map[string][]string) (map[string]map[string]bool, map[string][]string) {
+	stored := make(map[string]map[string]bool)
+	codecs := make(map[string][]string)
+	blocksByAddr := make(map[string][]string)
+	for hash, replicas := range owners {
+		stored[hash] = make(map[string]bool)
+		for _, blockStoreAddr := range replicas {
+			blocksByAddr[blockStoreAddr] = append(blocksByAddr[blockStoreAddr], hash)
+		}
+	}
+
+	for blockStoreAddr, hashes := range blocksByAddr {
+		var hashesOut, codecsOut []string
+		if err := client.HasBlocks(hashes, blockStoreAddr, &hashesOut, &codecsOut); err != nil {
+			log.Println("Could not check blocks on ", blockStoreAddr, ": ", err)
+			continue
+		}
+		for _, hash := range hashesOut {
+			stored[hash][blockStoreAddr] = true
+		}
+		codecs[blockStoreAddr] = codecsOut
+	}
+	return stored, codecs
+}
+
+// getBlockReplicas reads a block from the first replica that returns the
+// expected content.
+func getBlockReplicas(client RPCClient, blockHash string, replicas []string, block *Block) error {
+	for _, blockStoreAddr := range replicas {
+		if err := client.GetBlock(blockHash, blockStoreAddr, block); err != nil {
+			log.Println("Failed to get block from ", blockStoreAddr, ": ", err)
+			continue
+		}
+		if GetBlockHashString(block.BlockData) == blockHash {
+			return nil
 		}
-		byteSlice = byteSlice[:len]
+		log.Println("Corrupt block from ", blockStoreAddr)
+	}
+	return fmt.Errorf("no replica returned block %s", blockHash)
+}
 
-		block := Block{BlockData: byteSlice, BlockSize: int32(len)}
-		
-		var succ bool
-		if err := client.PutBlock(&block, blockStoreAddr, &succ); err != nil {
-			log.Println("Failed to put block: ", err)
+func uploadFile(client RPCClient, pool *transferPool, metaData *FileMetaData, stats *SyncStats) error {
+	path := localPath(client, metaData.Filename)
+	var latestVersion int32
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) || isTombstone(metaData.BlockHashList) || isDirectory(metaData.BlockHashList) || isSymlink(metaData.BlockHashList) {
+		if err := client.UpdateFile(metaData, &latestVersion); err != nil {
+			log.Println("Could not upload file: ", err)
+			return err
+		}
+		if latestVersion == -1 {
+			return ERR_UPDATE_REJECTED
 		}
+		metaData.Version = latestVersion
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Println("Error opening file: ", err)
+	}
+	defer file.Close()
+
+	owners, err := getBlockOwners(client, metaData.BlockHashList)
+	if err != nil {
+		log.Println("Could not get block store map: ", err)
+		return err
+	}
+	stored, codecs := getStoredReplicas(client, owners)
+	if err := putFileBlocks(client, pool, metaData.Filename, metaData.Chunking, file, owners, stored, codecs, stats); err != nil {
+		log.Println("Failed to put blocks: ", err)
+		return err
 	}
 
 	if err := client.UpdateFile(metaData, &latestVersion); err != nil {
 		log.Println("Failed to update file: ", err)
-		metaData.Version = -1
+		return err
+	}
+	if latestVersion == -1 {
+		log.Println("Update of ", metaData.Filename, " rejected, it was changed by another client")
+		return ERR_UPDATE_REJECTED
 	}
 	metaData.Version = latestVersion
 
 	return nil
 }
 
-func downloadFile(client RPCClient, localMetaData *FileMetaData, remoteMetaData *FileMetaData, blockStoreAddr string) error{
-	path := client.BaseDir + "/" + remoteMetaData.Filename
-	file, err := os.Create(path)
+// downloadFile replaces the local version of a file with the remote one. It
+// returns the state of a downloaded regular file, nil for other entries.
+func downloadFile(client RPCClient, pool *transferPool, localMetaData *FileMetaData, remoteMetaData *FileMetaData) (*LocalFileState, error){
+	path, err := safeLocalPath(client, remoteMetaData.Filename)
+	if err != nil {
+		log.Println("Not writing file: ", err)
+		return nil, err
+	}
+
+	//File deleted in server
+	if len(remoteMetaData.BlockHashList) == 1 && remoteMetaData.BlockHashList[0] == TOMBSTONE_HASH {
+		*localMetaData = *remoteMetaData
+		err := os.Remove(path)
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+			// The next sync uploads the directory again with the files
+			// that were added to it here
+			log.Println("Keeping directory with unsynced files: ", remoteMetaData.Filename)
+			return nil, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			log.Println("Could not remove local file: ", err)
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if isDirectory(remoteMetaData.BlockHashList) {
+		if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+			if err := os.Remove(path); err != nil {
+				log.Println("Could not replace file with directory: ", err)
+				return nil, err
+			}
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			log.Println("Could not create directory: ", err)
+			return nil, err
+		}
+		*localMetaData = *remoteMetaData
+		return nil, nil
+	}
+
+	if isSymlink(remoteMetaData.BlockHashList) {
+		if err := validateDownloadedSymlink(remoteMetaData.Filename, remoteMetaData.SymlinkTarget); err != nil {
+			log.Println("Not creating symlink: ", err)
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Println("Could not create directory: ", err)
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println("Could not replace file with symlink: ", err)
+			return nil, err
+		}
+		if err := os.Symlink(remoteMetaData.SymlinkTarget, path); err != nil {
+			log.Println("Could not create symlink: ", err)
+			return nil, err
+		}
+		*localMetaData = *remoteMetaData
+		return nil, nil
+	}
+
+	owners, err := getBlockOwners(client, remoteMetaData.BlockHashList)
+	if err != nil {
+		log.Println("Could not get block store map: ", err)
+		return nil, err
+	}
+
+	// Write into a temporary file that replaces the local file once it is
+	// complete, so a failed download leaves both the file and the index unchanged
+	tmp, err := ioutil.TempFile(client.BaseDir, DOWNLOAD_TMP_PREFIX)
 	if err != nil {
 		log.Println("Error creating file: ", err)
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeFileBlocks(client, pool, remoteMetaData.Filename, tmp, remoteMetaData.BlockHashList, owners); err != nil {
+		log.Println("Failed to get blocks: ", err)
+		tmp.Close()
+		return nil, err
+	}
+	mode := os.FileMode(0644)
+	if remoteMetaData.Mode != 0 {
+		mode = os.FileMode(remoteMetaData.Mode)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if remoteMetaData.Mtime != 0 {
+		mtime := time.Unix(0, remoteMetaData.Mtime)
+		if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+			log.Println("Could not set modification time: ", err)
+		}
+	}
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Println("Could not create directory: ", err)
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		// Only an empty directory can be replaced by a file
+		if err := os.Remove(path); err != nil {
+			log.Println("Could not replace directory with file: ", err)
+			return nil, err
+		}
+	}
+	// A parent may have become a symlink while the blocks were downloaded
+	if _, err := safeLocalPath(client, remoteMetaData.Filename); err != nil {
+		log.Println("Not writing file: ", err)
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		log.Println("Error replacing file: ", err)
+		return nil, err
 	}
-	defer file.Close()
 
 	*localMetaData = *remoteMetaData
+	fileState := newLocalFileState(info)
+	return &fileState, nil
+}
 
-	//File deleted in server
-	if len(remoteMetaData.BlockHashList) == 1 && remoteMetaData.BlockHashList[0] == "0" {
-		if err := os.Remove(path); err != nil {
-			log.Println("Could not remove local file: ", err)
-			return err
+// putFileBlocks reads the blocks of file, cut the way chunking describes, and
+// streams each one to the replicas
+// that do not store it yet, one PutBlocks stream per BlockStore, taken from
+// pool. Blocks are compressed for the BlockStores whose codecs include the
+// client's. It succeeds once every block is stored on a majority of its
+// replicas.
+func putFileBlocks(client RPCClient, pool *transferPool, fileName string, chunking string, file *os.File, owners map[string][]string, stored map[string]map[string]bool, codecs map[string][]string, stats *SyncStats) error {
+	chunker, err := newChunkerFor(client, chunking, file)
+	if err != nil {
+		return err
+	}
+	type putResult struct {
+		hashes []string
+		err    error
+	}
+	streams := make(map[string]chan *Block)
+	results := make(map[string]*putResult)
+	for hash, replicas := range owners {
+		for _, blockStoreAddr := range replicas {
+			if _, ok := streams[blockStoreAddr]; ok || stored[hash][blockStoreAddr] {
+				continue
+			}
+			streams[blockStoreAddr] = make(chan *Block)
+			results[blockStoreAddr] = &putResult{}
+		}
+	}
+
+	slots := pool.acquire(len(streams))
+	defer pool.release(slots)
+	var wg sync.WaitGroup
+	for blockStoreAddr, blocks := range streams {
+		wg.Add(1)
+		go func(blockStoreAddr string, blocks chan *Block, result *putResult) {
+			defer wg.Done()
+			result.err = client.PutBlocks(blockStoreAddr, blocks, &result.hashes)
+		}(blockStoreAddr, blocks, results[blockStoreAddr])
+	}
+
+	// Compress blocks for the BlockStores that accept the client's codec
+	compressFor := make(map[string]bool)
+	if client.Compression != CODEC_NONE {
+		for blockStoreAddr := range streams {
+			compressFor[blockStoreAddr] = contains(codecs[blockStoreAddr], client.Compression)
+		}
+	}
+
+	// Blocks sent to each replica in this upload, so repeated blocks are sent once
+	sent := make(map[string]map[string]bool)
+	var readErr error
+	for {
+		byteSlice, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("error reading bytes from file in basedir: %v", err)
+			break
+		}
+		size := len(byteSlice)
+
+		byteSlice = sealBlock(client, fileName, byteSlice)
+		block := &Block{BlockData: byteSlice, BlockSize: int32(len(byteSlice))}
+		var compressed *Block
+		hash := GetBlockHashString(byteSlice)
+		if _, ok := owners[hash]; !ok {
+			readErr = fmt.Errorf("file changed while uploading")
+			break
+		}
+		if _, ok := sent[hash]; !ok {
+			sent[hash] = make(map[string]bool)
+		}
+
+		uploaded := false
+		var sentSize int64
+		for _, blockStoreAddr := range owners[hash] {
+			if stored[hash][blockStoreAddr] || sent[hash][blockStoreAddr] {
+				continue
+			}
+			toSend := block
+			if compressFor[blockStoreAddr] {
+				if compressed == nil {
+					compressed = compressBlock(byteSlice, client.Compression)
+				}
+				toSend = compressed
+			}
+			streams[blockStoreAddr] <- toSend
+			sent[hash][blockStoreAddr] = true
+			if !uploaded {
+				sentSize = int64(len(toSend.BlockData))
+			}
+			uploaded = true
+		}
+		if uploaded {
+			stats.BlocksUploaded++
+			stats.BytesUploaded += int64(size)
+			stats.BytesSent += sentSize
+		} else {
+			stats.BlocksSkipped++
+			stats.BytesSaved += int64(size)
+		}
+	}
+
+	for _, blocks := range streams {
+		close(blocks)
+	}
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+
+	for blockStoreAddr, result := range results {
+		if result.err != nil {
+			log.Println("Failed to put blocks on ", blockStoreAddr, ": ", result.err)
+		}
+		for _, hash := range result.hashes {
+			if _, ok := stored[hash]; ok {
+				stored[hash][blockStoreAddr] = true
+			}
+		}
+	}
+	for hash, replicas := range owners {
+		count := 0
+		for _, blockStoreAddr := range replicas {
+			if stored[hash][blockStoreAddr] {
+				count++
+			}
+		}
+		if len(replicas) == 0 || count < len(replicas)/2+1 {
+			return fmt.Errorf("block %s stored on %d of %d replicas", hash, count, len(replicas))
 		}
-		return nil
 	}
+	return nil
+}
 
-	data := ""
-	for _, hash := range remoteMetaData.BlockHashList {
-		var block Block
-		if err := client.GetBlock(hash, blockStoreAddr, &block); err != nil{
-			log.Println("Failed to get block: ", err)
+// writeFileBlocks streams the blocks of a file from their first replica, one
+// GetBlocks stream per BlockStore taken from pool, and writes them to file in
+// order. Only one
+// block per stream is held in memory. A block that cannot be streamed is read
+// from the other replicas, and a block that occurs again is copied from the
+// part of the file that is already written.
+func writeFileBlocks(client RPCClient, pool *transferPool, fileName string, file *os.File, blockHashList []string, owners map[string][]string) error {
+	hashesByAddr := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, hash := range blockHashList {
+		if len(owners[hash]) == 0 {
+			return fmt.Errorf("no BlockStore available for block %s", hash)
+		}
+		if seen[hash] {
+			continue
 		}
+		seen[hash] = true
+		hashesByAddr[owners[hash][0]] = append(hashesByAddr[owners[hash][0]], hash)
+	}
 
-		data += string(block.BlockData)
+	slots := pool.acquire(len(hashesByAddr))
+	defer pool.release(slots)
+	streams := make(map[string]chan *Block)
+	for blockStoreAddr, hashes := range hashesByAddr {
+		received := make(chan *Block)
+		streams[blockStoreAddr] = received
+		go func(blockStoreAddr string, hashes []string) {
+			if err := client.GetBlocks(hashes, blockStoreAddr, received); err != nil {
+				log.Println("Failed to get blocks from ", blockStoreAddr, ": ", err)
+			}
+		}(blockStoreAddr, hashes)
 	}
-	file.WriteString(data)
+	// Let the streams run to completion if we return early
+	defer func() {
+		for _, received := range streams {
+			for range received {
+			}
+		}
+	}()
 
+	type extent struct {
+		offset int64
+		size   int
+	}
+	written := make(map[string]extent)
+	var offset int64
+	for _, hash := range blockHashList {
+		var data []byte
+		if e, ok := written[hash]; ok {
+			data = make([]byte, e.size)
+			if _, err := file.ReadAt(data, e.offset); err != nil {
+				return err
+			}
+		} else {
+			blockStoreAddr := owners[hash][0]
+			block, ok := <-streams[blockStoreAddr]
+			if !ok || GetBlockHashString(block.BlockData) != hash {
+				if ok {
+					log.Println("Corrupt block from ", blockStoreAddr)
+				}
+				block = &Block{}
+				if err := getBlockReplicas(client, hash, owners[hash], block); err != nil {
+					return err
+				}
+			}
+			var err error
+			data, err = openBlock(client, fileName, block.BlockData)
+			if err != nil {
+				return err
+			}
+			written[hash] = extent{offset: offset, size: len(data)}
+		}
+
+		if _, err := file.Write(data); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// sealBlock encrypts a block before it is hashed and uploaded, if the client
+// encrypts
+func sealBlock(client RPCClient, fileName string, data []byte) []byte {
+	if client.Encryption == nil {
+		return data
+	}
+	return client.Encryption.EncryptBlock(fileName, data)
+}
+
+// openBlock decrypts a downloaded block, if the client encrypts
+func openBlock(client RPCClient, fileName string, data []byte) ([]byte, error) {
+	if client.Encryption == nil {
+		return data, nil
+	}
+	return client.Encryption.DecryptBlock(fileName, data)
+}
+
+// runWorkers runs the jobs with at most n of them at the same time
+func runWorkers(n int, jobs []func()) {
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job func()) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			job()
+		}(job)
+	}
+	wg.Wait()
+}
+
+// transferPool bounds the block streams that are open at the same time during
+// a sync. A file takes the slots for all of its streams at once, as it feeds
+// them from one loop, so a file waiting for slots never holds any. A file
+// with more streams than the pool has slots takes all of them.
+type transferPool struct {
+	size int
+	free int
+	mtx  sync.Mutex
+	cond *sync.Cond
+}
+
+func newTransferPool(size int) *transferPool {
+	if size < 1 {
+		size = 1
+	}
+	pool := &transferPool{size: size, free: size}
+	pool.cond = sync.NewCond(&pool.mtx)
+	return pool
+}
+
+// acquire waits for n slots, or all of them if n is larger than the pool, and
+// returns how many it took
+func (p *transferPool) acquire(n int) int {
+	if n > p.size {
+		n = p.size
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for p.free < n {
+		p.cond.Wait()
+	}
+	p.free -= n
+	return n
+}
+
+func (p *transferPool) release(n int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.free += n
+	p.cond.Broadcast()
+}
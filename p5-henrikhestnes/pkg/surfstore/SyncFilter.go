@@ -0,0 +1,166 @@
+package surfstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Patterns of files that are neither uploaded nor downloaded, in the style of
+// .gitignore. The file is synced like any other, so all clients share it.
+const IGNORE_FILENAME string = ".surfignore"
+
+// Subtrees of the base directory this client does not sync, one path per
+// line. The file is not synced, so each client has its own.
+const EXCLUDE_FILENAME string = ".surfstore-exclude"
+
+// SyncFilter tells which files a client leaves out of its syncs. A file that
+// is left out is neither uploaded, downloaded nor deleted, and its index
+// entry is kept as it is until it is synced again.
+type SyncFilter struct {
+	patterns []ignorePattern
+	excluded []string
+	// Changes whenever the rules change, so that files that were left out
+	// can be synced from the whole file info map
+	Fingerprint string
+}
+
+// ignorePattern is one line of an ignore file
+type ignorePattern struct {
+	// Split at "/", a "**" segment matches any number of path elements
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// LoadSyncFilter reads the ignore and exclude files of a base directory.
+// Missing files leave nothing out.
+func LoadSyncFilter(baseDir string) (*SyncFilter, error) {
+	ignoreRules, err := ioutil.ReadFile(filepath.Join(baseDir, IGNORE_FILENAME))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	excludeRules, err := ioutil.ReadFile(filepath.Join(baseDir, EXCLUDE_FILENAME))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return NewSyncFilter(string(ignoreRules), string(excludeRules)), nil
+}
+
+// NewSyncFilter parses the contents of an ignore and an exclude file
+func NewSyncFilter(ignoreRules string, excludeRules string) *SyncFilter {
+	filter := &SyncFilter{}
+	for _, line := range strings.Split(ignoreRules, "\n") {
+		if pattern, ok := parseIgnorePattern(line); ok {
+			filter.patterns = append(filter.patterns, pattern)
+		}
+	}
+	for _, line := range strings.Split(excludeRules, "\n") {
+		line = strings.Trim(strings.TrimSpace(line), "/")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		filter.excluded = append(filter.excluded, line)
+	}
+	if len(filter.patterns) > 0 || len(filter.excluded) > 0 {
+		h := sha256.New()
+		h.Write([]byte(ignoreRules))
+		h.Write([]byte{0})
+		h.Write([]byte(excludeRules))
+		filter.Fingerprint = hex.EncodeToString(h.Sum(nil))
+	}
+	return filter
+}
+
+// parseIgnorePattern parses a line of an ignore file. Blank lines, comments
+// and invalid patterns are skipped.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var pattern ignorePattern
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false
+	}
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A pattern with a slash is relative to the base directory, any other
+	// pattern matches a name at any depth
+	if strings.Contains(line, "/") {
+		pattern.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	} else {
+		pattern.segments = []string{"**", line}
+	}
+	for _, segment := range pattern.segments {
+		if _, err := path.Match(segment, ""); err != nil || segment == "" {
+			log.Println("Skipping invalid pattern in ", IGNORE_FILENAME, ": ", line)
+			return pattern, false
+		}
+	}
+	return pattern, true
+}
+
+// Skipped reports whether a file or directory is left out of syncs, either
+// itself or because one of its parent directories is
+func (f *SyncFilter) Skipped(name string, isDir bool) bool {
+	if isBelow(name, f.excluded) {
+		return true
+	}
+	elems := strings.Split(name, "/")
+	for i := 1; i <= len(elems); i++ {
+		if f.ignored(elems[:i], i < len(elems) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored reports whether the last pattern matching a path ignores it
+func (f *SyncFilter) ignored(elems []string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range f.patterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(pattern.segments, elems) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+func matchSegments(segments []string, elems []string) bool {
+	for len(segments) > 0 {
+		if segments[0] == "**" {
+			if len(segments) == 1 {
+				// A trailing "**" matches everything inside a directory
+				return len(elems) > 0
+			}
+			for i := 0; i <= len(elems); i++ {
+				if matchSegments(segments[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(segments[0], elems[0]); !ok {
+			return false
+		}
+		segments, elems = segments[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
@@ -0,0 +1,220 @@
+package surfstore
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const DEFAULT_WATCH_POLL_INTERVAL = 30 * time.Second
+const DEFAULT_WATCH_DEBOUNCE = 500 * time.Millisecond
+
+// Time between attempts to subscribe to the MetaStore's changes
+const WATCH_RETRY_INTERVAL = 5 * time.Second
+
+// SyncWatcher keeps a base directory in sync until it is stopped. Local
+// changes are picked up with inotify and synced once the base directory has
+// been quiet for Debounce, so that a burst of writes becomes one sync. Remote
+// changes are pushed by the MetaStore's Watch stream, and also picked up by
+// syncing every PollInterval in case the stream is down.
+type SyncWatcher struct {
+	client       RPCClient
+	PollInterval time.Duration
+	Debounce     time.Duration
+	// OnSync is called after every successful sync with its stats
+	OnSync func(SyncStats)
+
+	// Files whose changes do not start a sync, read again after every sync
+	filter *SyncFilter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSyncWatcher(client RPCClient, pollInterval time.Duration, debounce time.Duration) *SyncWatcher {
+	return &SyncWatcher{
+		client:       client,
+		PollInterval: pollInterval,
+		Debounce:     debounce,
+		OnSync:       func(SyncStats) {},
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Run syncs once and then on every local change and poll until Stop is
+// called. It fails only if the base directory cannot be watched.
+func (sw *SyncWatcher) Run() error {
+	defer close(sw.done)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := sw.watchTree(watcher, sw.client.BaseDir); err != nil {
+		return err
+	}
+
+	sw.sync()
+
+	// Watch from the cursor of the first sync, so that the changes accepted
+	// while it ran are sent too
+	remote := make(chan struct{}, 1)
+	go sw.watchRemote(sw.syncedCursor(), remote)
+
+	var poll <-chan time.Time
+	if sw.PollInterval > 0 {
+		ticker := time.NewTicker(sw.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	// Set while local changes wait for the base directory to become quiet
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case <-sw.stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if sw.ignoreEvent(event) {
+				continue
+			}
+			if event.Op&fsnotify.Create != 0 {
+				// Inotify watches are not recursive. Files created in the new
+				// directory before its watch is added are found by the sync.
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := sw.watchTree(watcher, event.Name); err != nil {
+						log.Println("Could not watch ", event.Name, ": ", err)
+					}
+				}
+			}
+			debounce = time.After(sw.Debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Events may have been dropped, so sync to find the changes
+			log.Println("Watch error: ", err)
+			debounce = time.After(sw.Debounce)
+		case <-remote:
+			debounce = time.After(sw.Debounce)
+		case <-debounce:
+			debounce = nil
+			sw.sync()
+		case <-poll:
+			sw.sync()
+		}
+	}
+}
+
+// sync syncs the base directory and reloads the filter, which the sync may
+// have changed. A failed sync is retried on the next local change, remote
+// change or poll.
+func (sw *SyncWatcher) sync() {
+	stats, err := ClientSync(sw.client)
+	if err != nil {
+		log.Println("Sync failed: ", err)
+		return
+	}
+	sw.OnSync(stats)
+	filter, err := LoadSyncFilter(sw.client.BaseDir)
+	if err != nil {
+		log.Println("Could not load ignore rules: ", err)
+	}
+	sw.filter = filter
+}
+
+// Stop ends Run and waits for a sync in progress to finish
+func (sw *SyncWatcher) Stop() {
+	close(sw.stop)
+	<-sw.done
+}
+
+// syncedCursor returns the cursor of the last sync, or nil for the
+// MetaStore's next change if the index has none
+func (sw *SyncWatcher) syncedCursor() *Cursor {
+	index, err := LoadLocalIndex(sw.client.BaseDir)
+	if err != nil {
+		return nil
+	}
+	return index.Cursor
+}
+
+// watchRemote signals remote for every change the MetaStore accepts after the
+// cursor from until the watcher is stopped. A broken stream is opened again
+// after WATCH_RETRY_INTERVAL, and signals remote once more in case it missed a
+// change in the meantime.
+func (sw *SyncWatcher) watchRemote(from *Cursor, remote chan<- struct{}) {
+	notify := func() {
+		select {
+		case remote <- struct{}{}:
+		default:
+		}
+	}
+	for {
+		changes := make(chan *FileChange)
+		result := make(chan error, 1)
+		go func(from *Cursor) {
+			result <- sw.client.Watch(from, sw.stop, changes)
+		}(from)
+		for range changes {
+			notify()
+		}
+		err := <-result
+		select {
+		case <-sw.stop:
+			return
+		default:
+		}
+		log.Println("Watching the MetaStore failed: ", err)
+		from = nil
+		select {
+		case <-sw.stop:
+			return
+		case <-time.After(WATCH_RETRY_INTERVAL):
+		}
+		notify()
+	}
+}
+
+// watchTree adds a watch for dir and every directory below it. Symlinks are
+// not followed.
+func (sw *SyncWatcher) watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			log.Println("Could not watch ", p, ": ", err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return watcher.Add(p)
+	})
+}
+
+// ignoreEvent reports whether an event is about one of the client's own files,
+// which every sync writes, or a file left out of syncs
+func (sw *SyncWatcher) ignoreEvent(event fsnotify.Event) bool {
+	rel, err := filepath.Rel(sw.client.BaseDir, event.Name)
+	if err != nil {
+		return false
+	}
+	name := filepath.ToSlash(rel)
+	if isReservedName(name) {
+		return true
+	}
+	if sw.filter == nil {
+		return false
+	}
+	info, err := os.Lstat(event.Name)
+	return sw.filter.Skipped(name, err == nil && info.IsDir())
+}
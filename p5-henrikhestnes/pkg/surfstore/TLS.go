@@ -0,0 +1,168 @@
+package surfstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Connections use TLS once the TLS section names a certificate or a CA. The
+// Raft servers can use a separate identity among themselves: their Raft
+// address serves the peer certificate and only accepts callers with a
+// certificate signed by the peer CA, while clients, BlockStores and the
+// MetaStore's calls to them use the other one. Peer settings that are not set
+// fall back to the client traffic ones.
+
+// Load reads the certificates and keys the TLS section names. The
+// credentials methods load them on first use and exit if that fails, so call
+// Load first to handle errors.
+func (c *TLSConfig) Load() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	loaded := *c
+	var err error
+	if loaded.caPool, err = loadCertPool(c.CAFile); err != nil {
+		return err
+	}
+	if loaded.cert, err = loadKeyPair(c.CertFile, c.KeyFile); err != nil {
+		return err
+	}
+	if loaded.peerCAPool, err = loadCertPool(c.PeerCAFile); err != nil {
+		return err
+	}
+	if loaded.peerCAPool == nil {
+		loaded.peerCAPool = loaded.caPool
+	}
+	if loaded.peerCert, err = loadKeyPair(c.PeerCertFile, c.PeerKeyFile); err != nil {
+		return err
+	}
+	if loaded.peerCert == nil {
+		loaded.peerCert = loaded.cert
+	}
+	loaded.loaded = true
+	*c = loaded
+	return nil
+}
+
+func (c *TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("tls certFile and keyFile must be set together")
+	}
+	if (c.PeerCertFile == "") != (c.PeerKeyFile == "") {
+		return fmt.Errorf("tls peerCertFile and peerKeyFile must be set together")
+	}
+	if c.Mutual && c.CAFile == "" {
+		return fmt.Errorf("tls mutual needs a caFile to check callers against")
+	}
+	return nil
+}
+
+// HasPeerIdentity reports whether Raft peer traffic uses other credentials
+// than client traffic
+func (c *TLSConfig) HasPeerIdentity() bool {
+	return c.PeerCAFile != "" || c.PeerCertFile != ""
+}
+
+func (c *TLSConfig) ensureLoaded() {
+	if c.loaded {
+		return
+	}
+	if err := c.Load(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ClientCredentials returns the credentials to dial clients' servers with:
+// the MetaStore, the BlockStores and the Raft servers' client address
+func (c *TLSConfig) ClientCredentials() credentials.TransportCredentials {
+	c.ensureLoaded()
+	return clientTLSCredentials(c.caPool, c.cert)
+}
+
+// ServerCredentials returns the credentials to serve client traffic with
+func (c *TLSConfig) ServerCredentials() credentials.TransportCredentials {
+	c.ensureLoaded()
+	clientAuth := tls.NoClientCert
+	if c.Mutual {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return serverTLSCredentials(c.cert, c.caPool, clientAuth)
+}
+
+// PeerClientCredentials returns the credentials a Raft server dials its peers with
+func (c *TLSConfig) PeerClientCredentials() credentials.TransportCredentials {
+	c.ensureLoaded()
+	return clientTLSCredentials(c.peerCAPool, c.peerCert)
+}
+
+// PeerServerCredentials returns the credentials a Raft server serves its Raft
+// address with. Peers must present a certificate signed by the peer CA.
+func (c *TLSConfig) PeerServerCredentials() credentials.TransportCredentials {
+	c.ensureLoaded()
+	clientAuth := tls.NoClientCert
+	if c.peerCAPool != nil {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return serverTLSCredentials(c.peerCert, c.peerCAPool, clientAuth)
+}
+
+// clientTLSCredentials verifies servers against caPool, or the system's CAs
+// if it is nil, and presents cert if it is set. Without either, connections
+// are in plaintext.
+func clientTLSCredentials(caPool *x509.CertPool, cert *tls.Certificate) credentials.TransportCredentials {
+	if caPool == nil && cert == nil {
+		return insecure.NewCredentials()
+	}
+	config := &tls.Config{RootCAs: caPool, MinVersion: tls.VersionTLS12}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return credentials.NewTLS(config)
+}
+
+// serverTLSCredentials serves cert, and checks the certificates of callers
+// against caPool as clientAuth asks. Without a certificate, connections are
+// in plaintext.
+func serverTLSCredentials(cert *tls.Certificate, caPool *x509.CertPool, clientAuth tls.ClientAuthType) credentials.TransportCredentials {
+	if cert == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		ClientCAs:    caPool,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tls CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in tls CA %s", caFile)
+	}
+	return pool, nil
+}
+
+func loadKeyPair(certFile string, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tls certificate: %v", err)
+	}
+	return &cert, nil
+}
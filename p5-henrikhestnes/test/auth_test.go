@@ -0,0 +1,244 @@
+package SurfTest
+
+import (
+	"context"
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+const AUTH_CONFIG_PATH = "./config_files/3nodes_auth.yaml"
+
+// callAs returns a context for calls made with token in namespace, either
+// of which may be empty
+func callAs(token string, namespace string) context.Context {
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, surfstore.AUTH_METADATA_KEY, surfstore.AUTH_SCHEME+token)
+	}
+	if namespace != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, surfstore.NAMESPACE_METADATA_KEY, namespace)
+	}
+	return ctx
+}
+
+func expectCode(t *testing.T, err error, code codes.Code, call string) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("Expected %s to fail with %v, got %v", call, code, err)
+	}
+}
+
+func TestAuthenticatedNamespaces(t *testing.T) {
+	config, err := surfstore.LoadClusterConfig(AUTH_CONFIG_PATH)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+	service := config.Auth.ServiceToken
+	metaStore := surfstore.NewMetaStore(config.BlockStoreAddrs(), 0)
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(surfstore.NewAuthenticator(config.Auth).ServerOptions()...)
+	surfstore.RegisterMetaStoreServer(grpcServer, metaStore)
+	surfstore.RegisterBlockStoreServer(grpcServer, surfstore.NewBlockStore())
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	metaClient := surfstore.NewMetaStoreClient(conn)
+	blockClient := surfstore.NewBlockStoreClient(conn)
+
+	_, err = metaClient.GetFileInfoMap(callAs("", ""), &emptypb.Empty{})
+	expectCode(t, err, codes.Unauthenticated, "GetFileInfoMap without a token")
+	_, err = metaClient.GetFileInfoMap(callAs("mallory-secret", ""), &emptypb.Empty{})
+	expectCode(t, err, codes.Unauthenticated, "GetFileInfoMap with an unknown token")
+	_, err = blockClient.HasBlocks(callAs("", ""), &surfstore.BlockHashes{Hashes: []string{"h"}})
+	expectCode(t, err, codes.Unauthenticated, "HasBlocks without a token")
+	_, err = blockClient.ListBlocks(callAs("alice-secret", ""), &emptypb.Empty{})
+	expectCode(t, err, codes.PermissionDenied, "ListBlocks as a user")
+	_, err = blockClient.ListBlocks(callAs(service, ""), &emptypb.Empty{})
+	expectCode(t, err, codes.OK, "ListBlocks as the service")
+	_, err = metaClient.UpdateFile(callAs("bob-secret", "alice"), &surfstore.FileMetaData{Filename: "a.txt", Version: 1, BlockHashList: []string{"h"}})
+	expectCode(t, err, codes.PermissionDenied, "UpdateFile in another user's namespace")
+	_, err = metaClient.GetFileInfoMap(callAs("alice-secret", "../bob"), &emptypb.Empty{})
+	expectCode(t, err, codes.InvalidArgument, "GetFileInfoMap in an invalid namespace")
+	_, err = blockClient.PutBlock(callAs("alice-secret", ""), &surfstore.Block{BlockData: []byte("data"), BlockSize: 4})
+	expectCode(t, err, codes.OK, "PutBlock as a user")
+
+	update := func(ctx context.Context, fileName string, hash string) {
+		t.Helper()
+		version, err := metaClient.UpdateFile(ctx, &surfstore.FileMetaData{Filename: fileName, Version: 1, BlockHashList: []string{hash}})
+		if err != nil || version.Version != 1 {
+			t.Fatalf("Expected version 1 of %s, got %v %v", fileName, version, err)
+		}
+	}
+	fileHashes := func(ctx context.Context) map[string]string {
+		t.Helper()
+		fileInfoMap, err := metaClient.GetFileInfoMap(ctx, &emptypb.Empty{})
+		if err != nil {
+			t.Fatalf("GetFileInfoMap failed: %v", err)
+		}
+		hashes := make(map[string]string)
+		for fileName, fileMetaData := range fileInfoMap.FileInfoMap {
+			hashes[fileName] = fileMetaData.BlockHashList[0]
+		}
+		return hashes
+	}
+	// Alice's first namespace is used when she names none
+	update(callAs("alice-secret", ""), "a.txt", "alice")
+	update(callAs("bob-secret", "bob"), "a.txt", "bob")
+	update(callAs("bob-secret", "shared"), "s.txt", "shared")
+
+	expected := map[string]map[string]string{
+		"alice":  {"a.txt": "alice"},
+		"bob":    {"a.txt": "bob"},
+		"shared": {"s.txt": "shared"},
+	}
+	for namespace, want := range expected {
+		if got := fileHashes(callAs(service, namespace)); !reflect.DeepEqual(want, got) {
+			t.Fatalf("Expected %v in namespace %s, got %v", want, namespace, got)
+		}
+	}
+	if got := fileHashes(callAs("alice-secret", "shared")); got["s.txt"] != "shared" || len(got) != 1 {
+		t.Fatalf("Expected alice to see the shared files, got %v", got)
+	}
+	if got := fileHashes(callAs(service, "")); len(got) != 0 {
+		t.Fatalf("Expected no files in the default namespace, got %v", got)
+	}
+	fileMetaData, err := metaClient.GetFileVersion(callAs("alice-secret", ""), &surfstore.FileVersion{Filename: "a.txt", Version: 1})
+	if err != nil || fileMetaData.BlockHashList[0] != "alice" {
+		t.Fatalf("Expected alice's version of a.txt, got %v %v", fileMetaData, err)
+	}
+
+	// A file name cannot reach into another namespace
+	aliceFile := &surfstore.FileVersion{Filename: "alice" + surfstore.NAMESPACE_SEPARATOR + "a.txt", Version: 1}
+	if history, err := metaClient.GetFileHistory(callAs(service, ""), aliceFile); err == nil || !strings.Contains(err.Error(), surfstore.ERR_INVALID_PATH.Error()) {
+		t.Fatalf("Expected GetFileHistory of alice's file from the default namespace to fail, got %v %v", history, err)
+	}
+	if fileMetaData, err := metaClient.GetFileVersion(callAs(service, ""), aliceFile); err == nil || !strings.Contains(err.Error(), surfstore.ERR_INVALID_PATH.Error()) {
+		t.Fatalf("Expected GetFileVersion of alice's file from the default namespace to fail, got %v %v", fileMetaData, err)
+	}
+
+	// Changes in other namespaces are left out, but still count
+	changes, err := metaClient.GetChangesSince(callAs("alice-secret", "shared"), &surfstore.Cursor{Epoch: metaStore.Epoch, Index: 0})
+	if err != nil || len(changes.FileMetaData) != 1 || changes.FileMetaData[0].Filename != "s.txt" || changes.Cursor.Index != 3 {
+		t.Fatalf("Expected only s.txt up to change 3, got %v %v", changes, err)
+	}
+	stream, err := metaClient.Watch(callAs("bob-secret", "bob"), &surfstore.WatchRequest{FromIndex: 1, Epoch: metaStore.Epoch})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	change, err := stream.Recv()
+	if err != nil || change.Index != 2 || change.Namespace != "bob" {
+		t.Fatalf("Expected change 2 in bob's namespace, got %v %v", change, err)
+	}
+}
+
+func TestSyncNamespaces(t *testing.T) {
+	t.Logf("clients only sync the files of their namespace, and only in namespaces their token grants")
+	config, err := surfstore.LoadClusterConfig(AUTH_CONFIG_PATH)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+	// The BlockStore reads the auth section from the config
+	blockStore := InitBlockStore("8080", "-f", AUTH_CONFIG_PATH)
+	test := InitTestWithBlockStores(AUTH_CONFIG_PATH, nil)
+	test.Procs = append(test.Procs, blockStore)
+	defer EndTest(test)
+	service := metadata.AppendToOutgoingContext(test.Context, surfstore.AUTH_METADATA_KEY, surfstore.AUTH_SCHEME+config.Auth.ServiceToken)
+	test.Clients[0].SetLeader(service, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(service, &emptypb.Empty{})
+	if _, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected GetInternalState without a token to fail, got %v", err)
+	}
+
+	workers := make([]*DirectoryWorker, 4)
+	for i := range workers {
+		workers[i] = InitDirectoryWorker("test"+string(rune('0'+i)), SRC_PATH)
+		defer workers[i].CleanUp()
+	}
+	newClient := func(worker *DirectoryWorker, token string, namespace string) surfstore.RPCClient {
+		client := surfstore.NewSurfstoreRPCClient(test.Ips, worker.DirectoryName, 4)
+		client.Token, client.Namespace = token, namespace
+		return client
+	}
+	alice := newClient(workers[0], "alice-secret", "")
+	bob := newClient(workers[1], "bob-secret", "bob")
+	aliceShared := newClient(workers[2], "alice-secret", "shared")
+	bobShared := newClient(workers[3], "bob-secret", "shared")
+
+	write := func(worker *DirectoryWorker, fileName string, content string) {
+		if err := ioutil.WriteFile(filepath.Join(worker.DirectoryName, fileName), []byte(content), 0644); err != nil {
+			t.FailNow()
+		}
+	}
+	write(workers[0], "same.txt", "alice's")
+	write(workers[1], "same.txt", "bob's")
+	write(workers[2], "shared.txt", "shared")
+	for _, client := range []surfstore.RPCClient{alice, bob, aliceShared, bobShared, alice, bob} {
+		if stats, err := surfstore.ClientSync(client); err != nil || len(stats.Conflicts) > 0 {
+			t.Fatalf("Unexpected conflicts in %s: %v", client.BaseDir, stats.Conflicts)
+		}
+	}
+
+	expected := map[*DirectoryWorker]map[string]string{
+		workers[0]: {"same.txt": "alice's"},
+		workers[1]: {"same.txt": "bob's"},
+		workers[2]: {"shared.txt": "shared"},
+		workers[3]: {"shared.txt": "shared"},
+	}
+	for worker, want := range expected {
+		if got := treeContents(t, worker.DirectoryName); !reflect.DeepEqual(want, got) {
+			t.Fatalf("Expected %v in %s, got %v", want, worker.DirectoryName, got)
+		}
+	}
+	// The index records the namespace the server picked for alice, so naming
+	// it does not make her files look new
+	if index, err := surfstore.LoadLocalIndex(workers[0].DirectoryName); err != nil || index.Namespace != "alice" {
+		t.Fatalf("Expected the index to record namespace alice, got %v %v", index, err)
+	}
+	if stats, err := surfstore.ClientSync(newClient(workers[0], "alice-secret", "alice")); err != nil || stats.BlocksUploaded > 0 || len(stats.Conflicts) > 0 || len(stats.Rejected) > 0 {
+		t.Fatalf("Naming the namespace the index was synced in changed the files: %+v", stats)
+	}
+
+	// The internal state holds the files of every namespace, whatever the
+	// caller's namespace is
+	state, err := test.Clients[0].GetInternalState(metadata.AppendToOutgoingContext(service, surfstore.NAMESPACE_METADATA_KEY, "alice"), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	keys := make([]string, 0)
+	for key, fileMetaData := range state.MetaMap.FileInfoMap {
+		if fileMetaData.Version != 1 {
+			t.Fatalf("Expected version 1 of %q, got %d", key, fileMetaData.Version)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sep := surfstore.NAMESPACE_SEPARATOR
+	if want := []string{"alice" + sep + "same.txt", "bob" + sep + "same.txt", "shared" + sep + "shared.txt"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Expected the files of every namespace %q, got %q", want, keys)
+	}
+
+	var fileInfoMap map[string]*surfstore.FileMetaData
+	intruder := newClient(workers[1], "bob-secret", "alice")
+	expectCode(t, intruder.GetFileInfoMap(&fileInfoMap, nil, nil), codes.PermissionDenied, "GetFileInfoMap in another user's namespace")
+	anonymous := newClient(workers[1], "", "")
+	expectCode(t, anonymous.GetFileInfoMap(&fileInfoMap, nil, nil), codes.Unauthenticated, "GetFileInfoMap without a token")
+}
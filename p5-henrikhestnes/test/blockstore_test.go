@@ -0,0 +1,1184 @@
+package SurfTest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"cse224/proj5/pkg/surfstore"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestConsistentHashRing(t *testing.T) {
+	addrs := []string{"localhost:8081", "localhost:8082", "localhost:8083"}
+	ring := surfstore.NewConsistentHashRing(addrs, 0)
+
+	counts := make(map[string]int)
+	owners := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		hash := surfstore.GetBlockHashString([]byte(strconv.Itoa(i)))
+		owner := ring.GetResponsibleServer(hash)
+		counts[owner]++
+		owners[hash] = owner
+	}
+	for _, addr := range addrs {
+		if counts[addr] < 500 {
+			t.Fatalf("BlockStore %s only got %d of 3000 blocks", addr, counts[addr])
+		}
+	}
+
+	// Removing a server should only move the blocks it owned
+	smallerRing := surfstore.NewConsistentHashRing(addrs[:2], 0)
+	for hash, owner := range owners {
+		if owner != addrs[2] && smallerRing.GetResponsibleServer(hash) != owner {
+			t.Fatalf("Block %s moved from %s although its server was not removed", hash, owner)
+		}
+	}
+}
+
+// Two clients sync through a cluster with two BlockStores; blocks end up spread over both.
+func TestSyncMultipleBlockStores(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	blockStorePorts := []string{"8081", "8082"}
+	test := InitTestWithBlockStores(cfgPath, blockStorePorts, "-b", "localhost:8081,localhost:8082")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	// Small blocks so the file is split over several BlockStores
+	blockSize := 4
+	file1 := "multi_file1.txt"
+	if err := worker1.AddFile(file1); err != nil {
+		t.FailNow()
+	}
+
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if err := SyncClient("localhost:8080", "test1", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	hashList := state.MetaMap.FileInfoMap[file1].BlockHashList
+
+	stored := 0
+	for _, port := range blockStorePorts {
+		conn, err := grpc.Dial("localhost:"+port, grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("Could not connect to BlockStore: %v", err)
+		}
+		has, err := surfstore.NewBlockStoreClient(conn).HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: hashList})
+		conn.Close()
+		if err != nil {
+			t.Fatalf("HasBlocks failed: %v", err)
+		}
+		if len(has.Hashes) == 0 {
+			t.Fatalf("BlockStore on port %s holds none of the file's blocks", port)
+		}
+		stored += len(has.Hashes)
+	}
+	if stored != len(hashList) {
+		t.Fatalf("Expected %d blocks stored once, found %d", len(hashList), stored)
+	}
+}
+
+// A BlockStore dies after a sync; clients still read its blocks from the other
+// replica and the leader copies them to a new one.
+func TestBlockStoreReplication(t *testing.T) {
+	cfgPath := "./config_files/3nodes_replicated.yaml"
+	blockStorePorts := []string{"8081", "8082", "8083"}
+	test := InitTestWithBlockStores(cfgPath, blockStorePorts)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	blockSize := 4
+	file1 := "multi_file1.txt"
+	if err := worker1.AddFile(file1); err != nil {
+		t.FailNow()
+	}
+
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	hashList := state.MetaMap.FileInfoMap[file1].BlockHashList
+	if holders := countReplicas(t, blockStorePorts, hashList); len(holders) != len(uniqueHashes(hashList)) {
+		t.Fatalf("Not every block was stored")
+	} else {
+		for hash, count := range holders {
+			if count != 2 {
+				t.Fatalf("Block %s stored %d times, expected 2", hash, count)
+			}
+		}
+	}
+
+	// Procs start with the BlockStores in port order
+	test.Procs[0].Process.Kill()
+	test.Procs[0].Wait()
+
+	if err := SyncClient("localhost:8080", "test1", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced after a BlockStore failed")
+	}
+
+	// Wait for the leader to declare the BlockStore dead and repair
+	time.Sleep(3 * time.Second)
+	holders := countReplicas(t, blockStorePorts[1:], hashList)
+	for _, hash := range uniqueHashes(hashList) {
+		if holders[hash] != 2 {
+			t.Fatalf("Block %s has %d live replicas, expected 2", hash, holders[hash])
+		}
+	}
+}
+
+// countReplicas returns how many of the BlockStores hold each of the hashes
+func countReplicas(t *testing.T, ports []string, hashList []string) map[string]int {
+	holders := make(map[string]int)
+	for _, port := range ports {
+		conn, err := grpc.Dial("localhost:"+port, grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("Could not connect to BlockStore: %v", err)
+		}
+		has, err := surfstore.NewBlockStoreClient(conn).HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: uniqueHashes(hashList)})
+		conn.Close()
+		if err != nil {
+			t.Fatalf("HasBlocks failed: %v", err)
+		}
+		for _, hash := range has.Hashes {
+			holders[hash]++
+		}
+	}
+	return holders
+}
+
+func uniqueHashes(hashList []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0)
+	for _, hash := range hashList {
+		if !seen[hash] {
+			seen[hash] = true
+			unique = append(unique, hash)
+		}
+	}
+	return unique
+}
+
+func TestDiskBlockStore(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := surfstore.NewDiskBackend(dir)
+	if err != nil {
+		t.Fatalf("Could not create disk BlockStore: %v", err)
+	}
+
+	blockStore := surfstore.NewBlockStoreWithBackend(backend)
+
+	data := []byte("disk backed block")
+	hash := surfstore.GetBlockHashString(data)
+	if _, err := blockStore.PutBlock(context.Background(), &surfstore.Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+	path := filepath.Join(dir, hash[0:2], hash[2:4], hash)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Block not stored at %s: %v", path, err)
+	}
+
+	// Blocks survive a restart
+	backend, err = surfstore.NewDiskBackend(dir)
+	if err != nil {
+		t.Fatalf("Could not reopen disk BlockStore: %v", err)
+	}
+	blockStore = surfstore.NewBlockStoreWithBackend(backend)
+	has, err := blockStore.HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: []string{hash, "../../etc/passwd"}})
+	if err != nil || len(has.Hashes) != 1 || has.Hashes[0] != hash {
+		t.Fatalf("HasBlocks returned %v, %v", has, err)
+	}
+	block, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: hash})
+	if err != nil || string(block.BlockData) != string(data) {
+		t.Fatalf("GetBlock returned %v, %v", block, err)
+	}
+
+	// A block whose content no longer matches its hash is not returned
+	if err := ioutil.WriteFile(path, []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("Could not corrupt block: %v", err)
+	}
+	if _, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: hash}); err == nil {
+		t.Fatalf("GetBlock returned a corrupt block")
+	}
+}
+
+// Every storage backend behaves the same behind the BlockBackend interface
+func TestBlockBackends(t *testing.T) {
+	s3Server := NewFakeS3Server()
+	defer s3Server.Close()
+
+	newBackends := map[string]func() (surfstore.BlockBackend, error){
+		"memory": func() (surfstore.BlockBackend, error) {
+			return surfstore.NewMemoryBackend(), nil
+		},
+		"disk": func() (surfstore.BlockBackend, error) {
+			return surfstore.NewDiskBackend(t.TempDir())
+		},
+		"bolt": func() (surfstore.BlockBackend, error) {
+			backend, err := surfstore.NewBoltBackend(filepath.Join(t.TempDir(), "blocks.db"))
+			if err == nil {
+				t.Cleanup(func() { backend.Close() })
+			}
+			return backend, err
+		},
+		"s3": func() (surfstore.BlockBackend, error) {
+			return surfstore.NewS3Backend(s3Server.URL, "blocks", "", "access", "secret")
+		},
+	}
+
+	for name, newBackend := range newBackends {
+		t.Run(name, func(t *testing.T) {
+			backend, err := newBackend()
+			if err != nil {
+				t.Fatalf("Could not create backend: %v", err)
+			}
+
+			blocks := [][]byte{[]byte("first block"), []byte("second block!")}
+			for _, data := range blocks {
+				if err := backend.Put(surfstore.GetBlockHashString(data), &surfstore.Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+			}
+			hash := surfstore.GetBlockHashString(blocks[0])
+
+			block, err := backend.Get(hash)
+			if err != nil || string(block.BlockData) != string(blocks[0]) {
+				t.Fatalf("Get returned %v, %v", block, err)
+			}
+			missing := surfstore.GetBlockHashString([]byte("missing"))
+			if _, err := backend.Get(missing); err != surfstore.ERR_BLOCK_NOT_FOUND {
+				t.Fatalf("Get of a missing block returned %v", err)
+			}
+			if ok, err := backend.Has(missing); ok || err != nil {
+				t.Fatalf("Has of a missing block returned %v, %v", ok, err)
+			}
+
+			stats, err := backend.Stats()
+			if err != nil || stats.Blocks != 2 || stats.Bytes != int64(len(blocks[0])+len(blocks[1])) {
+				t.Fatalf("Stats returned %+v, %v", stats, err)
+			}
+
+			if err := backend.Delete(hash); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if ok, err := backend.Has(hash); ok || err != nil {
+				t.Fatalf("Has after Delete returned %v, %v", ok, err)
+			}
+			if err := backend.Delete(hash); err != nil {
+				t.Fatalf("Delete of a missing block failed: %v", err)
+			}
+
+			hashes := make([]string, 0)
+			err = backend.Iterate(func(hash string) error {
+				hashes = append(hashes, hash)
+				return nil
+			})
+			if err != nil || len(hashes) != 1 || hashes[0] != surfstore.GetBlockHashString(blocks[1]) {
+				t.Fatalf("Iterate returned %v, %v", hashes, err)
+			}
+
+			// The codec of a compressed block is stored with it
+			compressedHash := surfstore.GetBlockHashString([]byte("compressed block"))
+			compressed := &surfstore.Block{BlockData: []byte("gzip data"), BlockSize: 16, Codec: surfstore.CODEC_GZIP}
+			if err := backend.Put(compressedHash, compressed); err != nil {
+				t.Fatalf("Put of a compressed block failed: %v", err)
+			}
+			block, err = backend.Get(compressedHash)
+			if err != nil || string(block.BlockData) != "gzip data" || block.Codec != surfstore.CODEC_GZIP {
+				t.Fatalf("Get of a compressed block returned %v, %v", block, err)
+			}
+			if err := backend.Delete(compressedHash); err != nil {
+				t.Fatalf("Delete of a compressed block failed: %v", err)
+			}
+			if ok, err := backend.Has(compressedHash); ok || err != nil {
+				t.Fatalf("Has after Delete of a compressed block returned %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestDeleteBlocksGracePeriod(t *testing.T) {
+	blockStore := surfstore.NewBlockStore()
+	data := []byte("recently written")
+	hash := surfstore.GetBlockHashString(data)
+	if _, err := blockStore.PutBlock(context.Background(), &surfstore.Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	list, err := blockStore.ListBlocks(context.Background(), &emptypb.Empty{})
+	if err != nil || len(list.Hashes) != 1 || list.Hashes[0] != hash {
+		t.Fatalf("ListBlocks returned %v, %v", list, err)
+	}
+
+	deleted, err := blockStore.DeleteBlocks(context.Background(), &surfstore.DeleteBlocksRequest{Hashes: []string{hash}, GracePeriodMs: time.Hour.Milliseconds()})
+	if err != nil || len(deleted.Hashes) != 0 {
+		t.Fatalf("Block within the grace period was deleted: %v, %v", deleted, err)
+	}
+
+	// A block found by HasBlocks is about to be referenced without being
+	// uploaded again, so it gets a new grace period
+	time.Sleep(100 * time.Millisecond)
+	if _, err := blockStore.HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: []string{hash}}); err != nil {
+		t.Fatalf("HasBlocks failed: %v", err)
+	}
+	deleted, err = blockStore.DeleteBlocks(context.Background(), &surfstore.DeleteBlocksRequest{Hashes: []string{hash}, GracePeriodMs: 50})
+	if err != nil || len(deleted.Hashes) != 0 {
+		t.Fatalf("Block found by HasBlocks was deleted: %v, %v", deleted, err)
+	}
+
+	deleted, err = blockStore.DeleteBlocks(context.Background(), &surfstore.DeleteBlocksRequest{Hashes: []string{hash}})
+	if err != nil || len(deleted.Hashes) != 1 {
+		t.Fatalf("DeleteBlocks returned %v, %v", deleted, err)
+	}
+	has, err := blockStore.HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: []string{hash}})
+	if err != nil || len(has.Hashes) != 0 {
+		t.Fatalf("Block still stored after DeleteBlocks: %v, %v", has, err)
+	}
+}
+
+// Blocks of a deleted file are collected once the grace period has passed,
+// blocks still used by another file are kept.
+func TestBlockGarbageCollection(t *testing.T) {
+	cfgPath := "./config_files/3nodes_gc.yaml"
+	test := InitTestWithBlockStores(cfgPath, []string{"8081"})
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	defer worker1.CleanUp()
+
+	blockSize := 4
+	file1 := "multi_file1.txt"
+	file2 := "multi_file2.txt"
+	if err := worker1.AddFile(file1); err != nil {
+		t.FailNow()
+	}
+	if err := worker1.AddFile(file2); err != nil {
+		t.FailNow()
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	// The config keeps one prior version, so only the first version of file1
+	// is collected once it is overwritten and deleted
+	if err := ioutil.WriteFile(worker1.DirectoryName+"/"+file1, []byte("overwritten file1"), 0644); err != nil {
+		t.FailNow()
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	kept := append(state.MetaMap.FileInfoMap[file2].BlockHashList, state.MetaMap.FileInfoMap[file1].BlockHashList...)
+
+	if err := worker1.DeleteFile(file1); err != nil {
+		t.FailNow()
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	time.Sleep(3 * time.Second)
+
+	conn, err := grpc.Dial("localhost:8081", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Could not connect to BlockStore: %v", err)
+	}
+	defer conn.Close()
+	list, err := surfstore.NewBlockStoreClient(conn).ListBlocks(context.Background(), &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("ListBlocks failed: %v", err)
+	}
+	if !SameHashList(sortedStrings(list.Hashes), sortedStrings(uniqueHashes(kept))) {
+		t.Fatalf("Expected only the %d blocks of %s and the kept version of %s to remain, found %d blocks", len(uniqueHashes(kept)), file2, file1, len(list.Hashes))
+	}
+}
+
+func TestGarbageCollectionSkipsMarkers(t *testing.T) {
+	t.Logf("the hashes of deleted files, directories and symlinks are not counted as live blocks")
+	metaStore := surfstore.NewMetaStore([]string{"localhost:8081"}, 0)
+	ctx := context.Background()
+	block := surfstore.GetBlockHashString([]byte("data"))
+	entries := []*surfstore.FileMetaData{
+		{Filename: "file.txt", Version: 1, BlockHashList: []string{block}},
+		{Filename: "dir", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}, FileType: surfstore.FileType_DIRECTORY},
+		{Filename: "link", Version: 1, BlockHashList: []string{surfstore.SYMLINK_HASH}, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: "file.txt"},
+		{Filename: "gone.txt", Version: 1, BlockHashList: []string{surfstore.TOMBSTONE_HASH}},
+	}
+	for _, entry := range entries {
+		if _, err := metaStore.UpdateFile(ctx, entry); err != nil {
+			t.Fatalf("UpdateFile failed: %v", err)
+		}
+	}
+	if hashes := metaStore.ReferencedBlockHashes(); !SameHashList(hashes, []string{block}) {
+		t.Fatalf("Expected only the block of file.txt to be live, got %v", hashes)
+	}
+}
+
+func sortedStrings(list []string) []string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func TestGetBlockStatus(t *testing.T) {
+	backend := surfstore.NewMemoryBackend()
+	blockStore := surfstore.NewBlockStoreWithBackend(backend)
+
+	missing := surfstore.GetBlockHashString([]byte("missing"))
+	if _, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: missing}); status.Code(err) != codes.NotFound {
+		t.Fatalf("GetBlock of a missing block returned %v", err)
+	}
+
+	data := []byte("about to rot")
+	hash := surfstore.GetBlockHashString(data)
+	backend.Put(hash, &surfstore.Block{BlockData: []byte("rotten"), BlockSize: 6})
+	if _, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: hash}); status.Code(err) != codes.DataLoss {
+		t.Fatalf("GetBlock of a corrupt block returned %v", err)
+	}
+
+	if _, err := blockStore.PutBlock(context.Background(), &surfstore.Block{BlockData: data, BlockSize: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("PutBlock with a wrong size returned %v", err)
+	}
+}
+
+// The scrubber repairs a corrupt block from a peer and removes one no peer has.
+func TestBlockScrubber(t *testing.T) {
+	repairable := []byte("a peer has a good copy")
+	lost := []byte("no other copy")
+	healthy := []byte("never rotted")
+
+	peer := surfstore.NewBlockStore()
+	peer.PutBlock(context.Background(), &surfstore.Block{BlockData: repairable, BlockSize: int32(len(repairable))})
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	surfstore.RegisterBlockStoreServer(grpcServer, peer)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	backend := surfstore.NewMemoryBackend()
+	blockStore := surfstore.NewBlockStoreWithBackend(backend)
+	rotten := &surfstore.Block{BlockData: []byte("rotten"), BlockSize: 6}
+	backend.Put(surfstore.GetBlockHashString(repairable), rotten)
+	backend.Put(surfstore.GetBlockHashString(lost), rotten)
+	backend.Put(surfstore.GetBlockHashString(healthy), &surfstore.Block{BlockData: healthy, BlockSize: int32(len(healthy))})
+
+	scrubber := surfstore.NewBlockScrubber(blockStore, []string{lis.Addr().String()}, &surfstore.ClusterConfig{})
+	report, err := scrubber.Scrub()
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	expected := surfstore.ScrubReport{Checked: 3, Corrupt: 2, Repaired: 1, Removed: 1}
+	if report != expected {
+		t.Fatalf("Expected scrub report %+v, got %+v", expected, report)
+	}
+
+	block, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: surfstore.GetBlockHashString(repairable)})
+	if err != nil || string(block.BlockData) != string(repairable) {
+		t.Fatalf("Block was not repaired: %v, %v", block, err)
+	}
+	if ok, _ := backend.Has(surfstore.GetBlockHashString(lost)); ok {
+		t.Fatalf("Unrepairable block is still stored")
+	}
+}
+
+// A second copy of a file is synced without uploading any of its blocks
+func TestSyncSkipsStoredBlocks(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8081")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	blockSize := 4
+	file1 := "multi_file1.txt"
+	if err := worker1.AddFile(file1); err != nil {
+		t.FailNow()
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	copyPath := worker2.DirectoryName + "/copy_of_" + file1
+	if err := CopyFile(SRC_PATH+"/"+file1, copyPath); err != nil {
+		t.FailNow()
+	}
+	info, err := os.Stat(copyPath)
+	if err != nil {
+		t.FailNow()
+	}
+
+	client := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, blockSize)
+	stats, err := surfstore.ClientSync(client)
+	if err != nil || stats.BlocksUploaded != 0 || stats.BytesSaved != info.Size() {
+		t.Fatalf("Expected all %d bytes to be skipped, got %+v", info.Size(), stats)
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+}
+
+// Many blocks move over a single PutBlocks and GetBlocks stream
+func TestStreamBlocks(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	surfstore.RegisterBlockStoreServer(grpcServer, surfstore.NewBlockStore())
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	addr := lis.Addr().String()
+	client := surfstore.NewSurfstoreRPCClient(nil, "", 0)
+
+	hashes := make([]string, 0)
+	toSend := make(chan *surfstore.Block)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			data := []byte("block number " + strconv.Itoa(i))
+			hashes = append(hashes, surfstore.GetBlockHashString(data))
+			toSend <- &surfstore.Block{BlockData: data, BlockSize: int32(len(data))}
+		}
+		close(toSend)
+	}()
+	var stored []string
+	if err := client.PutBlocks(addr, toSend, &stored); err != nil {
+		t.Fatalf("PutBlocks failed: %v", err)
+	}
+	if !SameHashList(stored, hashes) {
+		t.Fatalf("PutBlocks stored %d of %d blocks", len(stored), len(hashes))
+	}
+
+	received := make(chan *surfstore.Block)
+	go func() {
+		if err := client.GetBlocks(hashes, addr, received); err != nil {
+			t.Errorf("GetBlocks failed: %v", err)
+		}
+	}()
+	i := 0
+	for block := range received {
+		if surfstore.GetBlockHashString(block.BlockData) != hashes[i] {
+			t.Fatalf("Block %d received out of order", i)
+		}
+		i++
+	}
+	if i != len(hashes) {
+		t.Fatalf("GetBlocks returned %d of %d blocks", i, len(hashes))
+	}
+
+	missing := surfstore.GetBlockHashString([]byte("missing"))
+	received = make(chan *surfstore.Block)
+	errChan := make(chan error)
+	go func() { errChan <- client.GetBlocks([]string{hashes[0], missing}, addr, received) }()
+	count := 0
+	for range received {
+		count++
+	}
+	if err := <-errChan; status.Code(err) != codes.NotFound || count != 1 {
+		t.Fatalf("GetBlocks with a missing block returned %d blocks and %v", count, err)
+	}
+}
+
+// streamCounter records the most block streams a set of BlockStores served
+// at the same time
+type streamCounter struct {
+	mtx     sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (c *streamCounter) intercept(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	c.mtx.Lock()
+	c.open++
+	if c.open > c.maxOpen {
+		c.maxOpen = c.open
+	}
+	c.mtx.Unlock()
+	defer func() {
+		c.mtx.Lock()
+		c.open--
+		c.mtx.Unlock()
+	}()
+	return handler(srv, stream)
+}
+
+// Files with repeated blocks spread over three BlockStores are transferred in
+// parallel and written back in order, with no more streams open than the
+// client's concurrency
+func TestParallelSync(t *testing.T) {
+	counter := &streamCounter{}
+	for _, port := range []string{"8081", "8082", "8083"} {
+		lis, err := net.Listen("tcp", "localhost:"+port)
+		if err != nil {
+			t.Fatalf("Could not listen: %v", err)
+		}
+		grpcServer := grpc.NewServer(grpc.StreamInterceptor(counter.intercept))
+		surfstore.RegisterBlockStoreServer(grpcServer, surfstore.NewBlockStore())
+		go grpcServer.Serve(lis)
+		defer grpcServer.Stop()
+	}
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTestWithBlockStores(cfgPath, nil, "-b", "localhost:8081,localhost:8082,localhost:8083")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	blockSize := 64
+	for i := 0; i < 6; i++ {
+		content := make([]byte, 0)
+		for j := 0; j < 200; j++ {
+			n := i*1000 + j
+			if j%3 == 2 {
+				// Repeat an earlier block
+				n = i*1000 + j/3
+			}
+			content = append(content, []byte(fmt.Sprintf("%-64d", n))...)
+		}
+		if err := ioutil.WriteFile(worker1.DirectoryName+"/file"+strconv.Itoa(i)+".txt", content, 0644); err != nil {
+			t.FailNow()
+		}
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, blockSize)
+	client1.Concurrency = 3
+	surfstore.ClientSync(client1)
+
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, blockSize)
+	client2.Concurrency = 3
+	surfstore.ClientSync(client2)
+
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+	if counter.maxOpen > 3 {
+		t.Fatalf("Expected at most 3 block streams at the same time, got %d", counter.maxOpen)
+	}
+	for name := range worker2.ListAllFile() {
+		if strings.HasPrefix(name, surfstore.DOWNLOAD_TMP_PREFIX) {
+			t.Fatalf("Temporary download file %s left behind", name)
+		}
+	}
+}
+
+func TestCDCChunker(t *testing.T) {
+	data := make([]byte, 256*1024)
+	state := uint32(1)
+	for i := range data {
+		state = state*1664525 + 1013904223
+		data[i] = byte(state >> 24)
+	}
+
+	chunk := func(data []byte) []string {
+		chunker := surfstore.NewCDCChunker(bytes.NewReader(data), 256, 1024, 8192)
+		hashes := make([]string, 0)
+		total := 0
+		for {
+			block, err := chunker.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Chunking failed: %v", err)
+			}
+			if len(block) > 8192 || (len(block) < 256 && total+len(block) != len(data)) {
+				t.Fatalf("Block of %d bytes outside of the size bounds", len(block))
+			}
+			if !bytes.Equal(block, data[total:total+len(block)]) {
+				t.Fatalf("Block at offset %d does not match the input", total)
+			}
+			total += len(block)
+			hashes = append(hashes, surfstore.GetBlockHashString(block))
+		}
+		if total != len(data) {
+			t.Fatalf("Chunked %d of %d bytes", total, len(data))
+		}
+		return hashes
+	}
+
+	original := chunk(data)
+	if len(original) < 128 || len(original) > 512 {
+		t.Fatalf("Expected about 256 blocks, got %d", len(original))
+	}
+
+	// Insert a byte in the middle of the data
+	edited := append(append(append([]byte{}, data[:len(data)/2]...), 'x'), data[len(data)/2:]...)
+	known := make(map[string]bool)
+	for _, hash := range original {
+		known[hash] = true
+	}
+	changed := 0
+	for _, hash := range chunk(edited) {
+		if !known[hash] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 3 {
+		t.Fatalf("Expected the insertion to change 1 to 3 blocks, %d changed", changed)
+	}
+}
+
+func TestChunkSizeLimits(t *testing.T) {
+	if err := surfstore.ValidateChunkSizes(1024, 4096, surfstore.MAX_BLOCK_SIZE); err != nil {
+		t.Fatalf("Expected a maximum of MAX_BLOCK_SIZE to be valid: %v", err)
+	}
+	if err := surfstore.ValidateChunkSizes(1024, 4096, surfstore.MAX_BLOCK_SIZE+1); err == nil {
+		t.Fatalf("Expected a maximum above MAX_BLOCK_SIZE to be rejected")
+	}
+
+	client := surfstore.NewSurfstoreRPCClient(nil, "", surfstore.MAX_BLOCK_SIZE/2)
+	if _, _, maxSize := client.ChunkSizes(); maxSize != surfstore.MAX_BLOCK_SIZE {
+		t.Fatalf("Expected the default maximum to be capped at %d, got %d", surfstore.MAX_BLOCK_SIZE, maxSize)
+	}
+}
+
+func TestSyncContentDefinedChunks(t *testing.T) {
+	t.Logf("client edits the middle of a file synced with content-defined chunking")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	content := make([]byte, 0)
+	for i := 0; i < 4000; i++ {
+		content = append(content, []byte(fmt.Sprintf("line %d\n", i*7919))...)
+	}
+	path := worker1.DirectoryName + "/big.txt"
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.FailNow()
+	}
+
+	blockSize := 512
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, blockSize)
+	client1.Chunking = surfstore.CHUNKING_CDC
+	first, err := surfstore.ClientSync(client1)
+	if err != nil || first.BytesUploaded != int64(len(content)) {
+		t.Fatalf("Expected %d bytes uploaded, got %d", len(content), first.BytesUploaded)
+	}
+
+	edited := append(append(append([]byte{}, content[:len(content)/2]...), []byte("inserted\n")...), content[len(content)/2:]...)
+	if err := ioutil.WriteFile(path, edited, 0644); err != nil {
+		t.FailNow()
+	}
+	second, err := surfstore.ClientSync(client1)
+	if err != nil || second.BytesUploaded == 0 || second.BytesUploaded > int64(4*8*blockSize) {
+		t.Fatalf("Expected only the blocks around the edit to be uploaded, got %d bytes", second.BytesUploaded)
+	}
+
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, blockSize)
+	surfstore.ClientSync(client2)
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+}
+
+func TestSyncMixedChunking(t *testing.T) {
+	t.Logf("clients that cut files differently do not upload each other's files as changes")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	content := make([]byte, 0)
+	for i := 0; i < 2000; i++ {
+		content = append(content, []byte(fmt.Sprintf("line %d\n", i*7919))...)
+	}
+	if err := ioutil.WriteFile(worker1.DirectoryName+"/big.txt", content, 0644); err != nil {
+		t.FailNow()
+	}
+
+	blockSize := 512
+	cdc := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, blockSize)
+	cdc.Chunking = surfstore.CHUNKING_CDC
+	fixed := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, blockSize)
+	// Hash every file on each sync instead of trusting the index
+	cdc.Verify, fixed.Verify = true, true
+	version := func() int32 {
+		t.Helper()
+		var fileInfoMap map[string]*surfstore.FileMetaData
+		if err := fixed.GetFileInfoMap(&fileInfoMap, nil, nil); err != nil {
+			t.Fatalf("GetFileInfoMap failed: %v", err)
+		}
+		return fileInfoMap["big.txt"].GetVersion()
+	}
+
+	surfstore.ClientSync(cdc)
+	surfstore.ClientSync(fixed)
+	for i := 0; i < 2; i++ {
+		for _, client := range []surfstore.RPCClient{fixed, cdc} {
+			if stats, err := surfstore.ClientSync(client); err != nil || stats.BytesUploaded != 0 {
+				t.Fatalf("Expected nothing to upload, %s uploaded %d bytes", client.Chunking, stats.BytesUploaded)
+			}
+		}
+	}
+	if v := version(); v != 1 {
+		t.Fatalf("Expected big.txt to stay at version 1, got %d", v)
+	}
+
+	// An edit is still a change, and is uploaded cut the editor's way
+	if err := AppendFile(worker2.DirectoryName+"/big.txt", "appended"); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(fixed)
+	surfstore.ClientSync(cdc)
+	surfstore.ClientSync(fixed)
+	if v := version(); v != 2 {
+		t.Fatalf("Expected the edit to make version 2, got %d", v)
+	}
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+}
+
+func TestBlockCompression(t *testing.T) {
+	blockStore := surfstore.NewBlockStore()
+	data := []byte(strings.Repeat("compressible text ", 100))
+	hash := surfstore.GetBlockHashString(data)
+
+	has, err := blockStore.HasBlocks(context.Background(), &surfstore.BlockHashes{})
+	if err != nil || len(has.Codecs) == 0 {
+		t.Fatalf("HasBlocks did not list the accepted codecs: %v, %v", has, err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	compressed := &surfstore.Block{BlockData: buf.Bytes(), BlockSize: int32(len(data)), Codec: surfstore.CODEC_GZIP}
+	if _, err := blockStore.PutBlock(context.Background(), compressed); err != nil {
+		t.Fatalf("PutBlock of a compressed block failed: %v", err)
+	}
+	has, err = blockStore.HasBlocks(context.Background(), &surfstore.BlockHashes{Hashes: []string{hash}})
+	if err != nil || len(has.Hashes) != 1 {
+		t.Fatalf("Compressed block not stored under the hash of its data: %v, %v", has, err)
+	}
+
+	// Callers that do not accept the codec get the data decompressed
+	block, err := blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: hash})
+	if err != nil || !bytes.Equal(block.BlockData, data) || block.Codec != surfstore.CODEC_NONE {
+		t.Fatalf("GetBlock did not decompress the block: %v", err)
+	}
+	block, err = blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: hash, Codecs: []string{surfstore.CODEC_GZIP}})
+	if err != nil || !bytes.Equal(block.BlockData, buf.Bytes()) || block.Codec != surfstore.CODEC_GZIP || int(block.BlockSize) != len(data) {
+		t.Fatalf("GetBlock did not return the compressed block: %v", err)
+	}
+
+	zstdData := []byte(strings.Repeat("zstd text ", 100))
+	encoder, _ := zstd.NewWriter(nil)
+	zstdBlock := &surfstore.Block{BlockData: encoder.EncodeAll(zstdData, nil), BlockSize: int32(len(zstdData)), Codec: surfstore.CODEC_ZSTD}
+	if _, err := blockStore.PutBlock(context.Background(), zstdBlock); err != nil {
+		t.Fatalf("PutBlock of a zstd block failed: %v", err)
+	}
+	block, err = blockStore.GetBlock(context.Background(), &surfstore.BlockHash{Hash: surfstore.GetBlockHashString(zstdData)})
+	if err != nil || !bytes.Equal(block.BlockData, zstdData) {
+		t.Fatalf("GetBlock did not decompress the zstd block: %v", err)
+	}
+
+	wrongSize := &surfstore.Block{BlockData: buf.Bytes(), BlockSize: 10, Codec: surfstore.CODEC_GZIP}
+	if _, err := blockStore.PutBlock(context.Background(), wrongSize); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("PutBlock of a block larger than its size returned %v", err)
+	}
+	unknown := &surfstore.Block{BlockData: data, BlockSize: int32(len(data)), Codec: "lzma"}
+	if _, err := blockStore.PutBlock(context.Background(), unknown); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("PutBlock with an unknown codec returned %v", err)
+	}
+
+	// A small block that decompresses past the limit is rejected, whatever
+	// size it claims
+	var bomb bytes.Buffer
+	w = gzip.NewWriter(&bomb)
+	w.Write(make([]byte, surfstore.MAX_BLOCK_SIZE+1))
+	w.Close()
+	for _, size := range []int32{int32(surfstore.MAX_BLOCK_SIZE + 1), 1 << 30} {
+		tooLarge := &surfstore.Block{BlockData: bomb.Bytes(), BlockSize: size, Codec: surfstore.CODEC_GZIP}
+		if _, err := blockStore.PutBlock(context.Background(), tooLarge); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("PutBlock of a block claiming %d bytes returned %v", size, err)
+		}
+	}
+}
+
+func TestSyncCompressedBlocks(t *testing.T) {
+	t.Logf("client uploads compressed blocks, and skips compressing random data")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	blockSize := 1024
+	for _, codec := range []string{surfstore.CODEC_GZIP, surfstore.CODEC_SNAPPY, surfstore.CODEC_ZSTD} {
+		text := []byte(strings.Repeat("text compresses well with "+codec+"\n", 500))
+		if err := ioutil.WriteFile(worker1.DirectoryName+"/"+codec+".txt", text, 0644); err != nil {
+			t.FailNow()
+		}
+		client := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, blockSize)
+		client.Compression = codec
+		stats, err := surfstore.ClientSync(client)
+		if err != nil || stats.BytesUploaded != int64(len(text)) || stats.BytesSent*2 > stats.BytesUploaded {
+			t.Fatalf("Expected %s to compress %d bytes to less than half, sent %d of %d", codec, len(text), stats.BytesSent, stats.BytesUploaded)
+		}
+	}
+
+	random := make([]byte, 8*blockSize)
+	state := uint32(7)
+	for i := range random {
+		state = state*1664525 + 1013904223
+		random[i] = byte(state >> 24)
+	}
+	if err := ioutil.WriteFile(worker1.DirectoryName+"/random.bin", random, 0644); err != nil {
+		t.FailNow()
+	}
+	client := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, blockSize)
+	client.Compression = surfstore.CODEC_GZIP
+	stats, err := surfstore.ClientSync(client)
+	if err != nil || stats.BytesSent != stats.BytesUploaded {
+		t.Fatalf("Random data was sent compressed: %d of %d bytes", stats.BytesSent, stats.BytesUploaded)
+	}
+
+	// A client without compression downloads the same content
+	if err := SyncClient("localhost:8080", "test1", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+}
+
+func TestSyncEncryptedBlocks(t *testing.T) {
+	t.Logf("clients sharing a passphrase sync encrypted blocks and file names")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	worker3 := InitDirectoryWorker("test2", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	defer worker3.CleanUp()
+
+	secret := []byte(strings.Repeat("attack at dawn ", 20))
+	for _, name := range []string{"plans.txt", "copy_of_plans.txt"} {
+		if err := ioutil.WriteFile(worker1.DirectoryName+"/"+name, secret, 0644); err != nil {
+			t.FailNow()
+		}
+	}
+
+	blockSize := 64
+	newClient := func(dir string, passphrase string) surfstore.RPCClient {
+		client := surfstore.NewSurfstoreRPCClient(test.Ips, dir, blockSize)
+		cipher, err := surfstore.NewBlockCipher(passphrase, surfstore.ENCRYPTION_CONVERGENT, true)
+		if err != nil {
+			t.Fatalf("Could not create cipher: %v", err)
+		}
+		client.Encryption = cipher
+		return client
+	}
+	client1 := newClient(worker1.DirectoryName, "correct horse")
+	surfstore.ClientSync(client1)
+
+	// The servers only see encrypted names and blocks
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	if len(state.MetaMap.FileInfoMap) != 2 {
+		t.Fatalf("Expected 2 files on the server, got %d", len(state.MetaMap.FileInfoMap))
+	}
+	hashLists := make([][]string, 0)
+	for _, metaData := range state.MetaMap.FileInfoMap {
+		hashLists = append(hashLists, metaData.BlockHashList)
+	}
+	if strings.Join(hashLists[0], HASH_DELIMITER) != strings.Join(hashLists[1], HASH_DELIMITER) {
+		t.Fatalf("Convergent encryption should encrypt equal files to the same blocks")
+	}
+	plainHashes := make(map[string]bool)
+	for i := 0; i < len(secret); i += blockSize {
+		end := i + blockSize
+		if end > len(secret) {
+			end = len(secret)
+		}
+		plainHashes[surfstore.GetBlockHashString(secret[i:end])] = true
+	}
+	for name, metaData := range state.MetaMap.FileInfoMap {
+		if strings.Contains(name, "plans") {
+			t.Fatalf("File name %s stored in plaintext", name)
+		}
+		var blockStoreMap map[string][]string
+		if err := client1.GetBlockStoreMap(metaData.BlockHashList, &blockStoreMap); err != nil {
+			t.Fatalf("Could not get block store map: %v", err)
+		}
+		for blockStoreAddr, hashes := range blockStoreMap {
+			for _, hash := range hashes {
+				if plainHashes[hash] {
+					t.Fatalf("Block addressed by the hash of its plaintext")
+				}
+				var block surfstore.Block
+				if err := client1.GetBlock(hash, blockStoreAddr, &block); err != nil {
+					t.Fatalf("GetBlock failed: %v", err)
+				}
+				if bytes.Contains(block.BlockData, []byte("attack")) {
+					t.Fatalf("Block stored in plaintext")
+				}
+			}
+		}
+	}
+
+	client2 := newClient(worker2.DirectoryName, "correct horse")
+	surfstore.ClientSync(client2)
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+
+	// Another passphrase cannot read the files, or even see their names
+	client3 := newClient(worker3.DirectoryName, "wrong passphrase")
+	surfstore.ClientSync(client3)
+	if files := worker3.ListAllFile(); len(files) != 1 {
+		t.Fatalf("Client with a wrong passphrase got files %v", files)
+	}
+}
+
+func TestSyncRandomNonceEncryption(t *testing.T) {
+	t.Logf("random nonces hide equal files, unchanged files are not uploaded again")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	content := []byte(strings.Repeat("same content in two files\n", 20))
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := ioutil.WriteFile(worker1.DirectoryName+"/"+name, content, 0644); err != nil {
+			t.FailNow()
+		}
+	}
+
+	blockSize := 64
+	newClient := func(dir string) surfstore.RPCClient {
+		client := surfstore.NewSurfstoreRPCClient(test.Ips, dir, blockSize)
+		cipher, err := surfstore.NewBlockCipher("correct horse", surfstore.ENCRYPTION_RANDOM, false)
+		if err != nil {
+			t.Fatalf("Could not create cipher: %v", err)
+		}
+		client.Encryption = cipher
+		return client
+	}
+	surfstore.ClientSync(newClient(worker1.DirectoryName))
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	hashesA := state.MetaMap.FileInfoMap["a.txt"].BlockHashList
+	hashesB := state.MetaMap.FileInfoMap["b.txt"].BlockHashList
+	for _, hash := range hashesA {
+		for _, other := range hashesB {
+			if hash == other {
+				t.Fatalf("Equal files share encrypted block %s", hash)
+			}
+		}
+	}
+
+	// A new client process encrypts the unchanged files to the same blocks
+	if stats, err := surfstore.ClientSync(newClient(worker1.DirectoryName)); err != nil || stats.BlocksUploaded != 0 {
+		t.Fatalf("Unchanged files uploaded %d blocks", stats.BlocksUploaded)
+	}
+	surfstore.ClientSync(newClient(worker2.DirectoryName))
+	if stats, err := surfstore.ClientSync(newClient(worker2.DirectoryName)); err != nil || stats.BlocksUploaded != 0 {
+		t.Fatalf("Downloaded files uploaded %d blocks", stats.BlocksUploaded)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if same, err := SameFile(worker1.DirectoryName+"/"+name, worker2.DirectoryName+"/"+name); !same || err != nil {
+			t.Fatalf("File %s is not synced", name)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package SurfTest
+
+import (
+	"context"
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMetaStoreGetChangesSince(t *testing.T) {
+	metaStore := surfstore.NewMetaStore([]string{"localhost:8081"}, 0)
+	metaStore.ChangeLogLength = 3
+	ctx := context.Background()
+	update := func(fileName string, version int32) {
+		metaStore.UpdateFile(ctx, &surfstore.FileMetaData{Filename: fileName, Version: version, BlockHashList: []string{"h"}})
+	}
+
+	update("a.txt", 1)
+	fileInfoMap, _ := metaStore.GetFileInfoMap(ctx, &emptypb.Empty{})
+	cursor := fileInfoMap.Cursor
+	if cursor.Index != 1 || len(fileInfoMap.FileInfoMap) != 1 {
+		t.Fatalf("Expected one file at index 1, got %v", fileInfoMap)
+	}
+
+	update("b.txt", 1)
+	update("a.txt", 2)
+	update("a.txt", 3)
+	changes, err := metaStore.GetChangesSince(ctx, cursor)
+	if err != nil {
+		t.Fatalf("GetChangesSince failed: %v", err)
+	}
+	if changes.Cursor.Index != 4 || changes.Cursor.Epoch != cursor.Epoch || len(changes.FileMetaData) != 2 {
+		t.Fatalf("Expected the latest version of 2 files up to index 4, got %v", changes)
+	}
+	for _, fileMetaData := range changes.FileMetaData {
+		if fileMetaData.Filename == "a.txt" && fileMetaData.Version != 3 {
+			t.Fatalf("Expected version 3 of a.txt, got %d", fileMetaData.Version)
+		}
+	}
+	if changes, err := metaStore.GetChangesSince(ctx, changes.Cursor); err != nil || len(changes.FileMetaData) != 0 {
+		t.Fatalf("Expected no changes after the latest cursor, got %v %v", changes, err)
+	}
+
+	update("c.txt", 1)
+	expired := []*surfstore.Cursor{
+		// Change 2 was dropped from the change log
+		{Epoch: cursor.Epoch, Index: 1},
+		{Epoch: cursor.Epoch + 1, Index: 4},
+		{Epoch: cursor.Epoch, Index: 9},
+	}
+	for _, c := range expired {
+		if _, err := metaStore.GetChangesSince(ctx, c); err != surfstore.ERR_CHANGES_EXPIRED {
+			t.Fatalf("Expected cursor %v to be expired, got %v", c, err)
+		}
+	}
+}
+
+// readCursor returns the cursor in a client's index, split into its epoch and
+// index
+func readCursor(t *testing.T, dir string) (int64, int64) {
+	index, err := surfstore.LoadLocalIndex(dir)
+	if err != nil {
+		t.Fatalf("Could not read index: %v", err)
+	}
+	if index.Cursor == nil {
+		t.Fatalf("Index of %s has no cursor", dir)
+	}
+	return index.Cursor.Epoch, index.Cursor.Index
+}
+
+// rewriteIndex applies edit to a client's index
+func rewriteIndex(t *testing.T, dir string, edit func(index *surfstore.LocalIndex)) {
+	index, err := surfstore.LoadLocalIndex(dir)
+	if err != nil {
+		t.Fatalf("Could not read index: %v", err)
+	}
+	edit(index)
+	if err := index.Write(dir); err != nil {
+		t.Fatalf("Could not write index: %v", err)
+	}
+}
+
+func TestSyncFetchesChangesSinceCursor(t *testing.T) {
+	t.Logf("a client with a cursor only fetches changed files, and fetches everything when the cursor expired")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, name), []byte(name), 0644); err != nil {
+			t.FailNow()
+		}
+	}
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	epoch, index := readCursor(t, worker2.DirectoryName)
+	if index != 2 {
+		t.Fatalf("Expected the cursor at change 2, got %d", index)
+	}
+
+	// Forget b.txt at client2. Only a.txt changes, so an incremental sync
+	// does not bring it back.
+	if err := os.Remove(filepath.Join(worker2.DirectoryName, "b.txt")); err != nil {
+		t.FailNow()
+	}
+	rewriteIndex(t, worker2.DirectoryName, func(index *surfstore.LocalIndex) {
+		delete(index.Files, "b.txt")
+	})
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "a.txt"), []byte("changed"), 0644); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	if data, err := ioutil.ReadFile(filepath.Join(worker2.DirectoryName, "a.txt")); err != nil || string(data) != "changed" {
+		t.Fatalf("Expected the change to a.txt at client2, got %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected b.txt not to be fetched again, got %v", err)
+	}
+	if e, i := readCursor(t, worker2.DirectoryName); e != epoch || i != 3 {
+		t.Fatalf("Expected the cursor at change 3, got %d", i)
+	}
+
+	// A cursor from another epoch fetches the whole index
+	rewriteIndex(t, worker2.DirectoryName, func(index *surfstore.LocalIndex) {
+		index.Cursor = &surfstore.Cursor{Epoch: 1, Index: 3}
+	})
+	surfstore.ClientSync(client2)
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced after a full fetch")
+	}
+	if e, i := readCursor(t, worker2.DirectoryName); e != epoch || i != 3 {
+		t.Fatalf("Expected the server's cursor after a full fetch, got %d %d", e, i)
+	}
+}
+
+func TestCursorSurvivesLeaderChange(t *testing.T) {
+	t.Logf("a cursor from one leader continues at the next, since the replicas share the epoch of their log")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	test.Clients[0].UpdateFile(test.Context, &surfstore.FileMetaData{Filename: "a.txt", Version: 1, BlockHashList: []string{"h"}})
+	// Lets the followers apply the update
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileInfoMap, err := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if err != nil || fileInfoMap.Cursor.Index != 1 {
+		t.Fatalf("Expected a cursor at change 1, got %v %v", fileInfoMap, err)
+	}
+	cursor := fileInfoMap.Cursor
+
+	test.Clients[1].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[1].SendHeartbeat(test.Context, &emptypb.Empty{})
+	test.Clients[1].UpdateFile(test.Context, &surfstore.FileMetaData{Filename: "b.txt", Version: 1, BlockHashList: []string{"h"}})
+
+	changes, err := test.Clients[1].GetChangesSince(test.Context, cursor)
+	if err != nil || len(changes.FileMetaData) != 1 || changes.FileMetaData[0].Filename != "b.txt" {
+		t.Fatalf("Expected only b.txt after the cursor, got %v %v", changes, err)
+	}
+	if changes.Cursor.Epoch != cursor.Epoch || changes.Cursor.Index != 2 {
+		t.Fatalf("Expected the cursor at change 2 of the same epoch, got %v", changes.Cursor)
+	}
+
+	ctx, cancel := context.WithCancel(test.Context)
+	defer cancel()
+	stream, err := test.Clients[1].Watch(ctx, &surfstore.WatchRequest{FromIndex: cursor.Index + 1, Epoch: cursor.Epoch})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	change, err := stream.Recv()
+	if err != nil || change.Index != 2 || change.FileMetaData.Filename != "b.txt" {
+		t.Fatalf("Expected change 2 of b.txt, got %v %v", change, err)
+	}
+}
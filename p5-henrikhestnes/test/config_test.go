@@ -0,0 +1,122 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"testing"
+	"time"
+)
+
+func TestLoadLegacyConfig(t *testing.T) {
+	config, err := surfstore.LoadClusterConfig("./config_files/3nodes.txt")
+	if err != nil {
+		t.Fatalf("Failed to load legacy config: %v", err)
+	}
+
+	expected := []string{"localhost:9007", "localhost:9008", "localhost:9009"}
+	if !SameHashList(config.RaftAddrs(), expected) {
+		t.Fatalf("Raft addresses should be %v, got %v", expected, config.RaftAddrs())
+	}
+	if !SameHashList(config.ClientAddrs(), expected) {
+		t.Fatalf("Client addresses should default to %v, got %v", expected, config.ClientAddrs())
+	}
+	if config.RPCTimeout() != surfstore.DEFAULT_RPC_TIMEOUT {
+		t.Fatalf("RPC timeout should default to %v", surfstore.DEFAULT_RPC_TIMEOUT)
+	}
+}
+
+func TestLoadStructuredConfig(t *testing.T) {
+	yamlConfig := `
+servers:
+  - id: 1
+    raftAddr: localhost:9008
+    clientAddr: localhost:9108
+  - id: 0
+    raftAddr: localhost:9007
+blockStores:
+  - addr: localhost:8081
+    dataDir: /tmp/block0
+timeouts:
+  rpc: 250ms
+tls:
+  caFile: ca.pem
+  certFile: cert.pem
+  keyFile: key.pem
+`
+	jsonConfig := `{
+	"servers": [
+		{"id": 1, "raftAddr": "localhost:9008", "clientAddr": "localhost:9108"},
+		{"id": 0, "raftAddr": "localhost:9007"}
+	],
+	"blockStores": [{"addr": "localhost:8081", "dataDir": "/tmp/block0"}],
+	"timeouts": {"rpc": "250ms"},
+	"tls": {"caFile": "ca.pem", "certFile": "cert.pem", "keyFile": "key.pem"}
+}`
+
+	for ext, content := range map[string]string{".yaml": yamlConfig, ".json": jsonConfig, "": jsonConfig} {
+		config, err := surfstore.ParseClusterConfig([]byte(content), ext)
+		if err != nil {
+			t.Fatalf("Failed to parse %q config: %v", ext, err)
+		}
+		if !SameHashList(config.RaftAddrs(), []string{"localhost:9007", "localhost:9008"}) {
+			t.Fatalf("Servers should be ordered by id, got %v", config.RaftAddrs())
+		}
+		if !SameHashList(config.ClientAddrs(), []string{"localhost:9007", "localhost:9108"}) {
+			t.Fatalf("Unexpected client addresses %v", config.ClientAddrs())
+		}
+		if config.BlockStores[0].DataDir != "/tmp/block0" {
+			t.Fatalf("Unexpected blockStore data dir %q", config.BlockStores[0].DataDir)
+		}
+		if config.RPCTimeout() != 250*time.Millisecond {
+			t.Fatalf("Unexpected rpc timeout %v", config.RPCTimeout())
+		}
+		if config.TLS.CertFile != "cert.pem" {
+			t.Fatalf("Unexpected tls cert file %q", config.TLS.CertFile)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	invalid := map[string]string{
+		"no servers":       "servers: []",
+		"duplicate id":     "servers: [{id: 0, raftAddr: a:1}, {id: 0, raftAddr: a:2}]",
+		"id out of range":  "servers: [{id: 3, raftAddr: a:1}]",
+		"missing address":  "servers: [{id: 0}]",
+		"duplicate addr":   "servers: [{id: 0, raftAddr: a:1}, {id: 1, raftAddr: a:1}]",
+		"unknown field":    "servers: [{id: 0, raftAddr: a:1, port: 3}]",
+		"bad duration":     "servers: [{id: 0, raftAddr: a:1}]\ntimeouts: {rpc: soon}",
+		"cert without key": "servers: [{id: 0, raftAddr: a:1}]\ntls: {certFile: cert.pem}",
+		"auth no service":  "servers: [{id: 0, raftAddr: a:1}]\nauth: {users: [{name: a, token: t}]}",
+		"user no token":    "servers: [{id: 0, raftAddr: a:1}]\nauth: {serviceToken: s, users: [{name: a}]}",
+		"shared token":     "servers: [{id: 0, raftAddr: a:1}]\nauth: {serviceToken: s, users: [{name: a, token: s}]}",
+		"duplicate user":   "servers: [{id: 0, raftAddr: a:1}]\nauth: {serviceToken: s, users: [{name: a, token: t}, {name: a, token: u}]}",
+		"bad namespace":    "servers: [{id: 0, raftAddr: a:1}]\nauth: {serviceToken: s, users: [{name: a, token: t, namespaces: [../x]}]}",
+		"mutual no ca":     "servers: [{id: 0, raftAddr: a:1}]\ntls: {mutual: true}",
+		"peer cert no key": "servers: [{id: 0, raftAddr: a:1}]\ntls: {peerCertFile: peer.pem}",
+		"history length":   "servers: [{id: 0, raftAddr: a:1}]\nhistoryLength: -2",
+		"legacy count":     "M: 3\nmetadata0: localhost:9007",
+		"legacy line":      "M: 1\nlocalhost:9007",
+	}
+
+	for name, content := range invalid {
+		if _, err := surfstore.ParseClusterConfig([]byte(content), ""); err == nil {
+			t.Errorf("Config with %s should be rejected", name)
+		}
+	}
+}
+
+func TestHistoryLengthConfig(t *testing.T) {
+	expected := map[string]int{
+		"":                  surfstore.DEFAULT_HISTORY_LENGTH,
+		"historyLength: 3":  3,
+		"historyLength: -1": 0,
+	}
+	for line, versions := range expected {
+		config, err := surfstore.ParseClusterConfig([]byte("servers: [{id: 0, raftAddr: a:1}]\n"+line), ".yaml")
+		if err != nil {
+			t.Fatalf("Failed to parse config with %q: %v", line, err)
+		}
+		if config.HistoryVersions() != versions {
+			t.Fatalf("Expected %d versions with %q, got %d", versions, line, config.HistoryVersions())
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestValidateFileName(t *testing.T) {
+	valid := []string{"a.txt", "dir/a.txt", "a/b/c", ".hidden", "sub/index.txt", "a..b", "a,b", "a b, c.txt"}
+	for _, name := range valid {
+		if err := surfstore.ValidateFileName(name); err != nil {
+			t.Fatalf("Expected %q to be valid: %v", name, err)
+		}
+	}
+	invalid := []string{"", ".", "..", "../a", "a/../../b", "/etc/passwd", "a//b", "a/", "./a", "a/./b",
+		"a\nb", "a\\b", "index.txt", ".surfstore-nonces", ".surfstore-download-1", ".tmp-index.txt123"}
+	for _, name := range invalid {
+		if err := surfstore.ValidateFileName(name); err == nil {
+			t.Fatalf("Expected %q to be invalid", name)
+		}
+	}
+}
+
+// treeContents maps every path below dir to the content of the file, or to
+// "/" for a directory
+func treeContents(t *testing.T, dir string) map[string]string {
+	contents := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			contents[filepath.ToSlash(rel)] = "/"
+			return nil
+		}
+		if rel == META_FILENAME {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		contents[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", dir, err)
+	}
+	return contents
+}
+
+func TestSyncDirectories(t *testing.T) {
+	t.Logf("clients sync nested files, empty directories and their removal")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	for _, dir := range []string{"docs/notes", "empty", "old/sub"} {
+		if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, dir), 0755); err != nil {
+			t.FailNow()
+		}
+	}
+	files := map[string]string{
+		"top.txt":             "top",
+		"docs/readme.txt":     "readme",
+		"docs/notes/todo.txt": "todo",
+		"old/sub/gone.txt":    "gone",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, name), []byte(content), 0644); err != nil {
+			t.FailNow()
+		}
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	if want, got := treeContents(t, worker1.DirectoryName), treeContents(t, worker2.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client2, got %v", want, got)
+	}
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	if hashes := state.MetaMap.FileInfoMap["empty"].GetBlockHashList(); len(hashes) != 1 || hashes[0] != surfstore.DIRECTORY_HASH {
+		t.Fatalf("Expected a directory entry for the empty directory, got %v", hashes)
+	}
+
+	// Removing a directory tree and replacing a file by a directory
+	if err := os.RemoveAll(filepath.Join(worker2.DirectoryName, "old")); err != nil {
+		t.FailNow()
+	}
+	if err := os.Remove(filepath.Join(worker2.DirectoryName, "top.txt")); err != nil {
+		t.FailNow()
+	}
+	if err := os.MkdirAll(filepath.Join(worker2.DirectoryName, "top.txt", "inner"), 0755); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(client2)
+	surfstore.ClientSync(client1)
+	if _, err := os.Stat(filepath.Join(worker1.DirectoryName, "old")); !os.IsNotExist(err) {
+		t.Fatalf("Removed directory still exists at client1: %v", err)
+	}
+	if want, got := treeContents(t, worker2.DirectoryName), treeContents(t, worker1.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client1, got %v", want, got)
+	}
+}
+
+func TestSyncRejectsEscapingNames(t *testing.T) {
+	t.Logf("client ignores server entries that would write outside its base directory")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	outside := InitDirectoryWorker("test_outside", SRC_PATH)
+	defer worker1.CleanUp()
+	defer outside.CleanUp()
+
+	victim := filepath.Join(outside.DirectoryName, "victim.txt")
+	if err := ioutil.WriteFile(victim, []byte("keep me"), 0644); err != nil {
+		t.FailNow()
+	}
+	// A symlink inside the base dir that points outside of it
+	if err := os.Symlink(outside.DirectoryName, filepath.Join(worker1.DirectoryName, "link")); err != nil {
+		t.FailNow()
+	}
+
+	entries := []*surfstore.FileMetaData{
+		{Filename: "../test_outside/victim.txt", Version: 1, BlockHashList: []string{surfstore.TOMBSTONE_HASH}},
+		{Filename: "../test_outside/made", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}},
+		{Filename: "/tmp/surfstore-absolute", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}},
+		{Filename: "link/victim.txt", Version: 1, BlockHashList: []string{surfstore.TOMBSTONE_HASH}},
+		{Filename: "link/made", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}},
+	}
+	for _, entry := range entries {
+		if _, err := test.Clients[0].UpdateFile(test.Context, entry); err != nil {
+			t.Fatalf("UpdateFile failed: %v", err)
+		}
+	}
+
+	client := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	surfstore.ClientSync(client)
+
+	if data, err := ioutil.ReadFile(victim); err != nil || string(data) != "keep me" {
+		t.Fatalf("File outside the base dir was changed: %q %v", data, err)
+	}
+	for _, dir := range []string{filepath.Join(outside.DirectoryName, "made"), "/tmp/surfstore-absolute"} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			os.Remove(dir)
+			t.Fatalf("Directory %s was created outside the base dir", dir)
+		}
+	}
+}
+
+func TestSyncRejectsEscapingSymlinks(t *testing.T) {
+	t.Logf("client creates no symlinks from the server that lead outside its base directory")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	outside := InitDirectoryWorker("test_outside", SRC_PATH)
+	defer worker1.CleanUp()
+	defer outside.CleanUp()
+
+	conn, err := grpc.Dial("localhost:8080", grpc.WithInsecure())
+	if err != nil {
+		t.FailNow()
+	}
+	defer conn.Close()
+	data := []byte("written through a symlink")
+	if _, err := surfstore.NewBlockStoreClient(conn).PutBlock(test.Context, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+		t.Fatalf("PutBlock failed: %v", err)
+	}
+
+	// A link to a directory outside and a file below it, which would be
+	// written there if the link was created first
+	symlink := []string{surfstore.SYMLINK_HASH}
+	entries := []*surfstore.FileMetaData{
+		{Filename: "evil", Version: 1, BlockHashList: symlink, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: outside.DirectoryName},
+		{Filename: "evil/x", Version: 1, BlockHashList: []string{surfstore.GetBlockHashString(data)}, FileType: surfstore.FileType_REGULAR},
+		{Filename: "sub", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}, FileType: surfstore.FileType_DIRECTORY},
+		{Filename: "up", Version: 1, BlockHashList: symlink, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: "../test_outside"},
+		{Filename: "sub/up", Version: 1, BlockHashList: symlink, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: "../../test_outside"},
+		{Filename: "sub/trick", Version: 1, BlockHashList: symlink, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: "up/../x"},
+		{Filename: "sub/inside", Version: 1, BlockHashList: symlink, FileType: surfstore.FileType_SYMLINK, SymlinkTarget: "../evil/x"},
+	}
+	for _, entry := range entries {
+		if _, err := test.Clients[0].UpdateFile(test.Context, entry); err != nil {
+			t.Fatalf("UpdateFile failed: %v", err)
+		}
+	}
+
+	client := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	surfstore.ClientSync(client)
+
+	if _, err := os.Stat(filepath.Join(outside.DirectoryName, "x")); !os.IsNotExist(err) {
+		t.Fatalf("File was written outside the base dir: %v", err)
+	}
+	for _, name := range []string{"evil", "up", "sub/up", "sub/trick"} {
+		if info, err := os.Lstat(filepath.Join(worker1.DirectoryName, name)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			t.Fatalf("Symlink %s was created", name)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(worker1.DirectoryName, "sub", "inside")); err != nil || target != "../evil/x" {
+		t.Fatalf("Expected a symlink to ../evil/x, got %q %v", target, err)
+	}
+}
+
+func TestSyncFileAttributes(t *testing.T) {
+	t.Logf("clients sync modes, modification times, symlinks and empty files")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	script := filepath.Join(worker1.DirectoryName, "bin", "run.sh")
+	if err := os.MkdirAll(filepath.Dir(script), 0750); err != nil {
+		t.FailNow()
+	}
+	if err := os.Chmod(filepath.Dir(script), 0750); err != nil {
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho hello\n"), 0755); err != nil {
+		t.FailNow()
+	}
+	if err := os.Chmod(script, 0755); err != nil {
+		t.FailNow()
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(script, mtime, mtime); err != nil {
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "empty.txt"), nil, 0600); err != nil {
+		t.FailNow()
+	}
+	if err := os.Symlink("bin/run.sh", filepath.Join(worker1.DirectoryName, "run")); err != nil {
+		t.FailNow()
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+
+	info, err := os.Stat(filepath.Join(worker2.DirectoryName, "bin", "run.sh"))
+	if err != nil {
+		t.Fatalf("Script missing at client2: %v", err)
+	}
+	if info.Mode().Perm() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Expected mode 0755 and mtime %v, got %v and %v", mtime, info.Mode().Perm(), info.ModTime())
+	}
+	if info, err := os.Stat(filepath.Join(worker2.DirectoryName, "bin")); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("Expected directory mode 0750, got %v %v", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(worker2.DirectoryName, "empty.txt")); err != nil || info.Size() != 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected an empty file with mode 0600, got %v %v", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(worker2.DirectoryName, "run")); err != nil || target != "bin/run.sh" {
+		t.Fatalf("Expected a symlink to bin/run.sh, got %q %v", target, err)
+	}
+
+	// A mode change is a new version, a new mtime alone is not
+	if err := os.Chmod(filepath.Join(worker2.DirectoryName, "bin", "run.sh"), 0700); err != nil {
+		t.FailNow()
+	}
+	if err := os.Chtimes(filepath.Join(worker2.DirectoryName, "empty.txt"), time.Now(), time.Now()); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(client2)
+	surfstore.ClientSync(client1)
+	if info, err := os.Stat(script); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected the mode change at client1, got %v %v", info, err)
+	}
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	if version := state.MetaMap.FileInfoMap["bin/run.sh"].Version; version != 2 {
+		t.Fatalf("Expected version 2 of the script, got %d", version)
+	}
+	if version := state.MetaMap.FileInfoMap["empty.txt"].Version; version != 1 {
+		t.Fatalf("Expected version 1 of the touched file, got %d", version)
+	}
+	if fileType := state.MetaMap.FileInfoMap["run"].FileType; fileType != surfstore.FileType_SYMLINK {
+		t.Fatalf("Expected run to be a symlink, got %v", fileType)
+	}
+}
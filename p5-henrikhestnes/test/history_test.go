@@ -0,0 +1,129 @@
+package SurfTest
+
+import (
+	"context"
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMetaStoreHistory(t *testing.T) {
+	metaStore := surfstore.NewMetaStore([]string{"localhost:8081"}, 0)
+	metaStore.HistoryLength = 2
+	ctx := context.Background()
+
+	for version := int32(1); version <= 4; version++ {
+		hash := surfstore.GetBlockHashString([]byte{byte(version)})
+		v, err := metaStore.UpdateFile(ctx, &surfstore.FileMetaData{Filename: "a.txt", Version: version, BlockHashList: []string{hash}})
+		if err != nil || v.Version != version {
+			t.Fatalf("UpdateFile to version %d failed: %v %v", version, v, err)
+		}
+	}
+
+	history, err := metaStore.GetFileHistory(ctx, &surfstore.FileVersion{Filename: "a.txt"})
+	if err != nil {
+		t.Fatalf("GetFileHistory failed: %v", err)
+	}
+	if len(history.Versions) != 3 {
+		t.Fatalf("Expected versions 2 to 4, got %d versions", len(history.Versions))
+	}
+	for i, fileMetaData := range history.Versions {
+		if fileMetaData.Version != int32(i+2) {
+			t.Fatalf("Expected version %d at %d, got %d", i+2, i, fileMetaData.Version)
+		}
+	}
+
+	if _, err := metaStore.GetFileVersion(ctx, &surfstore.FileVersion{Filename: "a.txt", Version: 1}); err != surfstore.ERR_VERSION_NOT_FOUND {
+		t.Fatalf("Expected version 1 to be dropped, got %v", err)
+	}
+	v2, err := metaStore.GetFileVersion(ctx, &surfstore.FileVersion{Filename: "a.txt", Version: 2})
+	if err != nil || v2.BlockHashList[0] != surfstore.GetBlockHashString([]byte{2}) {
+		t.Fatalf("GetFileVersion returned %v %v", v2, err)
+	}
+	if _, err := metaStore.GetFileHistory(ctx, &surfstore.FileVersion{Filename: "b.txt"}); err != surfstore.ERR_FILE_NOT_FOUND {
+		t.Fatalf("Expected an unknown file to fail, got %v", err)
+	}
+
+	// Blocks of kept versions are live, blocks of dropped versions are not
+	referenced := make(map[string]bool)
+	for _, hash := range metaStore.ReferencedBlockHashes() {
+		referenced[hash] = true
+	}
+	for version := 1; version <= 4; version++ {
+		if referenced[surfstore.GetBlockHashString([]byte{byte(version)})] != (version > 1) {
+			t.Fatalf("Block of version %d referenced: %v", version, !referenced[surfstore.GetBlockHashString([]byte{byte(version)})])
+		}
+	}
+}
+
+func TestRestoreFileVersion(t *testing.T) {
+	t.Logf("client restores an overwritten and then deleted file to its first version")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	blockSize := 4
+	path := worker1.DirectoryName + "/notes.txt"
+	for _, content := range []string{"first version", "second version"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.FailNow()
+		}
+		if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+	}
+	if err := worker1.DeleteFile("notes.txt"); err != nil {
+		t.FailNow()
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	out, err := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "-history", "notes.txt", "test0", "4").Output()
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 3 || lines[2] != "3 deleted" {
+		t.Fatalf("Unexpected history:\n%s", out)
+	}
+
+	restore := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "-restore", "notes.txt", "-version", "1", "test0", "4")
+	if err := restore.Run(); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil || string(data) != "first version" {
+		t.Fatalf("Expected the first version after the restore, got %q %v", data, err)
+	}
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	if version := state.MetaMap.FileInfoMap["notes.txt"].Version; version != 4 {
+		t.Fatalf("Expected the restore to be version 4, got %d", version)
+	}
+
+	if err := SyncClient("localhost:8080", "test1", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+
+	unknown := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "-restore", "notes.txt", "-version", "9", "test0", "4")
+	if err := unknown.Run(); err == nil {
+		t.Fatalf("Restoring a version that does not exist succeeded")
+	}
+}
@@ -0,0 +1,197 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSyncFilterPatterns(t *testing.T) {
+	ignore := `# build output
+*.o
+build/
+/top.txt
+docs/**/*.tmp
+!keep.o
+\#literal
+`
+	filter := surfstore.NewSyncFilter(ignore, "photos\n# comment\nshared/big/\n")
+	tests := []struct {
+		name    string
+		isDir   bool
+		skipped bool
+	}{
+		{"a.o", false, true},
+		{"src/a.o", false, true},
+		{"keep.o", false, false},
+		{"src/keep.o", false, false},
+		{"a.c", false, false},
+		{"build", true, true},
+		{"build/out.bin", false, true},
+		{"src/build/out.bin", false, true},
+		{"build", false, false},
+		{"top.txt", false, true},
+		{"src/top.txt", false, false},
+		{"docs/a.tmp", false, true},
+		{"docs/x/y/a.tmp", false, true},
+		{"a.tmp", false, false},
+		{"#literal", false, true},
+		{"photos", true, true},
+		{"photos/2020/a.jpg", false, true},
+		{"photos2", false, false},
+		{"shared/big/file", false, true},
+		{"shared/small", false, false},
+	}
+	for _, test := range tests {
+		if skipped := filter.Skipped(test.name, test.isDir); skipped != test.skipped {
+			t.Errorf("Expected Skipped(%q, %v) to be %v", test.name, test.isDir, test.skipped)
+		}
+	}
+
+	if surfstore.NewSyncFilter("", "").Fingerprint != "" {
+		t.Errorf("Expected no fingerprint without rules")
+	}
+	if filter.Fingerprint == surfstore.NewSyncFilter(ignore, "").Fingerprint {
+		t.Errorf("Expected the fingerprint to change with the rules")
+	}
+}
+
+func TestSyncIgnoredFiles(t *testing.T) {
+	t.Logf("files matching .surfignore are neither uploaded, downloaded nor deleted")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	files := map[string]string{
+		surfstore.IGNORE_FILENAME: "*.o\nbuild/\n!keep.o\n",
+		"a.c":                     "int main;",
+		"a.o":                     "object",
+		"keep.o":                  "kept",
+		"build/out.bin":           "binary",
+		"late.txt":                "late",
+	}
+	if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, "build"), 0755); err != nil {
+		t.FailNow()
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, name), []byte(content), 0644); err != nil {
+			t.FailNow()
+		}
+	}
+	// Client2 has the rules before its first sync, so it does not download
+	// files that were on the server before them
+	if err := ioutil.WriteFile(filepath.Join(worker2.DirectoryName, surfstore.IGNORE_FILENAME), []byte(files[surfstore.IGNORE_FILENAME]), 0644); err != nil {
+		t.FailNow()
+	}
+	// Matches the rules, but is already on the server
+	if _, err := test.Clients[0].UpdateFile(test.Context, &surfstore.FileMetaData{Filename: "remote.o", Version: 1, BlockHashList: []string{surfstore.DIRECTORY_HASH}}); err != nil {
+		t.Fatalf("UpdateFile failed: %v", err)
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+
+	state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	for _, name := range []string{"a.o", "build", "build/out.bin"} {
+		if _, ok := state.MetaMap.FileInfoMap[name]; ok {
+			t.Fatalf("Ignored file %s was uploaded", name)
+		}
+	}
+	for _, name := range []string{surfstore.IGNORE_FILENAME, "a.c", "keep.o", "late.txt"} {
+		if _, err := os.Stat(filepath.Join(worker2.DirectoryName, name)); err != nil {
+			t.Fatalf("Expected %s at client2: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "remote.o")); !os.IsNotExist(err) {
+		t.Fatalf("Ignored file remote.o was downloaded: %v", err)
+	}
+
+	// A synced file that becomes ignored is not deleted on the server
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, surfstore.IGNORE_FILENAME), []byte("*.o\nbuild/\n!keep.o\nlate.txt\n"), 0644); err != nil {
+		t.FailNow()
+	}
+	if err := os.Remove(filepath.Join(worker1.DirectoryName, "late.txt")); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	state, err = test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get internal state: %v", err)
+	}
+	if late := state.MetaMap.FileInfoMap["late.txt"]; late.GetVersion() != 1 {
+		t.Fatalf("Expected late.txt to stay at version 1, got %v", late)
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "late.txt")); err != nil {
+		t.Fatalf("Expected late.txt to stay at client2: %v", err)
+	}
+}
+
+func TestSyncExcludedSubtree(t *testing.T) {
+	t.Logf("a client does not download the subtrees it excludes, until it includes them again")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, "videos", "2020"), 0755); err != nil {
+		t.FailNow()
+	}
+	for _, name := range []string{"notes.txt", "videos/2020/a.mp4"} {
+		if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, name), []byte(name), 0644); err != nil {
+			t.FailNow()
+		}
+	}
+	exclude := filepath.Join(worker2.DirectoryName, surfstore.EXCLUDE_FILENAME)
+	if err := ioutil.WriteFile(exclude, []byte("videos\n"), 0644); err != nil {
+		t.FailNow()
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "notes.txt")); err != nil {
+		t.Fatalf("Expected notes.txt at client2: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "videos")); !os.IsNotExist(err) {
+		t.Fatalf("Excluded subtree was downloaded: %v", err)
+	}
+	// The exclude file belongs to client2 alone
+	surfstore.ClientSync(client1)
+	if _, err := os.Stat(filepath.Join(worker1.DirectoryName, surfstore.EXCLUDE_FILENAME)); !os.IsNotExist(err) {
+		t.Fatalf("Exclude file was synced: %v", err)
+	}
+
+	// Nothing changed on the server, but the subtree is fetched once included
+	if err := os.Remove(exclude); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(client2)
+	if want, got := treeContents(t, worker1.DirectoryName), treeContents(t, worker2.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client2 once the subtree is no longer excluded, got %v", want, got)
+	}
+}
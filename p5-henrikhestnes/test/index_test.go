@@ -0,0 +1,252 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestLocalIndexMigratesLegacyFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surfstore-index")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	legacy := surfstore.CURSOR_LINE_PREFIX + "7,42\n" +
+		"old.txt,3,h1 h2 \n" +
+		"bin/run.sh,1,h3 ,755,1577934245000000000,0,\n" +
+		"run,2,link ,0,0,2,bin/run.sh\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, META_FILENAME), []byte(legacy), 0644); err != nil {
+		t.FailNow()
+	}
+	index, err := surfstore.LoadLocalIndex(dir)
+	if err != nil {
+		t.Fatalf("Could not load legacy index: %v", err)
+	}
+	if index.Cursor == nil || index.Cursor.Epoch != 7 || index.Cursor.Index != 42 {
+		t.Fatalf("Expected cursor 7,42, got %v", index.Cursor)
+	}
+	if old := index.Files["old.txt"]; old == nil || old.Version != 3 || !reflect.DeepEqual(old.BlockHashList, []string{"h1", "h2"}) {
+		t.Fatalf("Unexpected entry for old.txt: %v", old)
+	}
+	if script := index.Files["bin/run.sh"]; script == nil || script.Mode != 0755 || script.Mtime != 1577934245000000000 {
+		t.Fatalf("Unexpected entry for bin/run.sh: %v", script)
+	}
+	if link := index.Files["run"]; link == nil || link.FileType != surfstore.FileType_SYMLINK || link.SymlinkTarget != "bin/run.sh" {
+		t.Fatalf("Unexpected entry for run: %v", link)
+	}
+
+	// Written back in the new format, with the same content
+	if err := index.Write(dir); err != nil {
+		t.Fatalf("Could not write index: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, META_FILENAME))
+	if err != nil || !strings.HasPrefix(string(data), `{"surfstoreIndex":2`) {
+		t.Fatalf("Expected an index in the new format, got %q %v", data, err)
+	}
+	reloaded, err := surfstore.LoadLocalIndex(dir)
+	if err != nil {
+		t.Fatalf("Could not load migrated index: %v", err)
+	}
+	if !sameLocalIndex(index, reloaded) {
+		t.Fatalf("Migrated index differs: %v %v", index.Files, reloaded.Files)
+	}
+}
+
+func TestLocalIndexRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surfstore-index")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	index := surfstore.NewLocalIndex()
+	for _, name := range []string{"a, b.txt", "with space/c d.txt", `quote"d <&>.txt`, "ünïcode.txt"} {
+		index.Files[name] = &surfstore.FileMetaData{Filename: name, Version: 2, BlockHashList: []string{"h1", "h2"}, Mode: 0644, Mtime: 5}
+		index.States[name] = surfstore.LocalFileState{Size: 10, Mtime: 5}
+	}
+	index.Files["gone.txt"] = &surfstore.FileMetaData{Filename: "gone.txt", Version: 4, BlockHashList: []string{surfstore.TOMBSTONE_HASH}}
+	index.Cursor = &surfstore.Cursor{Epoch: 1, Index: 9}
+	if err := index.Write(dir); err != nil {
+		t.Fatalf("Could not write index: %v", err)
+	}
+
+	reloaded, err := surfstore.LoadLocalIndex(dir)
+	if err != nil {
+		t.Fatalf("Could not load index: %v", err)
+	}
+	if !sameLocalIndex(index, reloaded) || !reflect.DeepEqual(index.States, reloaded.States) {
+		t.Fatalf("Expected %v %v, got %v %v", index.Files, index.States, reloaded.Files, reloaded.States)
+	}
+	if reloaded.Cursor == nil || reloaded.Cursor.Epoch != 1 || reloaded.Cursor.Index != 9 {
+		t.Fatalf("Expected cursor 1,9, got %v", reloaded.Cursor)
+	}
+	names, _ := ioutil.ReadDir(dir)
+	if len(names) != 1 {
+		t.Fatalf("Expected only the index in %s, found %d files", dir, len(names))
+	}
+}
+
+func TestLocalIndexRejectsNewerFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "surfstore-index")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, META_FILENAME), []byte(`{"surfstoreIndex":99}`+"\n"), 0644); err != nil {
+		t.FailNow()
+	}
+	if _, err := surfstore.LoadLocalIndex(dir); err == nil {
+		t.Fatalf("Expected an error for an index from a newer client")
+	}
+}
+
+func TestSyncNamesWithCommasAndSpaces(t *testing.T) {
+	t.Logf("clients sync files whose names the legacy index could not hold")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, "x, y"), 0755); err != nil {
+		t.FailNow()
+	}
+	// Old enough for its state to be recorded
+	mtime := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a, b.txt", "x, y/c d.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, name), []byte(name), 0644); err != nil {
+			t.FailNow()
+		}
+		if err := os.Chtimes(filepath.Join(worker1.DirectoryName, name), mtime, mtime); err != nil {
+			t.FailNow()
+		}
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	if want, got := treeContents(t, worker1.DirectoryName), treeContents(t, worker2.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client2, got %v", want, got)
+	}
+
+	// Both indexes know the synced state of the files
+	for _, dir := range []string{worker1.DirectoryName, worker2.DirectoryName} {
+		index, err := surfstore.LoadLocalIndex(dir)
+		if err != nil {
+			t.Fatalf("Could not load index: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(dir, "a, b.txt"))
+		if err != nil {
+			t.FailNow()
+		}
+		state, ok := index.States["a, b.txt"]
+		if !ok || state.Size != info.Size() || state.Mtime != info.ModTime().UnixNano() {
+			t.Fatalf("Expected the state of a, b.txt in %s, got %v", dir, state)
+		}
+		if index.Files["a, b.txt"].GetVersion() != 1 {
+			t.Fatalf("Expected version 1 of a, b.txt in %s, got %v", dir, index.Files["a, b.txt"])
+		}
+	}
+}
+
+func TestSyncSkipsHashingUnchangedFiles(t *testing.T) {
+	t.Logf("client trusts the size, mtime and inode in its index unless asked to verify")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	defer worker1.CleanUp()
+
+	old := filepath.Join(worker1.DirectoryName, "old.txt")
+	fresh := filepath.Join(worker1.DirectoryName, "fresh.txt")
+	mtime := time.Now().Add(-time.Hour)
+	if err := ioutil.WriteFile(old, []byte("original"), 0644); err != nil {
+		t.FailNow()
+	}
+	if err := os.Chtimes(old, mtime, mtime); err != nil {
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(fresh, []byte("fresh"), 0644); err != nil {
+		t.FailNow()
+	}
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	surfstore.ClientSync(client1)
+	index, err := surfstore.LoadLocalIndex(worker1.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load index: %v", err)
+	}
+	if _, ok := index.States["old.txt"]; !ok {
+		t.Fatalf("Expected the state of old.txt in the index")
+	}
+	// It could still be written again within the same mtime tick
+	if _, ok := index.States["fresh.txt"]; ok {
+		t.Fatalf("Expected no state for a file modified during the sync")
+	}
+
+	// Same size, mtime and inode: the new content goes unnoticed
+	file, err := os.OpenFile(old, os.O_WRONLY, 0)
+	if err != nil {
+		t.FailNow()
+	}
+	if _, err := file.WriteAt([]byte("ORIGINAL"), 0); err != nil {
+		t.FailNow()
+	}
+	file.Close()
+	if err := os.Chtimes(old, mtime, mtime); err != nil {
+		t.FailNow()
+	}
+	version := func() int32 {
+		state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+		if err != nil {
+			t.Fatalf("Could not get internal state: %v", err)
+		}
+		return state.MetaMap.FileInfoMap["old.txt"].GetVersion()
+	}
+	surfstore.ClientSync(client1)
+	if v := version(); v != 1 {
+		t.Fatalf("Expected old.txt not to be hashed again, got version %d", v)
+	}
+
+	client1.Verify = true
+	surfstore.ClientSync(client1)
+	if v := version(); v != 2 {
+		t.Fatalf("Expected -verify to find the change to old.txt, got version %d", v)
+	}
+}
+
+// sameLocalIndex reports whether two indexes have the same entries
+func sameLocalIndex(a *surfstore.LocalIndex, b *surfstore.LocalIndex) bool {
+	if len(a.Files) != len(b.Files) {
+		return false
+	}
+	for name, fileMetaData := range a.Files {
+		other, ok := b.Files[name]
+		if !ok || other.Filename != fileMetaData.Filename || other.Version != fileMetaData.Version ||
+			!SameHashList(other.BlockHashList, fileMetaData.BlockHashList) || other.Mode != fileMetaData.Mode ||
+			other.Mtime != fileMetaData.Mtime || other.FileType != fileMetaData.FileType ||
+			other.SymlinkTarget != fileMetaData.SymlinkTarget {
+			return false
+		}
+	}
+	return true
+}
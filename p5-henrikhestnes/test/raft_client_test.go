@@ -1,8 +1,12 @@
 package SurfTest
 
 import (
+	"cse224/proj5/pkg/surfstore"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"strings"
 	"testing"
 	//	"time"
 )
@@ -49,11 +53,11 @@ func TestSyncTwoClientsSameFileLeaderFailure(t *testing.T) {
 	test.Clients[1].SetLeader(test.Context, &emptypb.Empty{})
 	test.Clients[1].SendHeartbeat(test.Context, &emptypb.Empty{})
 
-	//client2 syncs
+	//client2 syncs, its own file1 is a conflict
 	err = SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath)
 	test.Clients[1].SendHeartbeat(test.Context, &emptypb.Empty{})
-	if err != nil {
-		t.Fatalf("Sync failed")
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != EX_CONFLICT {
+		t.Fatalf("Expected sync to report a conflict, got %v", err)
 	}
 
 	//client1 syncs
@@ -76,7 +80,7 @@ func TestSyncTwoClientsSameFileLeaderFailure(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load meta file for client1")
 	}
-	if len(fileMeta1) != 1 {
+	if len(fileMeta1) != 2 {
 		t.Fatalf("Wrong number of entries in client1 meta file")
 	}
 	if fileMeta1[file1].Version != 1 {
@@ -101,7 +105,7 @@ func TestSyncTwoClientsSameFileLeaderFailure(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Could not load meta file for client2")
 	}
-	if len(fileMeta2) != 1 {
+	if len(fileMeta2) != 2 {
 		t.Fatalf("Wrong number of entries in client2 meta file")
 	}
 	if fileMeta1[file1].Version != 1 {
@@ -115,4 +119,93 @@ func TestSyncTwoClientsSameFileLeaderFailure(t *testing.T) {
 	if !c {
 		t.Fatalf("wrong file2 contents at client2")
 	}
+
+	//client2's changes are kept in a conflicted copy that reached client1
+	host, _ := os.Hostname()
+	conflictCopy := "multi_file1 (conflicted copy from " + host + ").txt"
+	if _, ok := fileMeta1[conflictCopy]; !ok {
+		t.Fatalf("Conflicted copy missing from client1 metadata")
+	}
+	c, e = SameFile(workingDir+"/test0/"+conflictCopy, SRC_PATH+"/multi_file1.txt")
+	if e != nil {
+		t.Fatalf("Could not read conflicted copy: %v", e)
+	}
+	if c {
+		t.Fatalf("conflicted copy should hold client2's changes")
+	}
+}
+
+// A and B edit a synced file; B keeps A's version and its own edit as a copy.
+// A deletes a file B edited; the edit wins without a conflict.
+func TestSyncConflictedCopy(t *testing.T) {
+	t.Logf("client1 and client2 edit the same file, client2 saves its edit as a conflicted copy")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	file1 := "multi_file1.txt"
+	file2 := "multi_file2.txt"
+	if err := worker1.AddFile(file1); err != nil {
+		t.FailNow()
+	}
+	if err := worker1.AddFile(file2); err != nil {
+		t.FailNow()
+	}
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, BLOCK_SIZE)
+	client2 := surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, BLOCK_SIZE)
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+
+	if err := worker1.UpdateFile(file1, "edit from client1"); err != nil {
+		t.FailNow()
+	}
+	if err := worker1.DeleteFile(file2); err != nil {
+		t.FailNow()
+	}
+	if stats, err := surfstore.ClientSync(client1); err != nil || len(stats.Conflicts) != 0 {
+		t.Fatalf("Unexpected conflicts %v", stats.Conflicts)
+	}
+
+	if err := worker2.UpdateFile(file1, "edit from client2"); err != nil {
+		t.FailNow()
+	}
+	if err := worker2.UpdateFile(file2, "edit from client2"); err != nil {
+		t.FailNow()
+	}
+	stats, err := surfstore.ClientSync(client2)
+	if err != nil || len(stats.Conflicts) != 1 || stats.Conflicts[0] != file1 {
+		t.Fatalf("Expected a conflict on %s, got %v", file1, stats.Conflicts)
+	}
+
+	host, _ := os.Hostname()
+	conflictCopy := "multi_file1 (conflicted copy from " + host + ").txt"
+	data, err := ioutil.ReadFile(worker2.DirectoryName + "/" + file1)
+	if err != nil || !strings.HasSuffix(string(data), "edit from client1\n") {
+		t.Fatalf("Expected client1's version of %s, got %v", file1, err)
+	}
+	data, err = ioutil.ReadFile(worker2.DirectoryName + "/" + conflictCopy)
+	if err != nil || !strings.HasSuffix(string(data), "edit from client2\n") {
+		t.Fatalf("Expected client2's edit in the conflicted copy, got %v", err)
+	}
+
+	// The edit of file2 replaced the delete, and client1 gets both files back
+	surfstore.ClientSync(client1)
+	if !DirFullySynced(*worker1, *worker2) {
+		t.Fatalf("Directories are not synced")
+	}
+	if _, err := os.Stat(worker1.DirectoryName + "/" + file2); err != nil {
+		t.Fatalf("Edited %s was not restored at client1: %v", file2, err)
+	}
+
+	// Another sync finds nothing to resolve
+	if stats, err := surfstore.ClientSync(client2); err != nil || len(stats.Conflicts) != 0 || len(stats.Rejected) != 0 {
+		t.Fatalf("Unexpected conflicts %v, rejected %v", stats.Conflicts, stats.Rejected)
+	}
 }
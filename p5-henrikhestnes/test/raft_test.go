@@ -95,7 +95,7 @@ func TestRaftFollowersGetUpdates(t *testing.T) {
 	test.Clients[leaderIdx].UpdateFile(context.Background(), filemeta1)
 	test.Clients[leaderIdx].SendHeartbeat(test.Context, &emptypb.Empty{})
 
-	goldenMeta := surfstore.NewMetaStore("")
+	goldenMeta := surfstore.NewMetaStore(nil, 0)
 	goldenMeta.UpdateFile(test.Context, filemeta1)
 	goldenLog := make([]*surfstore.UpdateOperation, 0)
 	goldenLog = append(goldenLog, &surfstore.UpdateOperation{
@@ -22,11 +22,19 @@ type TestInfo struct {
 }
 
 func InitTest(cfgPath, blockStorePort string) TestInfo {
+	return InitTestWithBlockStores(cfgPath, []string{blockStorePort}, "-b", "localhost:"+blockStorePort)
+}
+
+// InitTestWithBlockStores starts a BlockStore on each port and the Raft servers
+// with raftArgs appended to their command line
+func InitTestWithBlockStores(cfgPath string, blockStorePorts []string, raftArgs ...string) TestInfo {
 	cfg := surfstore.LoadRaftConfigFile(cfgPath)
 
 	procs := make([]*exec.Cmd, 0)
-	procs = append(procs, InitBlockStore(blockStorePort))
-	procs = append(procs, InitRaftServers(cfgPath)...)
+	for _, port := range blockStorePorts {
+		procs = append(procs, InitBlockStore(port))
+	}
+	procs = append(procs, InitRaftServers(cfgPath, raftArgs...)...)
 
 	conns := make([]*grpc.ClientConn, 0)
 	clients := make([]surfstore.RaftSurfstoreClient, 0)
@@ -70,8 +78,10 @@ func EndTest(test TestInfo) {
 	time.Sleep(100 * time.Millisecond)
 }
 
-func InitBlockStore(blockStorePort string) *exec.Cmd {
-	blockCmd := exec.Command("_bin/SurfstoreServerExec", "-s", "block", "-p", blockStorePort, "-l")
+// InitBlockStore starts a BlockStore on a port with args appended to its
+// command line
+func InitBlockStore(blockStorePort string, args ...string) *exec.Cmd {
+	blockCmd := exec.Command("_bin/SurfstoreServerExec", append([]string{"-s", "block", "-p", blockStorePort, "-l"}, args...)...)
 	blockCmd.Stderr = os.Stderr
 	blockCmd.Stdout = os.Stdout
 	err := blockCmd.Start()
@@ -82,11 +92,11 @@ func InitBlockStore(blockStorePort string) *exec.Cmd {
 	return blockCmd
 }
 
-func InitRaftServers(cfgPath string) []*exec.Cmd {
+func InitRaftServers(cfgPath string, args ...string) []*exec.Cmd {
 	cfg := surfstore.LoadRaftConfigFile(cfgPath)
 	cmdList := make([]*exec.Cmd, 0)
 	for idx, _ := range cfg {
-		cmd := exec.Command("_bin/SurfstoreRaftServerExec", "-f", cfgPath, "-i", strconv.Itoa(idx), "-b", "localhost:8080")
+		cmd := exec.Command("_bin/SurfstoreRaftServerExec", append([]string{"-f", cfgPath, "-i", strconv.Itoa(idx)}, args...)...)
 		cmd.Stderr = os.Stderr
 		cmd.Stdout = os.Stdout
 		cmdList = append(cmdList, cmd)
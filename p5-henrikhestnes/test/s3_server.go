@@ -0,0 +1,106 @@
+package SurfTest
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FakeS3Server is a minimal in-process stand-in for MinIO. It serves the
+// subset of the S3 API used by surfstore.S3Backend: bucket creation, object
+// GET/PUT/HEAD/DELETE with user metadata and ListObjectsV2.
+type FakeS3Server struct {
+	*httptest.Server
+	mtx     sync.Mutex
+	buckets map[string]map[string]fakeS3Stored
+}
+
+type fakeS3Stored struct {
+	data     []byte
+	metadata http.Header
+}
+
+type fakeS3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key  string
+	Size int64
+}
+
+func NewFakeS3Server() *FakeS3Server {
+	s := &FakeS3Server{buckets: make(map[string]map[string]fakeS3Stored)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *FakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucketName := parts[0]
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucket, ok := s.buckets[bucketName]
+
+	if len(parts) == 1 {
+		switch {
+		case r.Method == http.MethodPut:
+			if !ok {
+				s.buckets[bucketName] = make(map[string]fakeS3Stored)
+			}
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet:
+			result := fakeS3ListResult{}
+			for key, object := range bucket {
+				result.Contents = append(result.Contents, fakeS3Object{Key: key, Size: int64(len(object.data))})
+			}
+			sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+			xml.NewEncoder(w).Encode(result)
+		}
+		return
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := parts[1]
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		metadata := http.Header{}
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				metadata[name] = values
+			}
+		}
+		bucket[key] = fakeS3Stored{data: data, metadata: metadata}
+	case http.MethodGet, http.MethodHead:
+		object, ok := bucket[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range object.metadata {
+			w.Header()[name] = values
+		}
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -4,7 +4,13 @@ const SRC_PATH = "./test_files"
 const BLOCK_SIZE = 1024
 const META_FILENAME = "index.txt"
 
+// Exit status of a client sync that found conflicts
+const EX_CONFLICT = 2
+
 const DEFAULT_META_FILENAME string = "index.txt"
+
+// Key of the format version in the first line of index.txt
+const INDEX_FORMAT_KEY string = "surfstoreIndex"
 const DEFAULT_BLOCK_SIZE int = 4096
 
 const META_INIT_BY_FILENAME int = 0
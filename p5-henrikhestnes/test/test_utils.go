@@ -1,11 +1,10 @@
 package SurfTest
 
 import (
-	"bufio"
 	"bytes"
 	"cse224/proj5/pkg/surfstore"
+	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
+	"strings"
 )
 
 func IsTombHashList(hashList []string) bool {
@@ -38,6 +38,14 @@ func ConcatPath(baseDir, fileDir string) string {
 	return baseDir + "/" + fileDir
 }
 
+// indexEntry is a file's line in index.txt. It is parsed here rather than
+// with the client's reader, so that the tests check the format on disk.
+type indexEntry struct {
+	Name          string   `json:"name"`
+	Version       int32    `json:"version"`
+	BlockHashList []string `json:"blockHashList"`
+}
+
 func LoadMetaFromMetaFile(baseDir string) (fileMetaMap map[string]*surfstore.FileMetaData, e error) {
 	metaFilePath, _ := filepath.Abs(ConcatPath(baseDir, DEFAULT_META_FILENAME))
 
@@ -47,34 +55,30 @@ func LoadMetaFromMetaFile(baseDir string) (fileMetaMap map[string]*surfstore.Fil
 	if e != nil || metaFileStats.IsDir() {
 		return fileMetaMap, nil
 	}
-	metaFD, e := os.Open(metaFilePath)
+	content, e := ioutil.ReadFile(metaFilePath)
 	if e != nil {
 		return nil, e
 	}
-	defer metaFD.Close()
-
-	leftOverContent := ""
-	metaReader := bufio.NewReader(metaFD)
-	for {
-		lineContent, isPrefix, e := metaReader.ReadLine()
-		if e != nil && e != io.EOF {
-			return nil, e
-		}
-
-		leftOverContent += string(lineContent)
-		if isPrefix {
-			continue
-		}
+	if len(content) == 0 {
+		return fileMetaMap, nil
+	}
 
-		if len(leftOverContent) == 0 {
-			break
+	// The first line is the header with the format version, then one JSON
+	// object per file
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	var header map[string]json.RawMessage
+	if e := json.Unmarshal([]byte(lines[0]), &header); e != nil {
+		return nil, fmt.Errorf("bad index header %q: %v", lines[0], e)
+	}
+	if _, ok := header[INDEX_FORMAT_KEY]; !ok {
+		return nil, fmt.Errorf("index header %q has no %s", lines[0], INDEX_FORMAT_KEY)
+	}
+	for _, line := range lines[1:] {
+		var entry indexEntry
+		if e := json.Unmarshal([]byte(line), &entry); e != nil || entry.Name == "" {
+			return nil, fmt.Errorf("bad index entry %q: %v", line, e)
 		}
-
-		currFileMeta := NewFileMetaData(META_INIT_BY_CONFIG_STR,
-			"", 0, nil, leftOverContent)
-
-		leftOverContent = ""
-		fileMetaMap[currFileMeta.Filename] = currFileMeta
+		fileMetaMap[entry.Name] = NewFileMetaDataFromParams(entry.Name, int(entry.Version), entry.BlockHashList)
 	}
 
 	return fileMetaMap, nil
@@ -0,0 +1,276 @@
+package SurfTest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"cse224/proj5/pkg/surfstore"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testCA signs certificates for localhost
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// PEM file of the CA certificate
+	path string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Could not create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse CA certificate: %v", err)
+	}
+	path := filepath.Join(dir, name+".pem")
+	writePEM(t, path, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, path: path}
+}
+
+// issue writes a certificate for localhost signed by the CA, usable by both
+// servers and clients, and returns its certificate and key files
+func (ca *testCA) issue(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Could not marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Could not write %s: %v", path, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+	strangerCert, strangerKey := otherCA.issue(t, dir, "stranger")
+
+	serverTLS := &surfstore.TLSConfig{CAFile: ca.path, CertFile: serverCert, KeyFile: serverKey, Mutual: true}
+	if err := serverTLS.Load(); err != nil {
+		t.Fatalf("Could not load server certificates: %v", err)
+	}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(serverTLS.ServerCredentials()))
+	surfstore.RegisterBlockStoreServer(grpcServer, surfstore.NewBlockStore())
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	putBlock := func(tlsConfig *surfstore.TLSConfig) error {
+		client := surfstore.NewSurfstoreRPCClient(nil, "", 4)
+		if tlsConfig != nil {
+			if err := tlsConfig.Load(); err != nil {
+				t.Fatalf("Could not load client certificates: %v", err)
+			}
+		}
+		client.TLS = tlsConfig
+		var succ bool
+		return client.PutBlock(&surfstore.Block{BlockData: []byte("data"), BlockSize: 4}, lis.Addr().String(), &succ)
+	}
+	if err := putBlock(&surfstore.TLSConfig{CAFile: ca.path, CertFile: clientCert, KeyFile: clientKey}); err != nil {
+		t.Fatalf("Expected a client with a certificate from the CA to connect: %v", err)
+	}
+	rejected := map[string]*surfstore.TLSConfig{
+		"plaintext":                 nil,
+		"no certificate":            {CAFile: ca.path},
+		"certificate of another CA": {CAFile: ca.path, CertFile: strangerCert, KeyFile: strangerKey},
+		"untrusted server":          {CAFile: otherCA.path, CertFile: clientCert, KeyFile: clientKey},
+	}
+	for name, tlsConfig := range rejected {
+		if err := putBlock(tlsConfig); err == nil {
+			t.Errorf("Expected a client with %s to be rejected", name)
+		}
+	}
+
+	for name, tlsConfig := range map[string]*surfstore.TLSConfig{
+		"missing CA":       {CAFile: filepath.Join(dir, "missing.pem")},
+		"key of the CA":    {CAFile: ca.path, CertFile: clientCert, KeyFile: strangerKey},
+		"empty CA":         {CAFile: filepath.Join(dir, "client-key.pem")},
+		"cert without key": {CertFile: clientCert},
+	} {
+		if err := tlsConfig.Load(); err == nil {
+			t.Errorf("Expected loading a config with %s to fail", name)
+		}
+	}
+}
+
+func TestSyncOverMutualTLS(t *testing.T) {
+	t.Logf("clients, BlockStores and Raft servers talk over mutual TLS, with separate peer certificates")
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	peerCA := newTestCA(t, dir, "peer-ca")
+	blockCert, blockKey := ca.issue(t, dir, "block")
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	peerCert, peerKey := peerCA.issue(t, dir, "peer")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+
+	cfgPath := filepath.Join(dir, "cluster.yaml")
+	cfg := "servers:\n"
+	for i := 0; i < 3; i++ {
+		cfg += fmt.Sprintf("  - id: %d\n    raftAddr: localhost:%d\n    clientAddr: localhost:%d\n", i, 9007+i, 9107+i)
+	}
+	cfg += "blockStores:\n  - addr: localhost:8080\n"
+	cfg += "tls:\n  caFile: " + ca.path + "\n  mutual: true\n  peerCAFile: " + peerCA.path + "\n"
+	if err := ioutil.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.FailNow()
+	}
+	config, err := surfstore.LoadClusterConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("Could not load config: %v", err)
+	}
+
+	blockStore := InitBlockStore("8080", "-f", cfgPath, "-tls-cert", blockCert, "-tls-key", blockKey)
+	test := InitTestWithBlockStores(cfgPath, nil, "-tls-cert", serverCert, "-tls-key", serverKey, "-peer-cert", peerCert, "-peer-key", peerKey)
+	test.Procs = append(test.Procs, blockStore)
+	defer EndTest(test)
+
+	clientTLS := &surfstore.TLSConfig{CAFile: ca.path, CertFile: clientCert, KeyFile: clientKey}
+	if err := clientTLS.Load(); err != nil {
+		t.Fatalf("Could not load client certificates: %v", err)
+	}
+	peerTLS := &surfstore.TLSConfig{CAFile: peerCA.path, CertFile: peerCert, KeyFile: peerKey}
+	if err := peerTLS.Load(); err != nil {
+		t.Fatalf("Could not load peer certificates: %v", err)
+	}
+	// The testing methods are only served on the Raft address
+	conn, err := grpc.Dial(config.RaftAddrs()[0], grpc.WithTransportCredentials(peerTLS.ClientCredentials()))
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	leader := surfstore.NewRaftSurfstoreClient(conn)
+	if _, err := leader.SetLeader(test.Context, &emptypb.Empty{}); err != nil {
+		t.Fatalf("SetLeader failed: %v", err)
+	}
+	// Only succeeds if the peers accept each other's certificates
+	if success, err := leader.SendHeartbeat(test.Context, &emptypb.Empty{}); err != nil || !success.Flag {
+		t.Fatalf("Expected the peers to answer the heartbeat, got %v %v", success, err)
+	}
+
+	// A client certificate cannot call the peer and testing methods on the
+	// client address
+	clientConn, err := grpc.Dial(config.ClientAddrs()[0], grpc.WithTransportCredentials(clientTLS.ClientCredentials()))
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer clientConn.Close()
+	sameServer := surfstore.NewRaftSurfstoreClient(clientConn)
+	_, err = sameServer.AppendEntries(test.Context, &surfstore.AppendEntryInput{Term: 100})
+	expectCode(t, err, codes.Unimplemented, "AppendEntries on the client address")
+	_, err = sameServer.Crash(test.Context, &emptypb.Empty{})
+	expectCode(t, err, codes.Unimplemented, "Crash on the client address")
+	if state, err := leader.GetInternalState(test.Context, &emptypb.Empty{}); err != nil || !state.IsLeader || state.Term != 1 {
+		t.Fatalf("Expected the server to stay the leader of term 1, got %v %v", state, err)
+	}
+	if crashed, err := leader.IsCrashed(test.Context, &emptypb.Empty{}); err != nil || crashed.IsCrashed {
+		t.Fatalf("Expected the leader to keep running, got %v %v", crashed, err)
+	}
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	worker3 := InitDirectoryWorker("test2", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	defer worker3.CleanUp()
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "secret.txt"), []byte("over tls"), 0644); err != nil {
+		t.FailNow()
+	}
+	client1 := surfstore.NewSurfstoreRPCClient(config.ClientAddrs(), worker1.DirectoryName, 4)
+	client2 := surfstore.NewSurfstoreRPCClient(config.ClientAddrs(), worker2.DirectoryName, 4)
+	client1.TLS, client2.TLS = clientTLS, clientTLS
+	surfstore.ClientSync(client1)
+	surfstore.ClientSync(client2)
+	if want, got := treeContents(t, worker1.DirectoryName), treeContents(t, worker2.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client2, got %v", want, got)
+	}
+
+	// The client takes the CA from the config and its certificate from flags
+	clientCmd := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "-tls-cert", clientCert, "-tls-key", clientKey, worker3.DirectoryName, strconv.Itoa(4))
+	clientCmd.Stderr = os.Stderr
+	if err := clientCmd.Run(); err != nil {
+		t.Fatalf("Client failed: %v", err)
+	}
+	if want, got := treeContents(t, worker1.DirectoryName), treeContents(t, worker3.DirectoryName); !reflect.DeepEqual(want, got) {
+		t.Fatalf("Expected %v at client3, got %v", want, got)
+	}
+
+	var fileInfoMap map[string]*surfstore.FileMetaData
+	plaintext := surfstore.NewSurfstoreRPCClient(config.ClientAddrs(), worker2.DirectoryName, 4)
+	if err := plaintext.GetFileInfoMap(&fileInfoMap, nil, nil); err == nil {
+		t.Fatalf("Expected a plaintext client to be rejected")
+	}
+	// Client certificates are not accepted on the Raft address
+	raftConn, err := grpc.Dial(config.RaftAddrs()[1], grpc.WithTransportCredentials(clientTLS.ClientCredentials()))
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer raftConn.Close()
+	ctx, cancel := context.WithTimeout(test.Context, time.Second)
+	defer cancel()
+	if _, err := surfstore.NewRaftSurfstoreClient(raftConn).Crash(ctx, &emptypb.Empty{}); err == nil {
+		t.Fatalf("Expected a client certificate to be rejected by a peer")
+	}
+}
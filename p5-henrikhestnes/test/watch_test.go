@@ -0,0 +1,321 @@
+package SurfTest
+
+import (
+	"context"
+	"cse224/proj5/pkg/surfstore"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestWatchSyncsChanges(t *testing.T) {
+	t.Logf("a watching client uploads local changes and picks up remote ones without being run again")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	watcher := surfstore.NewSyncWatcher(client1, 300*time.Millisecond, 100*time.Millisecond)
+	syncs := 0
+	watcher.OnSync = func(surfstore.SyncStats) { syncs++ }
+	result := make(chan error, 1)
+	go func() { result <- watcher.Run() }()
+
+	onServer := func(fileName string) bool {
+		state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+		return err == nil && state.MetaMap.FileInfoMap[fileName] != nil
+	}
+
+	// A local file, and one in a directory created after the watch started
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "local.txt"), []byte("local"), 0644); err != nil {
+		t.FailNow()
+	}
+	if !waitFor(5*time.Second, func() bool { return onServer("local.txt") }) {
+		t.Fatalf("Local file was not uploaded")
+	}
+	if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, "new"), 0755); err != nil {
+		t.FailNow()
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "new", "nested.txt"), []byte("nested"), 0644); err != nil {
+		t.FailNow()
+	}
+	if !waitFor(5*time.Second, func() bool { return onServer("new/nested.txt") }) {
+		t.Fatalf("File in a new directory was not uploaded")
+	}
+
+	// A remote file arrives with the next poll
+	if err := ioutil.WriteFile(filepath.Join(worker2.DirectoryName, "remote.txt"), []byte("remote"), 0644); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4))
+	if !waitFor(5*time.Second, func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(worker1.DirectoryName, "remote.txt"))
+		return err == nil && string(data) == "remote"
+	}) {
+		t.Fatalf("Remote file was not downloaded")
+	}
+
+	watcher.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if syncs < 3 {
+		t.Fatalf("Expected at least 3 syncs, got %d", syncs)
+	}
+}
+
+func TestWatchSurvivesServerFailure(t *testing.T) {
+	t.Logf("a watching client keeps running while the servers are down, and syncs once they are back")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer func() { EndTest(test) }()
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	defer worker1.CleanUp()
+
+	client1 := surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4)
+	watcher := surfstore.NewSyncWatcher(client1, 300*time.Millisecond, 100*time.Millisecond)
+	result := make(chan error, 1)
+	go func() { result <- watcher.Run() }()
+
+	onServer := func(fileName string) bool {
+		state, err := test.Clients[0].GetInternalState(test.Context, &emptypb.Empty{})
+		return err == nil && state.MetaMap.FileInfoMap[fileName] != nil
+	}
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "before.txt"), []byte("before"), 0644); err != nil {
+		t.FailNow()
+	}
+	if !waitFor(5*time.Second, func() bool { return onServer("before.txt") }) {
+		t.Fatalf("Local file was not uploaded")
+	}
+
+	// The first process is the BlockStore, the others are the Raft servers
+	for _, server := range test.Procs[1:] {
+		server.Process.Kill()
+		server.Wait()
+	}
+	if err := ioutil.WriteFile(filepath.Join(worker1.DirectoryName, "during.txt"), []byte("during"), 0644); err != nil {
+		t.FailNow()
+	}
+	// Let a few syncs fail
+	time.Sleep(time.Second)
+	select {
+	case err := <-result:
+		t.Fatalf("Watch stopped while the servers were down: %v", err)
+	default:
+	}
+
+	test.Procs = append(test.Procs[:1], InitRaftServers(cfgPath, "-b", "localhost:8080")...)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if !waitFor(10*time.Second, func() bool { return onServer("before.txt") && onServer("during.txt") }) {
+		t.Fatalf("Files were not uploaded once the servers were back")
+	}
+
+	watcher.Stop()
+	if err := <-result; err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+}
+
+func TestMetaStoreWatch(t *testing.T) {
+	metaStore := surfstore.NewMetaStore([]string{"localhost:8081"}, 0)
+	metaStore.ChangeLogLength = 2
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	surfstore.RegisterMetaStoreServer(grpcServer, metaStore)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Could not connect: %v", err)
+	}
+	defer conn.Close()
+	client := surfstore.NewMetaStoreClient(conn)
+
+	ctx := context.Background()
+	update := func(fileName string, version int32) {
+		metaStore.UpdateFile(ctx, &surfstore.FileMetaData{Filename: fileName, Version: version, BlockHashList: []string{"h"}})
+	}
+	update("a.txt", 1)
+	update("b.txt", 1)
+	update("a.txt", 2)
+	// Rejected updates are not changes
+	update("a.txt", 5)
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &surfstore.WatchRequest{FromIndex: 2, Epoch: metaStore.Epoch})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	expect := func(index int64, fileName string, version int32) {
+		change, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Expected change %d, got %v", index, err)
+		}
+		if change.Index != index || change.FileMetaData.Filename != fileName || change.FileMetaData.Version != version {
+			t.Fatalf("Expected change %d of %s to version %d, got %v", index, fileName, version, change)
+		}
+	}
+	expect(2, "b.txt", 1)
+	expect(3, "a.txt", 2)
+	update("c.txt", 1)
+	expect(4, "c.txt", 1)
+
+	// Index 1 was dropped from the change log
+	expired, err := client.Watch(ctx, &surfstore.WatchRequest{FromIndex: 1, Epoch: metaStore.Epoch})
+	if err == nil {
+		_, err = expired.Recv()
+	}
+	if err == nil || err == io.EOF || !strings.Contains(err.Error(), surfstore.ERR_CHANGES_EXPIRED.Error()) {
+		t.Fatalf("Expected the watch to fail with %v, got %v", surfstore.ERR_CHANGES_EXPIRED, err)
+	}
+
+	// So was every change of another epoch
+	expired, err = client.Watch(ctx, &surfstore.WatchRequest{FromIndex: 3, Epoch: metaStore.Epoch + 1})
+	if err == nil {
+		_, err = expired.Recv()
+	}
+	if err == nil || err == io.EOF || !strings.Contains(err.Error(), surfstore.ERR_CHANGES_EXPIRED.Error()) {
+		t.Fatalf("Expected a watch from another epoch to fail with %v, got %v", surfstore.ERR_CHANGES_EXPIRED, err)
+	}
+}
+
+func TestWatchFollowsLeader(t *testing.T) {
+	t.Logf("a watch is pushed the changes the leader accepts, and fails when the leader crashes")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	test.Clients[0].UpdateFile(test.Context, &surfstore.FileMetaData{Filename: "old.txt", Version: 1, BlockHashList: []string{"h"}})
+
+	client := surfstore.NewSurfstoreRPCClient(test.Ips, "", 4)
+	stop := make(chan struct{})
+	changes := make(chan *surfstore.FileChange, 10)
+	result := make(chan error, 1)
+	go func() { result <- client.Watch(nil, stop, changes) }()
+	time.Sleep(500 * time.Millisecond)
+
+	test.Clients[0].UpdateFile(test.Context, &surfstore.FileMetaData{Filename: "new.txt", Version: 1, BlockHashList: []string{"h"}})
+	select {
+	case change := <-changes:
+		if change.Index != 2 || change.FileMetaData.Filename != "new.txt" {
+			t.Fatalf("Expected change 2 of new.txt, got %v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No change was pushed")
+	}
+
+	test.Clients[0].Crash(test.Context, &emptypb.Empty{})
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatalf("Expected the watch to fail without a leader")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watch did not notice the crashed leader")
+	}
+	close(stop)
+}
+
+func TestWatchPushesRemoteChanges(t *testing.T) {
+	t.Logf("a watching client without polling picks up a remote change")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	watcher := surfstore.NewSyncWatcher(surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4), 0, 100*time.Millisecond)
+	go watcher.Run()
+	defer watcher.Stop()
+	time.Sleep(500 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filepath.Join(worker2.DirectoryName, "pushed.txt"), []byte("pushed"), 0644); err != nil {
+		t.FailNow()
+	}
+	surfstore.ClientSync(surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4))
+	if !waitFor(5*time.Second, func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(worker1.DirectoryName, "pushed.txt"))
+		return err == nil && string(data) == "pushed"
+	}) {
+		t.Fatalf("Remote file was not pushed")
+	}
+}
+
+func TestWatchPushesChangesDuringFirstSync(t *testing.T) {
+	t.Logf("a watching client without polling picks up a remote change made before its watch started")
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath, "8080")
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	if err := ioutil.WriteFile(filepath.Join(worker2.DirectoryName, "early.txt"), []byte("early"), 0644); err != nil {
+		t.FailNow()
+	}
+	watcher := surfstore.NewSyncWatcher(surfstore.NewSurfstoreRPCClient(test.Ips, worker1.DirectoryName, 4), 0, 100*time.Millisecond)
+	first := true
+	watcher.OnSync = func(surfstore.SyncStats) {
+		// The first sync is done, but the MetaStore is not watched yet
+		if first {
+			first = false
+			surfstore.ClientSync(surfstore.NewSurfstoreRPCClient(test.Ips, worker2.DirectoryName, 4))
+		}
+	}
+	go watcher.Run()
+	defer watcher.Stop()
+
+	if !waitFor(5*time.Second, func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(worker1.DirectoryName, "early.txt"))
+		return err == nil && string(data) == "early"
+	}) {
+		t.Fatalf("Remote file changed after the first sync was not pushed")
+	}
+}